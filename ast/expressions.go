@@ -14,6 +14,7 @@ type Expression interface {
 
 type Identifier struct {
 	Token token.Token
+	Span
 	Value string
 }
 
@@ -24,7 +25,8 @@ func (i *Identifier) String() string {
 }
 
 type PrefixExpression struct {
-	Token    token.Token
+	Token token.Token
+	Span
 	Operator string
 	Right    Expression
 }
@@ -36,7 +38,8 @@ func (p *PrefixExpression) String() string {
 }
 
 type InfixExpression struct {
-	Token    token.Token
+	Token token.Token
+	Span
 	Left     Expression
 	Operator string
 	Right    Expression
@@ -51,7 +54,8 @@ func (e *InfixExpression) String() string {
 // todo: postfix Expression
 
 type IfExpression struct {
-	Token       token.Token
+	Token token.Token
+	Span
 	Condition   Expression
 	Consequence *BlockStatement
 	Alternative *BlockStatement
@@ -64,7 +68,9 @@ func (i *IfExpression) String() string {
 	fmt.Fprintf(&sb, "if %s {\n", i.Condition)
 	fmt.Fprintf(&sb, "%s", i.Consequence.String())
 
-	if i.Alternative != nil {
+	if i.IsElseIf() {
+		fmt.Fprintf(&sb, "} else %s", i.Alternative.Statements[0])
+	} else if i.Alternative != nil {
 		fmt.Fprintf(&sb, "} else {\n%s}", i.Alternative.String())
 	} else {
 		sb.WriteString("}")
@@ -72,8 +78,14 @@ func (i *IfExpression) String() string {
 	return sb.String()
 }
 
+// IsElseIf reports whether the alternative is a chained `else if`
+func (i *IfExpression) IsElseIf() bool {
+	return i.Alternative != nil && i.Alternative.Token == token.If && len(i.Alternative.Statements) == 1
+}
+
 type WhileExpression struct {
-	Token     token.Token
+	Token token.Token
+	Span
 	Condition Expression
 	Body      *BlockStatement
 }
@@ -91,7 +103,8 @@ func (w *WhileExpression) String() string {
 }
 
 type ForExpression struct {
-	Token     token.Token
+	Token token.Token
+	Span
 	Init      Statement
 	Condition Statement
 	Increment Statement
@@ -109,7 +122,8 @@ func (f *ForExpression) String() string {
 }
 
 type CallExpression struct {
-	Token     token.Token
+	Token token.Token
+	Span
 	Function  Expression
 	Arguments []Expression
 }
@@ -129,8 +143,23 @@ func (c *CallExpression) String() string {
 	return sb.String()
 }
 
+// SelectorExpression is a name selected from a module, as in module.name
+type SelectorExpression struct {
+	Token token.Token
+	Span
+	Left Expression
+	Name *Identifier
+}
+
+func (s *SelectorExpression) expressionNode()      {}
+func (s *SelectorExpression) TokenLiteral() string { return s.Token.String() }
+func (s *SelectorExpression) String() string {
+	return s.Left.String() + "." + s.Name.String()
+}
+
 type IndexExpression struct {
 	Token token.Token
+	Span
 	Left  Expression
 	Index Expression
 }
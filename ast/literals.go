@@ -10,15 +10,17 @@ import (
 
 type StringLiteral struct {
 	Token token.Token
+	Span
 	Value string
 }
 
 func (l *StringLiteral) expressionNode()      {}
 func (l *StringLiteral) TokenLiteral() string { return l.Token.String() }
-func (l *StringLiteral) String() string       { return fmt.Sprintf(`"%s"`, l.Value) }
+func (l *StringLiteral) String() string       { return strconv.Quote(l.Value) }
 
 type CommentLiteral struct {
 	Token token.Token
+	Span
 	Value string
 }
 
@@ -28,6 +30,7 @@ func (l *CommentLiteral) String() string       { return fmt.Sprintf("%s %s", l.T
 
 type IntegerLiteral struct {
 	Token token.Token
+	Span
 	Value int64
 }
 
@@ -37,6 +40,7 @@ func (l *IntegerLiteral) String() string       { return strconv.FormatInt(l.Valu
 
 type FloatLiteral struct {
 	Token token.Token
+	Span
 	Value float64
 }
 
@@ -46,6 +50,7 @@ func (l *FloatLiteral) String() string       { return strconv.FormatFloat(l.Valu
 
 type BooleanLiteral struct {
 	Token token.Token
+	Span
 	Value bool
 }
 
@@ -54,7 +59,11 @@ func (l *BooleanLiteral) TokenLiteral() string { return l.Token.String() }
 func (l *BooleanLiteral) String() string       { return strconv.FormatBool(l.Value) }
 
 type FunctionLiteral struct {
-	Token      token.Token
+	Token token.Token
+	Span
+	// Name is the name the function was declared with, if any. It is only
+	// used for debug info, such as stack traces.
+	Name       string
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
@@ -76,7 +85,8 @@ func (f *FunctionLiteral) String() string {
 }
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token token.Token
+	Span
 	Elements []Expression
 }
 
@@ -96,6 +106,7 @@ func (a *ArrayLiteral) String() string {
 
 type MapLiteral struct {
 	Token token.Token
+	Span
 	Pairs map[Expression]Expression
 }
 
@@ -2,13 +2,29 @@ package ast
 
 import (
 	"strings"
+
+	"github.com/jimmykodes/joker/token"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos returns the position of the first character of the node
+	Pos() token.Position
+	// End returns the position immediately after the node
+	End() token.Position
+}
+
+// Span is the range of the source a node was parsed from. It is embedded in
+// every node to implement Pos and End.
+type Span struct {
+	Start token.Position
+	Stop  token.Position
 }
 
+func (s Span) Pos() token.Position { return s.Start }
+func (s Span) End() token.Position { return s.Stop }
+
 type Program struct {
 	Statements []Statement
 }
@@ -20,6 +36,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var sb strings.Builder
 	for _, statement := range p.Statements {
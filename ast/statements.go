@@ -14,6 +14,7 @@ type Statement interface {
 
 type DefineStatement struct {
 	Token token.Token
+	Span
 	Name  *Identifier
 	Value Expression
 }
@@ -26,6 +27,7 @@ func (ds *DefineStatement) String() string {
 
 type LetStatement struct {
 	Token token.Token
+	Span
 	Name  *Identifier
 	Value Expression
 }
@@ -38,8 +40,9 @@ func (ls *LetStatement) String() string {
 
 type FuncStatement struct {
 	Token token.Token
-	Name  *Identifier
-	Fn    *FunctionLiteral
+	Span
+	Name *Identifier
+	Fn   *FunctionLiteral
 }
 
 func (f *FuncStatement) statementNode()       {}
@@ -54,6 +57,7 @@ func (f *FuncStatement) String() string {
 
 type ReassignStatement struct {
 	Token token.Token
+	Span
 	Name  *Identifier
 	Value Expression
 }
@@ -64,8 +68,22 @@ func (rs *ReassignStatement) String() string {
 	return fmt.Sprintf("%s %s %s", rs.Name.Value, rs.Token.String(), rs.Value.String())
 }
 
+type IndexAssignStatement struct {
+	Token token.Token
+	Span
+	Target *IndexExpression
+	Value  Expression
+}
+
+func (is *IndexAssignStatement) statementNode()       {}
+func (is *IndexAssignStatement) TokenLiteral() string { return is.Token.String() }
+func (is *IndexAssignStatement) String() string {
+	return fmt.Sprintf("%s %s %s", is.Target.String(), is.Token.String(), is.Value.String())
+}
+
 type ReturnStatement struct {
 	Token token.Token
+	Span
 	Value Expression
 }
 
@@ -83,8 +101,61 @@ func (rs *ReturnStatement) String() string {
 	return sb.String()
 }
 
+// TryStatement runs Body, and if it raises an error, runs Catch with the error
+// assigned to Name. Name is nil when the error isn't needed.
+type TryStatement struct {
+	Token token.Token
+	Span
+	Body  *BlockStatement
+	Name  *Identifier
+	Catch *BlockStatement
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.String() }
+func (ts *TryStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("try {\n")
+	sb.WriteString(ts.Body.String())
+	sb.WriteString("} catch ")
+	if ts.Name != nil {
+		sb.WriteString(ts.Name.Value + " ")
+	}
+	sb.WriteString("{\n")
+	sb.WriteString(ts.Catch.String())
+	sb.WriteString("}")
+	return sb.String()
+}
+
+type RaiseStatement struct {
+	Token token.Token
+	Span
+	Value Expression
+}
+
+func (rs *RaiseStatement) statementNode()       {}
+func (rs *RaiseStatement) TokenLiteral() string { return rs.Token.String() }
+func (rs *RaiseStatement) String() string {
+	return fmt.Sprintf("%s %s;", rs.TokenLiteral(), rs.Value)
+}
+
+// ImportStatement makes the globals of the module at Path available to the
+// importing file, as name.global
+type ImportStatement struct {
+	Token token.Token
+	Span
+	Path *StringLiteral
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.String() }
+func (is *ImportStatement) String() string {
+	return fmt.Sprintf("%s %s;", is.TokenLiteral(), is.Path)
+}
+
 type ContinueStatement struct {
 	Token token.Token
+	Span
 }
 
 func (c *ContinueStatement) statementNode()       {}
@@ -93,6 +164,7 @@ func (c *ContinueStatement) String() string       { return "continue" }
 
 type BreakStatement struct {
 	Token token.Token
+	Span
 }
 
 func (b *BreakStatement) statementNode()       {}
@@ -100,7 +172,8 @@ func (b *BreakStatement) TokenLiteral() string { return b.Token.String() }
 func (b *BreakStatement) String() string       { return "break" }
 
 type ExpressionStatement struct {
-	Token      token.Token
+	Token token.Token
+	Span
 	Expression Expression
 }
 
@@ -109,7 +182,8 @@ func (es *ExpressionStatement) TokenLiteral() string { return es.Token.String()
 func (es *ExpressionStatement) String() string       { return es.Expression.String() }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token token.Token
+	Span
 	Statements []Statement
 }
 
@@ -24,12 +24,15 @@ func _() {
 	_ = x[Readline-13]
 	_ = x[Write-14]
 	_ = x[Close-15]
-	_ = x[end-16]
+	_ = x[Eprint-16]
+	_ = x[Input-17]
+	_ = x[Error-18]
+	_ = x[end-19]
 }
 
-const _builtin_name = "startintfloatstringlenpopprintappendsetsliceargvopenreadreadlinewritecloseend"
+const _builtin_name = "startintfloatstringlenpopprintappendsetsliceargvopenreadreadlinewritecloseeprintinputerrorend"
 
-var _builtin_index = [...]uint8{0, 5, 8, 13, 19, 22, 25, 30, 36, 39, 44, 48, 52, 56, 64, 69, 74, 77}
+var _builtin_index = [...]uint8{0, 5, 8, 13, 19, 22, 25, 30, 36, 39, 44, 48, 52, 56, 64, 69, 74, 80, 85, 90, 93}
 
 func (i builtin) String() string {
 	if i < 0 || i >= builtin(len(_builtin_index)-1) {
@@ -2,7 +2,8 @@ package builtins
 
 import (
 	"fmt"
-	"os"
+	"io"
+	"io/fs"
 	"strconv"
 
 	"github.com/jimmykodes/joker/object"
@@ -12,6 +13,21 @@ func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// charge charges the size of a newly allocated obj to rt. If that exceeds
+// the memory limit, the error is returned in place of obj.
+func charge(rt *object.Runtime, obj object.Object) object.Object {
+	if err := rt.Charge(object.SizeOf(obj)); err != nil {
+		return object.ErrorFromGo(err)
+	}
+	return obj
+}
+
+// raise wraps err in an Exception, for the builtins that can't raise an
+// error by returning it
+func raise(err *object.Error) *object.Exception {
+	return &object.Exception{Err: err}
+}
+
 func nArgs(n int, args []object.Object) *object.Error {
 	if len(args) != n {
 		return newError("invalid number of args: got %d - want %d", len(args), n)
@@ -39,6 +55,9 @@ const (
 	Readline         // readline
 	Write            // write
 	Close            // close
+	Eprint           // eprint
+	Input            // input
+	Error            // error
 	end
 )
 
@@ -69,10 +88,53 @@ func LookupFunc(name string) (*object.Builtin, bool) {
 	return builtins[val], ok
 }
 
+// Raised returns the error raised by res, the result of calling b, if any.
+// Builtins raise an error by returning it, except for error() and pop(),
+// which can return errors as values, and so raise them by wrapping them in
+// an Exception.
+func Raised(b *object.Builtin, res object.Object) (*object.Error, bool) {
+	switch res := res.(type) {
+	case *object.Exception:
+		return res.Err, true
+	case *object.Error:
+		return res, b != builtins[Error] && b != builtins[Pop]
+	default:
+		return nil, false
+	}
+}
+
+// fprint writes args to w separated by spaces, the way print does
+func fprint(w io.Writer, args []object.Object) {
+	out := make([]any, len(args))
+	for i, arg := range args {
+		if s, ok := arg.(object.Stringer); ok {
+			out[i] = s.String()
+		} else {
+			out[i] = arg.Inspect()
+		}
+	}
+	fmt.Fprintln(w, out...)
+}
+
+// readline reads a line from stdin, returning nil once there is no more input
+func readline(rt *object.Runtime) object.Object {
+	if err := rt.Permissions().CheckStdin(); err != nil {
+		return object.ErrorFromGo(err)
+	}
+	line, err := rt.ReadLine()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return object.ErrorFromGo(err)
+	}
+	return charge(rt, &object.String{Value: line})
+}
+
 var builtins = [...]*object.Builtin{
 	Int: {
 		Name: Int.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if errOb := nArgs(1, args); errOb != nil {
 				return errOb
 			}
@@ -99,7 +161,7 @@ var builtins = [...]*object.Builtin{
 	},
 	Float: {
 		Name: Float.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if errOb := nArgs(1, args); errOb != nil {
 				return errOb
 			}
@@ -121,15 +183,15 @@ var builtins = [...]*object.Builtin{
 	},
 	String: {
 		Name: String.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if errOb := nArgs(1, args); errOb != nil {
 				return errOb
 			}
 			switch a := args[0].(type) {
 			case *object.Integer:
-				return &object.String{Value: strconv.FormatInt(a.Value, 10)}
+				return charge(rt, &object.String{Value: strconv.FormatInt(a.Value, 10)})
 			case *object.Float:
-				return &object.String{Value: fmt.Sprintf("%v", a.Value)}
+				return charge(rt, &object.String{Value: fmt.Sprintf("%v", a.Value)})
 			case *object.String:
 				return a
 			default:
@@ -139,7 +201,7 @@ var builtins = [...]*object.Builtin{
 	},
 	Len: {
 		Name: Len.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if errOb := nArgs(1, args); errOb != nil {
 				return errOb
 			}
@@ -154,17 +216,17 @@ var builtins = [...]*object.Builtin{
 		Name: Pop.String(),
 		// TODO: create a "popable" interface and have this compare the object to the interface
 		// implement the interface on both maps and slices
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if err := nArgs(2, args); err != nil {
-				return err
+				return raise(err)
 			}
 			m, ok := args[0].(*object.Map)
 			if !ok {
-				return newError("invalid type for pop. got %s, want %s", args[0].Type(), object.MapType)
+				return raise(newError("invalid type for pop. got %s, want %s", args[0].Type(), object.MapType))
 			}
 			k, ok := args[1].(object.Hashable)
 			if !ok {
-				return newError("invalid key type")
+				return raise(newError("invalid key type"))
 			}
 			obj, ok := m.Pairs[k.HashKey()]
 			if !ok {
@@ -176,22 +238,17 @@ var builtins = [...]*object.Builtin{
 	},
 	Print: {
 		Name: Print.String(),
-		Fn: func(args ...object.Object) object.Object {
-			out := make([]any, len(args))
-			for i, arg := range args {
-				if s, ok := arg.(object.Stringer); ok {
-					out[i] = s.String()
-				} else {
-					out[i] = arg.Inspect()
-				}
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if err := rt.Permissions().CheckStdout(); err != nil {
+				return object.ErrorFromGo(err)
 			}
-			fmt.Fprintln(os.Stdout, out...)
+			fprint(rt.Stdout(), args)
 			return nil
 		},
 	},
 	Append: {
 		Name: Append.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) < 2 {
 				return newError("invalid number of args, got %d, want 2+", len(args))
 			}
@@ -199,12 +256,15 @@ var builtins = [...]*object.Builtin{
 			if !ok {
 				return newError("first argument of append must be an %s", object.ArrayType)
 			}
+			if err := rt.Charge(object.ArraySize(len(source.Elements) + len(args) - 1)); err != nil {
+				return object.ErrorFromGo(err)
+			}
 			return &object.Array{Elements: append(source.Elements, args[1:]...)}
 		},
 	},
 	Set: {
 		Name: Set.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 3 {
 				return newError("invalid number of args, got %d, want 3", len(args))
 			}
@@ -214,12 +274,15 @@ var builtins = [...]*object.Builtin{
 			if !ok {
 				return newError("invalid object: %T is not Settable", obj)
 			}
+			if err := rt.Charge(object.SetSize(obj, args[1])); err != nil {
+				return object.ErrorFromGo(err)
+			}
 			return settable.Set(args[1], args[2])
 		},
 	},
 	Slice: {
 		Name: Slice.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			var (
 				source object.Object
 				start  int64
@@ -246,11 +309,16 @@ var builtins = [...]*object.Builtin{
 				return newError("starting point of slice cannot be negative")
 			}
 
+			// slices share memory with their source, so only the new array
+			// header is charged, and substrings aren't charged at all
 			switch src := source.(type) {
 			case *object.Array:
 				if start > int64(len(src.Elements)) || end > int64(len(src.Elements)) {
 					return newError("index out of range [%d] with length %d", end, len(src.Elements))
 				}
+				if err := rt.Charge(object.ArraySize(0)); err != nil {
+					return object.ErrorFromGo(err)
+				}
 				return &object.Array{Elements: src.Elements[start:end]}
 			case *object.String:
 				if start > int64(len(src.Value)) || end > int64(len(src.Value)) {
@@ -264,32 +332,35 @@ var builtins = [...]*object.Builtin{
 	},
 	Argv: {
 		Name: Argv.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 0 {
 				return newError("invalid number of args, got %d, want 0", len(args))
 			}
-			argv := os.Args
-			elements := make([]object.Object, len(os.Args))
+			argv, err := rt.Permissions().Argv()
+			if err != nil {
+				return object.ErrorFromGo(err)
+			}
+			elements := make([]object.Object, len(argv))
 			for i, arg := range argv {
 				elements[i] = &object.String{Value: arg}
 			}
-			return &object.Array{Elements: elements}
+			return charge(rt, &object.Array{Elements: elements})
 		},
 	},
 	Open: {
 		Name: Open.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			switch len(args) {
 			case 1:
 				if args[0].Type() != object.StringType {
 					return newError("invalid type for file name: must be %s - got %s", object.StringType, args[0].Type())
 				}
 				filename := args[0].(*object.String)
-				f, err := os.Open(filename.Value)
+				f, err := rt.Permissions().Open(filename.Value)
 				if err != nil {
 					return object.ErrorFromGo(err)
 				}
-				return &object.File{Value: f}
+				return &object.File{Name: filename.Value, Value: f}
 			case 2:
 				if args[0].Type() != object.StringType {
 					return newError("invalid type for file name: must be %s - got %s", object.StringType, args[0].Type())
@@ -301,24 +372,27 @@ var builtins = [...]*object.Builtin{
 				var (
 					filename = args[0].(*object.String)
 					mode     = args[1].(*object.String)
+					perms    = rt.Permissions()
 					state    = object.UnknownFileState
-					f        *os.File
+					f        fs.File
 					err      error
 				)
 				switch mode.Value {
 				case "r":
-					f, err = os.Open(filename.Value)
+					f, err = perms.Open(filename.Value)
 				case "w":
 					state = object.WriteFileState
-					f, err = os.Create(filename.Value)
+					f, err = perms.Create(filename.Value)
 				case "a":
 					state = object.WriteFileState
-					f, err = os.OpenFile(filename.Value, os.O_APPEND|os.O_WRONLY, 0)
+					f, err = perms.Append(filename.Value)
+				default:
+					return newError("invalid file mode: must be r, w or a - got %s", mode.Value)
 				}
 				if err != nil {
 					return object.ErrorFromGo(err)
 				}
-				return &object.File{Value: f, State: state}
+				return &object.File{Name: filename.Value, Value: f, State: state}
 
 			default:
 				return newError("invalid number of args, got %d, want 1 or 2", len(args))
@@ -327,7 +401,7 @@ var builtins = [...]*object.Builtin{
 	},
 	Read: {
 		Name: Read.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("invalid number of args, got %d, want 1", len(args))
 			}
@@ -335,25 +409,29 @@ var builtins = [...]*object.Builtin{
 			if !ok {
 				return newError("invalid type: cannot read from %s", args[0].Type())
 			}
-			return reader.Read()
+			return charge(rt, reader.Read())
 		},
 	},
 	Readline: {
 		Name: Readline.String(),
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("invalid number of args, got %d, want 1", len(args))
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			switch len(args) {
+			case 0:
+				return readline(rt)
+			case 1:
+			default:
+				return newError("invalid number of args, got %d, want 0 or 1", len(args))
 			}
 			reader, ok := args[0].(object.Readliner)
 			if !ok {
 				return newError("invalid type: cannot readline from %s", args[0].Type())
 			}
-			return reader.Readline()
+			return charge(rt, reader.Readline())
 		},
 	},
 	Write: {
 		Name: Write.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 2 {
 				return newError("invalid number of args, got %d, want 2", len(args))
 			}
@@ -366,7 +444,7 @@ var builtins = [...]*object.Builtin{
 	},
 	Close: {
 		Name: Close.String(),
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("invalid number of args, got %d, want 1", len(args))
 			}
@@ -377,4 +455,62 @@ var builtins = [...]*object.Builtin{
 			return reader.Close()
 		},
 	},
+	Eprint: {
+		Name: Eprint.String(),
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if err := rt.Permissions().CheckStderr(); err != nil {
+				return object.ErrorFromGo(err)
+			}
+			fprint(rt.Stderr(), args)
+			return nil
+		},
+	},
+	Input: {
+		Name: Input.String(),
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			switch len(args) {
+			case 0:
+			case 1:
+				prompt, ok := args[0].(*object.String)
+				if !ok {
+					return newError("invalid type for prompt: must be %s - got %s", object.StringType, args[0].Type())
+				}
+				if err := rt.Permissions().CheckStdout(); err != nil {
+					return object.ErrorFromGo(err)
+				}
+				fmt.Fprint(rt.Stdout(), prompt.Value)
+			default:
+				return newError("invalid number of args, got %d, want 0 or 1", len(args))
+			}
+			return readline(rt)
+		},
+	},
+	Error: {
+		Name: Error.String(),
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return raise(newError("invalid number of args, got %d, want 1 to 3", len(args)))
+			}
+			msg, ok := args[0].(*object.String)
+			if !ok {
+				return raise(newError("invalid type for error message: must be %s - got %s", object.StringType, args[0].Type()))
+			}
+			errOb := &object.Error{Message: msg.Value}
+			if len(args) > 1 {
+				kind, ok := args[1].(*object.String)
+				if !ok {
+					return raise(newError("invalid type for error kind: must be %s - got %s", object.StringType, args[1].Type()))
+				}
+				errOb.Kind = kind.Value
+			}
+			if len(args) > 2 {
+				cause, ok := args[2].(*object.Error)
+				if !ok {
+					return raise(newError("invalid type for error cause: must be %s - got %s", object.ErrorType, args[2].Type()))
+				}
+				errOb.Cause = cause
+			}
+			return errOb
+		},
+	},
 }
@@ -0,0 +1,161 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jimmykodes/joker/object"
+	"github.com/jimmykodes/joker/token"
+)
+
+var (
+	ErrInvalidName   = errors.New("invalid name")
+	ErrAlreadyExists = errors.New("already registered")
+)
+
+// Registry holds native functions provided by the program embedding joker.
+// Functions are either registered on their own, and called by name like any
+// builtin, or grouped into a module and called as module.name.
+//
+// Functions are referenced by index in compiled bytecode, so the VM running a
+// program must be given a registry with the same functions, registered in the
+// same order, as the one used to compile it.
+type Registry struct {
+	mu      sync.RWMutex
+	funcs   []*object.Builtin
+	lookups map[string]int
+	modules map[string]struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		lookups: make(map[string]int),
+		modules: make(map[string]struct{}),
+	}
+}
+
+// Register adds a function that scripts call by name.
+func (r *Registry) Register(name string, fn object.BuiltinFunction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkName(name); err != nil {
+		return err
+	}
+	if _, ok := r.modules[name]; ok {
+		return fmt.Errorf("%w: %s is a module", ErrAlreadyExists, name)
+	}
+	if fn == nil {
+		return fmt.Errorf("%w: %s has no function", ErrInvalidName, name)
+	}
+	r.add(name, fn)
+	return nil
+}
+
+// RegisterModule adds functions to the named module, creating it if needed.
+// Scripts call them as module.name.
+func (r *Registry) RegisterModule(module string, funcs map[string]object.BuiltinFunction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.modules[module]; !ok {
+		if err := r.checkName(module); err != nil {
+			return err
+		}
+	}
+	for name, fn := range funcs {
+		if !isIdent(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidName, name)
+		}
+		if _, ok := r.lookups[module+"."+name]; ok {
+			return fmt.Errorf("%w: %s.%s", ErrAlreadyExists, module, name)
+		}
+		if fn == nil {
+			return fmt.Errorf("%w: %s.%s has no function", ErrInvalidName, module, name)
+		}
+	}
+
+	r.modules[module] = struct{}{}
+	for _, name := range sortedKeys(funcs) {
+		r.add(module+"."+name, funcs[name])
+	}
+	return nil
+}
+
+// Lookup returns the index of the function registered as name, which is
+// either a plain name or module.name.
+func (r *Registry) Lookup(name string) (int, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i, ok := r.lookups[name]
+	return i, ok
+}
+
+// IsModule reports whether name is a registered module
+func (r *Registry) IsModule(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.modules[name]
+	return ok
+}
+
+// Func returns the function at index i
+func (r *Registry) Func(i int) (*object.Builtin, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if i < 0 || i >= len(r.funcs) {
+		return nil, false
+	}
+	return r.funcs[i], true
+}
+
+func (r *Registry) add(name string, fn object.BuiltinFunction) {
+	r.lookups[name] = len(r.funcs)
+	r.funcs = append(r.funcs, &object.Builtin{Name: name, Fn: fn})
+}
+
+// checkName validates a name for a function or module in the global
+// namespace, where it can't shadow builtins or anything already registered.
+func (r *Registry) checkName(name string) error {
+	if !isIdent(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if _, ok := Lookup(name); ok {
+		return fmt.Errorf("%w: %s is a builtin", ErrAlreadyExists, name)
+	}
+	if _, ok := r.lookups[name]; ok {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, name)
+	}
+	return nil
+}
+
+// isIdent reports whether name would be lexed as an identifier
+func isIdent(name string) bool {
+	if name == "" || token.Lookup(name) != token.Ident {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z':
+		case ch == '_' || '0' <= ch && ch <= '9':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]object.BuiltinFunction) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
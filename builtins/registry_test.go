@@ -0,0 +1,70 @@
+package builtins
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jimmykodes/joker/object"
+)
+
+func TestRegistry(t *testing.T) {
+	noop := func(rt *object.Runtime, args ...object.Object) object.Object { return nil }
+
+	r := NewRegistry()
+	if err := r.Register("double", noop); err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+	if err := r.RegisterModule("math", map[string]object.BuiltinFunction{"sqrt": noop, "abs": noop}); err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+	if err := r.RegisterModule("math", map[string]object.BuiltinFunction{"pow": noop}); err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"double", ErrAlreadyExists},
+		{"math", ErrAlreadyExists},
+		{"len", ErrAlreadyExists},
+		{"let", ErrInvalidName},
+		{"1x", ErrInvalidName},
+		{"_x", ErrInvalidName},
+		{"math.floor", ErrInvalidName},
+		{"", ErrInvalidName},
+	}
+	for _, tt := range tests {
+		if err := r.Register(tt.name, noop); !errors.Is(err, tt.err) {
+			t.Errorf("invalid error registering %q: got %v - want %s", tt.name, err, tt.err)
+		}
+	}
+	if err := r.RegisterModule("math", map[string]object.BuiltinFunction{"abs": noop}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("invalid error registering math.abs: got %v - want %s", err, ErrAlreadyExists)
+	}
+	if err := r.RegisterModule("double", map[string]object.BuiltinFunction{"x": noop}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("invalid error registering module double: got %v - want %s", err, ErrAlreadyExists)
+	}
+
+	lookups := map[string]int{"double": 0, "math.abs": 1, "math.sqrt": 2, "math.pow": 3}
+	for name, want := range lookups {
+		got, ok := r.Lookup(name)
+		if !ok || got != want {
+			t.Errorf("invalid lookup for %s: got %d, %t - want %d", name, got, ok, want)
+			continue
+		}
+		fn, ok := r.Func(got)
+		if !ok || fn.Name != name {
+			t.Errorf("invalid func for %s: got %v", name, fn)
+		}
+	}
+	if _, ok := r.Lookup("math"); ok {
+		t.Errorf("modules should not be callable")
+	}
+	if !r.IsModule("math") || r.IsModule("double") {
+		t.Errorf("invalid modules")
+	}
+	if _, ok := r.Func(4); ok {
+		t.Errorf("expected no func at index 4")
+	}
+}
@@ -1,7 +1,10 @@
 package build
 
 import (
+	"errors"
+	"flag"
 	"os"
+	"path/filepath"
 
 	"github.com/jimmykodes/joker/compiler"
 	"github.com/jimmykodes/joker/lexer"
@@ -10,6 +13,17 @@ import (
 
 func Cmd() func(args []string) error {
 	return func(args []string) error {
+		fs := flag.NewFlagSet("build", flag.ContinueOnError)
+		modules := fs.Bool("modules", false, "build each imported module into a .jkb file of its own, instead of linking them into one")
+		var opts compiler.EncodeOptions
+		fs.BoolVar(&opts.Compact, "compact", false, "write integers as varints and share strings between constants, for smaller files")
+		fs.BoolVar(&opts.Deflate, "deflate", false, "compress the contents of the file")
+		optimize := fs.Bool("O", false, "optimize the program, folding expressions of literals into constants and removing unneeded instructions")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		args = fs.Args()
+
 		filename := "main.jk"
 		if len(args) > 0 {
 			filename = args[0]
@@ -21,31 +35,52 @@ func Cmd() func(args []string) error {
 			return err
 		}
 
-		l := lexer.New(string(data))
+		l := lexer.New(string(data), lexer.WithFilename(filename))
 		p := parser.New(l)
 		prog := p.ParseProgram()
-		c := compiler.New()
-
-		if err := c.Compile(prog); err != nil {
+		if err := errors.Join(p.Errors()...); err != nil {
 			return err
 		}
+		c := compiler.New(
+			compiler.WithFilename(filename),
+			compiler.WithSearchPath(filepath.SplitList(os.Getenv("JOKERPATH"))...),
+			compiler.WithOptimize(*optimize),
+		)
 
-		outFilename := filename + "b"
-		outFile, err := os.Create(outFilename)
-		if err != nil {
+		if err := c.Compile(prog); err != nil {
 			return err
 		}
-		defer outFile.Close()
 
-		data, err = c.Bytecode().MarshalBinary()
-		if err != nil {
-			return err
+		if !*modules {
+			bytecode, err := c.Link()
+			if err != nil {
+				return err
+			}
+			return write(filename+"b", bytecode, opts)
 		}
 
-		if _, err := outFile.Write(data); err != nil {
-			return err
+		// modules are written next to the main file, where run looks for them
+		dir := filepath.Dir(filename)
+		for _, mod := range c.Modules() {
+			name := filepath.FromSlash(mod.Modules[0].Name)
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(dir, name)
+			}
+			if err := write(name+"b", mod, opts); err != nil {
+				return err
+			}
 		}
+		return write(filename+"b", c.Bytecode(), opts)
+	}
+}
 
-		return nil
+func write(filename string, bytecode *compiler.Bytecode, opts compiler.EncodeOptions) error {
+	data, err := bytecode.Encode(opts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
 	}
+	return os.WriteFile(filename, data, 0o644)
 }
@@ -1,6 +1,7 @@
 package bytecode
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -24,10 +25,15 @@ func Cmd() func(args []string) error {
 				return err
 			}
 
+			h, err := compiler.ReadHeader(data)
+			if err != nil {
+				return err
+			}
 			var bc compiler.Bytecode
 			if err := bc.UnmarshalBinary(data); err != nil {
 				return err
 			}
+			fmt.Println(h)
 			fmt.Println(bc)
 
 		case ".jk":
@@ -36,14 +42,21 @@ func Cmd() func(args []string) error {
 				return err
 			}
 
-			l := lexer.New(string(data))
+			l := lexer.New(string(data), lexer.WithFilename(filename))
 			p := parser.New(l)
 			prog := p.ParseProgram()
-			c := compiler.New()
+			if err := errors.Join(p.Errors()...); err != nil {
+				return err
+			}
+			c := compiler.New(compiler.WithFilename(filename), compiler.WithSearchPath(filepath.SplitList(os.Getenv("JOKERPATH"))...))
 			if err := c.Compile(prog); err != nil {
 				return err
 			}
-			fmt.Println(c.Bytecode())
+			bytecode, err := c.Link()
+			if err != nil {
+				return err
+			}
+			fmt.Println(bytecode)
 		default:
 			return fmt.Errorf("invalid filetype: %s", ext)
 		}
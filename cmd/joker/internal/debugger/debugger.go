@@ -1,6 +1,7 @@
 package debugger
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -31,22 +32,38 @@ func Cmd() func(args []string) error {
 			if err := bc.UnmarshalBinary(data); err != nil {
 				return err
 			}
+			bytecode := &bc
+			if !bc.Linked() {
+				// built with -modules, so the modules it imports are in files of
+				// their own
+				loader := compiler.FileLoader(filepath.Dir(filename), filepath.SplitList(os.Getenv("JOKERPATH"))...)
+				if bytecode, err = compiler.Link(bytecode, loader); err != nil {
+					return err
+				}
+			}
 
-			machine = vm.New(&bc)
+			machine = vm.New(bytecode)
 		case ".jk":
 			data, err := os.ReadFile(filename)
 			if err != nil {
 				return err
 			}
 
-			l := lexer.New(string(data))
+			l := lexer.New(string(data), lexer.WithFilename(filename))
 			p := parser.New(l)
 			prog := p.ParseProgram()
-			c := compiler.New()
+			if err := errors.Join(p.Errors()...); err != nil {
+				return err
+			}
+			c := compiler.New(compiler.WithFilename(filename), compiler.WithSearchPath(filepath.SplitList(os.Getenv("JOKERPATH"))...))
 			if err := c.Compile(prog); err != nil {
 				return err
 			}
-			machine = vm.New(c.Bytecode())
+			bytecode, err := c.Link()
+			if err != nil {
+				return err
+			}
+			machine = vm.New(bytecode)
 
 		default:
 			return fmt.Errorf("invalid filetype: %s", ext)
@@ -24,7 +24,7 @@ func Cmd() func(args []string) error {
 			return err
 		}
 
-		l := lexer.New(string(data))
+		l := lexer.New(string(data), lexer.WithFilename(filename))
 		p := parser.New(l)
 		prog := p.ParseProgram()
 
@@ -37,7 +37,7 @@ func Cmd() func(args []string) error {
 
 		fmt.Println(res)
 
-		if res.Type() == object.ErrorType {
+		if res.Type() == object.ExceptionType {
 			return fmt.Errorf(res.Inspect())
 		}
 
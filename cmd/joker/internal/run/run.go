@@ -31,8 +31,17 @@ func Cmd() func(args []string) error {
 			if err := bc.UnmarshalBinary(data); err != nil {
 				return err
 			}
+			bytecode := &bc
+			if !bc.Linked() {
+				// built with -modules, so the modules it imports are in files of
+				// their own
+				loader := compiler.FileLoader(filepath.Dir(filename), filepath.SplitList(os.Getenv("JOKERPATH"))...)
+				if bytecode, err = compiler.Link(bytecode, loader); err != nil {
+					return err
+				}
+			}
 
-			machine := vm.New(&bc)
+			machine := vm.New(bytecode)
 			return machine.Run()
 		case ".jk":
 			data, err := os.ReadFile(filename)
@@ -40,18 +49,22 @@ func Cmd() func(args []string) error {
 				return err
 			}
 
-			l := lexer.New(string(data))
+			l := lexer.New(string(data), lexer.WithFilename(filename))
 			p := parser.New(l)
 			prog := p.ParseProgram()
 			if err := errors.Join(p.Errors()...); err != nil {
 				return err
 			}
 
-			c := compiler.New()
+			c := compiler.New(compiler.WithFilename(filename), compiler.WithSearchPath(filepath.SplitList(os.Getenv("JOKERPATH"))...))
 			if err := c.Compile(prog); err != nil {
 				return err
 			}
-			machine := vm.New(c.Bytecode())
+			bytecode, err := c.Link()
+			if err != nil {
+				return err
+			}
+			machine := vm.New(bytecode)
 			if err := machine.Run(); err != nil {
 				return err
 			}
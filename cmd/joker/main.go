@@ -53,10 +53,14 @@ func usage() {
 	fmt.Println("Usage:")
 	fmt.Println("\njoker command file")
 	fmt.Println("\nCommands:")
-	fmt.Println("  build          build a .jkb file from a .jk file")
+	fmt.Println("  build          build a .jkb file from a .jk file, with -modules each")
+	fmt.Println("                 imported module is built into a .jkb file of its own, -compact")
+	fmt.Println("                 and -deflate make smaller files, and -O optimizes the program")
 	fmt.Println("  run            run a .jk or .jkb file")
 	fmt.Println("  debug, d       run a .jk or .jkb file using an interactive debugger")
 	fmt.Println("  bytecode, bc   print the bytecode for a .jk file")
 	fmt.Println("  interpret, i   run a .jk file using the interpreter instead of compiler")
 	fmt.Println("  help, h        show this usage text")
+	fmt.Println("\nEnvironment:")
+	fmt.Println("  JOKERPATH      directories to look for imported modules in, separated like PATH")
 }
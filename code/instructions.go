@@ -9,9 +9,15 @@ import (
 type Instructions []byte
 
 func (ins *Instructions) UnmarshalBytes(data []byte) (int, error) {
-	lenIns := int(binary.BigEndian.Uint64(data))
+	if len(data) < 8 {
+		return 0, fmt.Errorf("invalid instructions: not enough data")
+	}
+	lenIns := binary.BigEndian.Uint64(data)
+	if lenIns > uint64(len(data)-8) {
+		return 0, fmt.Errorf("invalid instructions: not enough data")
+	}
 	*ins = data[8 : 8+lenIns]
-	return lenIns + 8, nil
+	return int(lenIns) + 8, nil
 }
 
 func (ins Instructions) MarshalBytes() ([]byte, error) {
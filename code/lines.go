@@ -0,0 +1,118 @@
+package code
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// LineEntry maps the instruction at Offset, and every instruction after it up
+// to the Offset of the next entry, to a line in the source file.
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// LineTable is the position table for a set of Instructions, sorted by Offset.
+type LineTable []LineEntry
+
+// Add records that the instruction at offset came from line. Entries are only
+// added when the line changes, so runs of instructions from the same line share
+// a single entry.
+func (lt *LineTable) Add(offset, line int) {
+	if n := len(*lt); n > 0 && (*lt)[n-1].Line == line {
+		return
+	}
+	*lt = append(*lt, LineEntry{Offset: offset, Line: line})
+}
+
+// Truncate drops all entries at or after offset, for use when the
+// instructions they describe have been removed.
+func (lt *LineTable) Truncate(offset int) {
+	n := len(*lt)
+	for n > 0 && (*lt)[n-1].Offset >= offset {
+		n--
+	}
+	*lt = (*lt)[:n]
+}
+
+// Line returns the source line of the instruction at offset, or 0 if unknown.
+func (lt LineTable) Line(offset int) int {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return lt[i-1].Line
+}
+
+func (lt *LineTable) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 8 {
+		return 0, fmt.Errorf("invalid line table: not enough data")
+	}
+	numEntries := binary.BigEndian.Uint64(data)
+	if numEntries > uint64(len(data)-8)/16 {
+		return 0, fmt.Errorf("invalid line table: not enough data")
+	}
+	size := 8 + int(numEntries)*16
+	*lt = nil
+	for i := 0; i < int(numEntries); i++ {
+		offset := 8 + i*16
+		*lt = append(*lt, LineEntry{
+			Offset: int(binary.BigEndian.Uint64(data[offset:])),
+			Line:   int(binary.BigEndian.Uint64(data[offset+8:])),
+		})
+	}
+	return size, nil
+}
+
+func (lt LineTable) MarshalBytes() ([]byte, error) {
+	out := make([]byte, 8+len(lt)*16)
+	binary.BigEndian.PutUint64(out, uint64(len(lt)))
+	for i, entry := range lt {
+		offset := 8 + i*16
+		binary.BigEndian.PutUint64(out[offset:], uint64(entry.Offset))
+		binary.BigEndian.PutUint64(out[offset+8:], uint64(entry.Line))
+	}
+	return out, nil
+}
+
+// MarshalCompact encodes the table with varints. Offsets and lines are stored
+// as the difference from the previous entry, which is usually small.
+func (lt LineTable) MarshalCompact() []byte {
+	var b [binary.MaxVarintLen64]byte
+	out := append([]byte(nil), b[:binary.PutUvarint(b[:], uint64(len(lt)))]...)
+	prev := LineEntry{}
+	for _, entry := range lt {
+		out = append(out, b[:binary.PutUvarint(b[:], uint64(entry.Offset-prev.Offset))]...)
+		out = append(out, b[:binary.PutVarint(b[:], int64(entry.Line-prev.Line))]...)
+		prev = entry
+	}
+	return out
+}
+
+// UnmarshalCompact decodes a table encoded with MarshalCompact, returning the
+// number of bytes read
+func (lt *LineTable) UnmarshalCompact(data []byte) (int, error) {
+	numEntries, ptr := binary.Uvarint(data)
+	// every entry takes at least two bytes
+	if ptr <= 0 || numEntries > uint64(len(data)-ptr)/2 {
+		return 0, fmt.Errorf("invalid line table: not enough data")
+	}
+	*lt = nil
+	prev := LineEntry{}
+	for i := 0; i < int(numEntries); i++ {
+		offset, n := binary.Uvarint(data[ptr:])
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid line table: invalid offset")
+		}
+		ptr += n
+		line, n := binary.Varint(data[ptr:])
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid line table: invalid line")
+		}
+		ptr += n
+		prev = LineEntry{Offset: prev.Offset + int(offset), Line: prev.Line + int(line)}
+		*lt = append(*lt, prev)
+	}
+	return ptr, nil
+}
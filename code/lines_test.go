@@ -0,0 +1,88 @@
+package code
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineTable(t *testing.T) {
+	var lt LineTable
+	lt.Add(0, 1)
+	lt.Add(3, 1)
+	lt.Add(4, 2)
+	lt.Add(7, 4)
+	lt.Add(9, 4)
+
+	want := LineTable{{0, 1}, {4, 2}, {7, 4}}
+	if !reflect.DeepEqual(lt, want) {
+		t.Fatalf("invalid line table: got %v - want %v", lt, want)
+	}
+
+	tests := []struct {
+		offset int
+		line   int
+	}{
+		{0, 1},
+		{3, 1},
+		{4, 2},
+		{6, 2},
+		{7, 4},
+		{100, 4},
+	}
+	for _, tt := range tests {
+		if got := lt.Line(tt.offset); got != tt.line {
+			t.Errorf("invalid line for offset %d: got %d - want %d", tt.offset, got, tt.line)
+		}
+	}
+
+	lt.Truncate(7)
+	if got := lt.Line(8); got != 2 {
+		t.Errorf("invalid line after truncate: got %d - want %d", got, 2)
+	}
+}
+
+func TestLineTableEncoding(t *testing.T) {
+	lt := LineTable{{0, 1}, {4, 2}, {7, 4}}
+	data, err := lt.MarshalBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got LineTable
+	read, err := got.UnmarshalBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != len(data) {
+		t.Errorf("invalid bytes read: got %d - want %d", read, len(data))
+	}
+	if !reflect.DeepEqual(got, lt) {
+		t.Errorf("invalid unmarshal: got %v - want %v", got, lt)
+	}
+}
+
+func TestLineTableCompactEncoding(t *testing.T) {
+	lt := LineTable{{0, 10}, {4, 2}, {300, 4}}
+	data := lt.MarshalCompact()
+	// the count, then two bytes for each entry, except the offset 296 that
+	// takes two
+	if want := 1 + 3*2 + 1; len(data) != want {
+		t.Errorf("invalid size: got %d - want %d", len(data), want)
+	}
+
+	var got LineTable
+	read, err := got.UnmarshalCompact(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != len(data) {
+		t.Errorf("invalid bytes read: got %d - want %d", read, len(data))
+	}
+	if !reflect.DeepEqual(got, lt) {
+		t.Errorf("invalid unmarshal: got %v - want %v", got, lt)
+	}
+
+	if _, err := got.UnmarshalCompact(data[:len(data)-1]); err == nil {
+		t.Errorf("expected an error for truncated data")
+	}
+}
@@ -57,6 +57,33 @@ const (
 	OpClosure
 	OpReturn
 
+	// Opcodes added since are appended below, so that the values of the
+	// opcodes before them don't change.
+
+	OpSetIndex
+	OpJumpTruthy
+	OpGetNative
+
+	// Errors
+	OpTry
+	OpEndTry
+	OpRaise
+
+	// OpTailCall calls a function in place of the one that is running, which
+	// returns whatever it does.
+	OpTailCall
+
+	// OpCaptureLocal and OpCaptureFree push the upvalue of a local or free
+	// variable, for OpClosure to give to the closure it makes. The closure
+	// then shares the variable with the function it was made in.
+	OpCaptureLocal
+	OpCaptureFree
+
+	// OpCloseUpvalues closes the upvalues of the locals from its operand up,
+	// so closures made in one iteration of a loop keep that iteration's
+	// variables rather than sharing them with the next.
+	OpCloseUpvalues
+
 	lastOpcode
 )
 
@@ -64,6 +91,7 @@ var opWidths = [lastOpcode][]int{
 	OpConstant:      {2},
 	OpJump:          {2},
 	OpJumpNotTruthy: {2},
+	OpJumpTruthy:    {2},
 	OpSetGlobal:     {2},
 	OpGetGlobal:     {2},
 	OpSetLocal:      {1},
@@ -73,8 +101,14 @@ var opWidths = [lastOpcode][]int{
 	OpArray:         {2},
 	OpMap:           {2},
 	OpCall:          {1},
+	OpTailCall:      {1},
+	OpCaptureLocal:  {1},
+	OpCaptureFree:   {1},
+	OpCloseUpvalues: {1},
 	OpGetBuiltin:    {1},
+	OpGetNative:     {2},
 	OpClosure:       {2, 1},
+	OpTry:           {2},
 }
 
 func OpWidths(op byte) ([]int, error) {
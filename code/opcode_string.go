@@ -39,12 +39,22 @@ func _() {
 	_ = x[OpGetBuiltin-28]
 	_ = x[OpClosure-29]
 	_ = x[OpReturn-30]
-	_ = x[lastOpcode-31]
+	_ = x[OpSetIndex-31]
+	_ = x[OpJumpTruthy-32]
+	_ = x[OpGetNative-33]
+	_ = x[OpTry-34]
+	_ = x[OpEndTry-35]
+	_ = x[OpRaise-36]
+	_ = x[OpTailCall-37]
+	_ = x[OpCaptureLocal-38]
+	_ = x[OpCaptureFree-39]
+	_ = x[OpCloseUpvalues-40]
+	_ = x[lastOpcode-41]
 }
 
-const _Opcode_name = "OpConstantOpPopOpAddOpSubOpMultOpDivOpModOpTrueOpFalseOpNullOpEQOpNEQOpGTOpGTEOpMinusOpBangOpJumpOpJumpNotTruthyOpSetGlobalOpGetGlobalOpSetLocalOpGetLocalOpGetFreeOpSetFreeOpArrayOpMapOpIndexOpCallOpGetBuiltinOpClosureOpReturnlastOpcode"
+const _Opcode_name = "OpConstantOpPopOpAddOpSubOpMultOpDivOpModOpTrueOpFalseOpNullOpEQOpNEQOpGTOpGTEOpMinusOpBangOpJumpOpJumpNotTruthyOpSetGlobalOpGetGlobalOpSetLocalOpGetLocalOpGetFreeOpSetFreeOpArrayOpMapOpIndexOpCallOpGetBuiltinOpClosureOpReturnOpSetIndexOpJumpTruthyOpGetNativeOpTryOpEndTryOpRaiseOpTailCallOpCaptureLocalOpCaptureFreeOpCloseUpvalueslastOpcode"
 
-var _Opcode_index = [...]uint8{0, 10, 15, 20, 25, 31, 36, 41, 47, 54, 60, 64, 69, 73, 78, 85, 91, 97, 112, 123, 134, 144, 154, 163, 172, 179, 184, 191, 197, 209, 218, 226, 236}
+var _Opcode_index = [...]uint16{0, 10, 15, 20, 25, 31, 36, 41, 47, 54, 60, 64, 69, 73, 78, 85, 91, 97, 112, 123, 134, 144, 154, 163, 172, 179, 184, 191, 197, 209, 218, 226, 236, 248, 259, 264, 272, 279, 289, 303, 316, 331, 341}
 
 func (i Opcode) String() string {
 	if i >= Opcode(len(_Opcode_index)-1) {
@@ -1,8 +1,9 @@
 package compiler
 
 import (
-	"encoding/binary"
 	"fmt"
+	"math"
+	"path/filepath"
 	"strings"
 
 	"github.com/jimmykodes/joker/ast"
@@ -13,33 +14,126 @@ import (
 
 type Scope struct {
 	instructions code.Instructions
+	lines        code.LineTable
 	// ultInst is the last (ultimate) instruction emitted
 	ultInst EmittedInstruction
 	// penultInst is the second to last (penultimate) instruction emitted
 	penultInst EmittedInstruction
 	startPos   int
 	setEndPos  []int
+	// tryDepth is the number of try blocks the current instruction is in, and
+	// loopTryDepth the number the innermost loop is in. Leaving a loop with
+	// break or continue leaves the try blocks in between.
+	tryDepth     int
+	loopTryDepth int
+	// loopLocals is the first local defined in the body of the innermost
+	// loop. The upvalues of the body's locals are closed each time it ends.
+	loopLocals int
 }
 
 type Compiler struct {
 	constants   []object.Object
 	symbolTable *SymbolTable
+	// constantIndex maps the keys of constants that can be shared to their
+	// index, see addConstant
+	constantIndex map[any]int
 
 	scopes []*Scope
+	// line is the source line of the statement currently being compiled
+	line int
+
+	// registry holds the native functions available to the program, if any
+	registry *builtins.Registry
+
+	// filename is the file being compiled, which imports are resolved from,
+	// and name is its name in the module table
+	filename string
+	name     string
+	// searchPath holds the directories that imports not found next to the
+	// importing file are looked for in
+	searchPath []string
+	// modules holds every module of the program, and is shared with the
+	// compilers of the modules this file imports
+	modules *moduleSet
+	// requires are the names of the modules this file imports, and imports
+	// the globals of those modules it uses
+	requires []string
+	imports  []Import
+
+	// optimize enables optimizations, see WithOptimize
+	optimize bool
 }
 
-func New() *Compiler {
-	return &Compiler{
-		symbolTable: NewSymbolTable(),
-		scopes:      []*Scope{{}},
+type Option func(*Compiler)
+
+// WithRegistry makes the native functions in r available to the program. The
+// VM running the program must use the same registry.
+func WithRegistry(r *builtins.Registry) Option {
+	return func(c *Compiler) {
+		c.registry = r
+	}
+}
+
+// WithFilename sets the name of the file being compiled. Imports are looked
+// for relative to its directory, or the working directory if it isn't set.
+func WithFilename(filename string) Option {
+	return func(c *Compiler) {
+		c.filename = filename
+	}
+}
+
+// WithSearchPath sets the directories that imports are looked for in when
+// they aren't found relative to the importing file
+func WithSearchPath(dirs ...string) Option {
+	return func(c *Compiler) {
+		c.searchPath = dirs
 	}
 }
 
+// WithOptimize enables optimizations that make programs smaller and faster
+// without changing what they do. Expressions made only of literals, like
+// 1 + 2, are folded into the constant they evaluate to, and the instructions
+// of the program and each function are rewritten by a peephole optimizer,
+// see optimizeInstructions.
+func WithOptimize(enabled bool) Option {
+	return func(c *Compiler) {
+		c.optimize = enabled
+	}
+}
+
+func New(opts ...Option) *Compiler {
+	c := &Compiler{
+		symbolTable:   NewSymbolTable(),
+		constantIndex: make(map[any]int),
+		scopes:        []*Scope{{}},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.modules = newModuleSet(c.filename)
+	if c.filename != "" {
+		c.name = filepath.Base(c.filename)
+	}
+	return c
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
+	if stmt, ok := node.(ast.Statement); ok {
+		prevLine := c.line
+		c.line = stmt.Pos().Line
+		defer func() { c.line = prevLine }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
-			if err := c.Compile(s); err != nil {
+			var err error
+			if imp, ok := s.(*ast.ImportStatement); ok {
+				err = c.compileImport(imp)
+			} else {
+				err = c.Compile(s)
+			}
+			if err != nil {
 				return err
 			}
 		}
@@ -55,6 +149,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if _, ok := node.Expression.(*ast.CommentLiteral); ok {
 			return nil
 		}
+		if ifExp, ok := node.Expression.(*ast.IfExpression); ok {
+			// the value of an if used as a statement is never used, so don't produce one
+			return c.compileIf(ifExp, false)
+		}
 		if err := c.Compile(node.Expression); err != nil {
 			return err
 		}
@@ -62,7 +160,6 @@ func (c *Compiler) Compile(node ast.Node) error {
 		switch node.Expression.(type) {
 		case *ast.WhileExpression:
 		case *ast.ForExpression:
-		case *ast.IfExpression:
 		default:
 			c.emit(code.OpPop)
 		}
@@ -70,14 +167,37 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		sym, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			builtin, ok := builtins.Lookup(node.Value)
-			if !ok {
-				return fmt.Errorf("could not resolve identifier: %s", node.Value)
+			if builtin, ok := builtins.Lookup(node.Value); ok {
+				c.emit(code.OpGetBuiltin, builtin)
+				return nil
+			}
+			if native, ok := c.lookupNative(node.Value); ok {
+				c.emit(code.OpGetNative, native)
+				return nil
 			}
-			c.emit(code.OpGetBuiltin, builtin)
+			return fmt.Errorf("could not resolve identifier: %s", node.Value)
+		}
+		if sym.Scope == ModuleScope {
+			return fmt.Errorf("module %s is not a value, use %s.name to access its globals", node.Value, node.Value)
 		}
 		c.loadSymbol(sym)
 
+	case *ast.SelectorExpression:
+		mod, ok := node.Left.(*ast.Identifier)
+		if ok {
+			if sym, ok := c.symbolTable.Resolve(mod.Value); ok && sym.Scope == ModuleScope {
+				return c.compileModuleSelector(mod.Value, c.modules.modules[sym.Index], node.Name.Value)
+			}
+		}
+		if !ok || !c.isModule(mod.Value) {
+			return fmt.Errorf("invalid selector %s: %s is not a module", node, node.Left)
+		}
+		native, ok := c.lookupNative(mod.Value + "." + node.Name.Value)
+		if !ok {
+			return fmt.Errorf("module %s has no function %s", mod.Value, node.Name.Value)
+		}
+		c.emit(code.OpGetNative, native)
+
 	case *ast.ReassignStatement:
 		if err := c.Compile(node.Value); err != nil {
 			return err
@@ -86,8 +206,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if !ok {
 			return fmt.Errorf("cannot resolve symbol %s", node.Name.Value)
 		}
+		if sym.Scope == ModuleScope {
+			return fmt.Errorf("cannot assign to module %s", node.Name.Value)
+		}
 		c.setSymbol(sym)
 
+	case *ast.IndexAssignStatement:
+		if err := c.Compile(node.Target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+
 	case *ast.LetStatement:
 		if err := c.Compile(node.Value); err != nil {
 			return err
@@ -126,17 +261,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 	// expressions
 	case *ast.CallExpression:
-		if err := c.Compile(node.Function); err != nil {
-			return err
-		}
-		for _, arg := range node.Arguments {
-			if err := c.Compile(arg); err != nil {
-				return err
-			}
-		}
-		c.emit(code.OpCall, len(node.Arguments))
+		return c.compileCall(node, code.OpCall)
 
 	case *ast.InfixExpression:
+		if obj, ok := c.fold(node); ok {
+			c.emitValue(obj)
+			return nil
+		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
+		}
 		if node.Operator == "<" || node.Operator == "<=" {
 			if err := c.Compile(node.Right); err != nil {
 				return err
@@ -184,6 +318,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.PrefixExpression:
+		if obj, ok := c.fold(node); ok {
+			c.emitValue(obj)
+			return nil
+		}
 		if err := c.Compile(node.Right); err != nil {
 			return err
 		}
@@ -207,26 +345,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		// Conditionals
 	case *ast.IfExpression:
-		if err := c.Compile(node.Condition); err != nil {
-			return err
-		}
+		return c.compileIf(node, true)
 
-		jmpNTPos := c.emit(code.OpJumpNotTruthy, 0)
-
-		if err := c.Compile(node.Consequence); err != nil {
-			return err
-		}
-
-		if node.Alternative != nil {
-			jmpPos := c.emit(code.OpJump, 0)
-			c.replaceOperand(jmpNTPos, len(c.currentScope().instructions))
-			if err := c.Compile(node.Alternative); err != nil {
-				return err
-			}
-			c.replaceOperand(jmpPos, len(c.currentScope().instructions))
-		} else {
-			c.replaceOperand(jmpNTPos, len(c.currentScope().instructions))
-		}
 	case *ast.ForExpression:
 		if err := c.Compile(node.Init); err != nil {
 			return err
@@ -235,6 +355,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		initJumpPos := c.emit(code.OpJump, 0)
 
 		oldStart := c.currentScope().startPos
+		oldLoopTryDepth := c.currentScope().loopTryDepth
+		oldLoopLocals := c.currentScope().loopLocals
+		c.currentScope().loopTryDepth = c.currentScope().tryDepth
+		c.currentScope().loopLocals = c.symbolTable.numDefinitions
 		incrementLocation := len(c.currentScope().instructions)
 		c.currentScope().startPos = incrementLocation
 
@@ -255,6 +379,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		c.closeLoopLocals()
 		c.emit(code.OpJump, incrementLocation)
 		endPos := len(c.currentScope().instructions)
 
@@ -264,10 +389,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		c.currentScope().startPos = oldStart
+		c.currentScope().loopTryDepth = oldLoopTryDepth
+		c.currentScope().loopLocals = oldLoopLocals
 		c.currentScope().setEndPos = nil
 
 	case *ast.WhileExpression:
 		oldStart := c.currentScope().startPos
+		oldLoopTryDepth := c.currentScope().loopTryDepth
+		oldLoopLocals := c.currentScope().loopLocals
+		c.currentScope().loopTryDepth = c.currentScope().tryDepth
+		c.currentScope().loopLocals = c.symbolTable.numDefinitions
 
 		startPos := len(c.currentScope().instructions)
 		c.currentScope().startPos = startPos
@@ -281,6 +412,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		c.closeLoopLocals()
 		c.emit(code.OpJump, startPos)
 		endPos := len(c.currentScope().instructions)
 		c.replaceOperand(jntPos, endPos)
@@ -288,8 +420,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.replaceOperand(setEndPos, endPos)
 		}
 		c.currentScope().startPos = oldStart
+		c.currentScope().loopTryDepth = oldLoopTryDepth
+		c.currentScope().loopLocals = oldLoopLocals
 		c.currentScope().setEndPos = nil
 
+	case *ast.TryStatement:
+		return c.compileTry(node)
+
+	case *ast.ImportStatement:
+		return fmt.Errorf("imports are only allowed at the top level of a file")
+
+	case *ast.RaiseStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpRaise)
+
 		// Literals
 	case *ast.IntegerLiteral:
 		obj := &object.Integer{Value: node.Value}
@@ -335,7 +481,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.currentScope().ultInst.Opcode != code.OpReturn {
+		if !c.returns() {
 			c.emit(code.OpNull)
 			c.emit(code.OpReturn)
 		}
@@ -344,13 +490,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 		scope := c.leaveScope()
 
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.captureSymbol(s)
 		}
 
+		ins, lines := scope.instructions, scope.lines
+		if c.optimize {
+			ins, lines = optimizeInstructions(ins, lines, true)
+		}
 		cf := c.addConstant(&object.CompiledFunction{
-			Instructions: scope.instructions,
+			Instructions: ins,
 			NumLocals:    numLocals,
 			NumParams:    len(node.Parameters),
+			Name:         node.Name,
+			Lines:        lines,
 		})
 		c.emit(code.OpClosure, cf, len(freeSymbols))
 
@@ -358,16 +510,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if len(c.scopes) == 1 {
 			return fmt.Errorf("top level returns are not allowed")
 		}
+		// a call in a try block can't replace the function, since the
+		// function's catch block must still handle what it raises
+		if call, ok := node.Value.(*ast.CallExpression); ok && c.currentScope().tryDepth == 0 {
+			return c.compileCall(call, code.OpTailCall)
+		}
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
 		c.emit(code.OpReturn)
 
 	case *ast.BreakStatement:
+		c.leaveTries()
+		c.closeLoopLocals()
 		jmpPos := c.emit(code.OpJump, 0)
 		c.currentScope().setEndPos = append(c.currentScope().setEndPos, jmpPos)
 
 	case *ast.ContinueStatement:
+		c.leaveTries()
+		c.closeLoopLocals()
 		c.emit(code.OpJump, c.currentScope().startPos)
 
 	default:
@@ -376,13 +537,268 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileCall compiles a call with op, which is OpCall, or OpTailCall for a
+// call whose value the function returns
+func (c *Compiler) compileCall(node *ast.CallExpression, op code.Opcode) error {
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+	for _, arg := range node.Arguments {
+		if err := c.Compile(arg); err != nil {
+			return err
+		}
+	}
+	c.emit(op, len(node.Arguments))
+	return nil
+}
+
+// returns reports whether the function being compiled can't reach the end of
+// its instructions: its last instruction returns, and nothing jumps past it
+func (c *Compiler) returns() bool {
+	scope := c.currentScope()
+	if op := scope.ultInst.Opcode; op != code.OpReturn && op != code.OpTailCall {
+		return false
+	}
+	end := len(scope.instructions)
+	for i := 0; i < end; {
+		op := code.Opcode(scope.instructions[i])
+		widths, _ := code.OpWidths(byte(op))
+		operands, read := code.ReadOperands(widths, scope.instructions[i+1:])
+		switch op {
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpTry:
+			if operands[0] == end {
+				return false
+			}
+		}
+		i += 1 + read
+	}
+	return true
+}
+
+// compileIf compiles an if expression. When wantValue is true, exactly one value
+// is left on the stack: the value of the last expression statement of the branch
+// taken, or null if there isn't one.
+func (c *Compiler) compileIf(node *ast.IfExpression, wantValue bool) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jmpNTPos := c.emit(code.OpJumpNotTruthy, 0)
+
+	if err := c.compileBranch(node.Consequence, wantValue); err != nil {
+		return err
+	}
+
+	if node.Alternative == nil && !wantValue {
+		c.replaceOperand(jmpNTPos, len(c.currentScope().instructions))
+		return nil
+	}
+
+	jmpPos := c.emit(code.OpJump, 0)
+	c.replaceOperand(jmpNTPos, len(c.currentScope().instructions))
+	if node.Alternative != nil {
+		if err := c.compileBranch(node.Alternative, wantValue); err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpNull)
+	}
+	c.replaceOperand(jmpPos, len(c.currentScope().instructions))
+	return nil
+}
+
+func (c *Compiler) compileBranch(block *ast.BlockStatement, wantValue bool) error {
+	if !wantValue {
+		return c.Compile(block)
+	}
+
+	if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	stmt, ok := block.Statements[last].(*ast.ExpressionStatement)
+	if !ok {
+		if err := c.Compile(block.Statements[last]); err != nil {
+			return err
+		}
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	if ifExp, ok := stmt.Expression.(*ast.IfExpression); ok {
+		// the last statement of the branch is itself an if (as in else if chains)
+		// so its value becomes the value of this branch
+		prevLine := c.line
+		c.line = stmt.Pos().Line
+		err := c.compileIf(ifExp, true)
+		c.line = prevLine
+		return err
+	}
+
+	if err := c.Compile(stmt); err != nil {
+		return err
+	}
+	switch stmt.Expression.(type) {
+	case *ast.WhileExpression, *ast.ForExpression, *ast.CommentLiteral:
+		c.emit(code.OpNull)
+	default:
+		c.removeLastInstruction(code.OpPop)
+	}
+	return nil
+}
+
+// compileTry compiles a try statement. OpTry registers the catch block as the
+// handler for errors raised until the matching OpEndTry. When an error is
+// raised, the VM unwinds to the handler and pushes the error, which the catch
+// block starts by storing in its variable.
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	tryPos := c.emit(code.OpTry, 0)
+	c.currentScope().tryDepth++
+	err := c.Compile(node.Body)
+	c.currentScope().tryDepth--
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpEndTry)
+	jmpPos := c.emit(code.OpJump, 0)
+
+	c.replaceOperand(tryPos, len(c.currentScope().instructions))
+	if node.Name != nil {
+		sym := c.symbolTable.Define(node.Name.Value)
+		c.setSymbol(sym)
+	} else {
+		c.emit(code.OpPop)
+	}
+	if err := c.Compile(node.Catch); err != nil {
+		return err
+	}
+	c.replaceOperand(jmpPos, len(c.currentScope().instructions))
+	return nil
+}
+
+// leaveTries ends the try blocks that a break or continue jumps out of
+func (c *Compiler) leaveTries() {
+	for i := c.currentScope().loopTryDepth; i < c.currentScope().tryDepth; i++ {
+		c.emit(code.OpEndTry)
+	}
+}
+
+// closeLoopLocals closes the upvalues of the locals defined so far in the
+// body of the innermost loop, as an iteration of it ends. Globals aren't
+// captured, so there is nothing to close outside of functions.
+func (c *Compiler) closeLoopLocals() {
+	if c.symbolTable.outer == nil || c.symbolTable.numDefinitions <= c.currentScope().loopLocals {
+		return
+	}
+	c.emit(code.OpCloseUpvalues, c.currentScope().loopLocals)
+}
+
+// compileLogical compiles && and || so the right side is only evaluated when
+// the left side doesn't already determine the result. Both produce a boolean.
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	// && bails out to false on the first falsy operand, || bails out to true
+	// on the first truthy one
+	jumpOp, bailOp, resultOp := code.OpJumpNotTruthy, code.OpFalse, code.OpTrue
+	if node.Operator == "||" {
+		jumpOp, bailOp, resultOp = code.OpJumpTruthy, code.OpTrue, code.OpFalse
+	}
+
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	leftJmp := c.emit(jumpOp, 0)
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	rightJmp := c.emit(jumpOp, 0)
+
+	c.emit(resultOp)
+	endJmp := c.emit(code.OpJump, 0)
+
+	bailPos := len(c.currentScope().instructions)
+	c.replaceOperand(leftJmp, bailPos)
+	c.replaceOperand(rightJmp, bailPos)
+	c.emit(bailOp)
+
+	c.replaceOperand(endJmp, len(c.currentScope().instructions))
+	return nil
+}
+
+// Bytecode returns the compiled file. If it imports modules, it must be
+// linked with them before it can be run, see Link.
 func (c *Compiler) Bytecode() *Bytecode {
+	st := c.globalTable()
+	exports := make(map[string]int)
+	for _, sym := range st.Symbols() {
+		exports[sym.Name] = sym.Index
+	}
+	ins, lines := c.scopes[0].instructions, c.scopes[0].lines
+	if c.optimize {
+		ins, lines = optimizeInstructions(ins, lines, false)
+	}
 	return &Bytecode{
-		Instructions: c.scopes[0].instructions,
+		Instructions: ins,
+		Lines:        lines,
 		Constants:    c.constants,
+		Modules: []Module{{
+			Name:         c.name,
+			Instructions: newRange(0, len(ins)),
+			Constants:    newRange(0, len(c.constants)),
+			Globals:      newRange(0, st.numDefinitions),
+			Exports:      exports,
+		}},
+		Requires: c.requires,
+		Imports:  c.imports,
 	}
 }
 
+// Link returns the program linked with every module it imports, ready to run
+func (c *Compiler) Link() (*Bytecode, error) {
+	return Link(c.Bytecode(), c.modules.load)
+}
+
+// Modules returns the compiled modules that the program imports, in the order
+// they were first imported. Each must be linked before it can be run.
+func (c *Compiler) Modules() []*Bytecode {
+	var mods []*Bytecode
+	for _, mod := range c.modules.modules {
+		if mod.bytecode != nil {
+			mods = append(mods, mod.bytecode)
+		}
+	}
+	return mods
+}
+
+func (c *Compiler) lookupNative(name string) (int, bool) {
+	if c.registry == nil {
+		return 0, false
+	}
+	return c.registry.Lookup(name)
+}
+
+// isModule reports whether name refers to a registered module, rather than
+// a variable that shadows it
+func (c *Compiler) isModule(name string) bool {
+	if _, ok := c.symbolTable.Resolve(name); ok {
+		return false
+	}
+	return c.registry != nil && c.registry.IsModule(name)
+}
+
+// SymbolTable returns the global symbol table. Symbols defined in it before
+// compiling are available to the program as globals.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
 func (c *Compiler) currentScope() *Scope {
 	return c.scopes[len(c.scopes)-1]
 }
@@ -405,6 +821,7 @@ func (c *Compiler) removeLastInstruction(op code.Opcode) {
 		return
 	}
 	scope.instructions = scope.instructions[:scope.ultInst.Position]
+	scope.lines.Truncate(scope.ultInst.Position)
 	scope.ultInst = scope.penultInst
 }
 
@@ -432,10 +849,6 @@ func (c *Compiler) loadSymbol(s Symbol) {
 }
 
 func (c *Compiler) setSymbol(s Symbol) {
-	c._setSymbol(c.symbolTable, s)
-}
-
-func (c *Compiler) _setSymbol(st *SymbolTable, s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
 		c.emit(code.OpSetGlobal, s.Index)
@@ -443,7 +856,17 @@ func (c *Compiler) _setSymbol(st *SymbolTable, s Symbol) {
 		c.emit(code.OpSetLocal, s.Index)
 	case FreeScope:
 		c.emit(code.OpSetFree, s.Index)
-		c._setSymbol(st.outer, st.FreeSymbols[s.Index])
+	}
+}
+
+// captureSymbol emits the instruction that pushes the upvalue of s, a local
+// or free variable of the function being compiled, for a closure made in it
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
 	}
 }
 
@@ -464,71 +887,55 @@ func (c *Compiler) addInstruction(ins code.Instructions) int {
 	scope := c.currentScope()
 	pos := len(scope.instructions)
 	scope.instructions = append(scope.instructions, ins...)
+	scope.lines.Add(pos, c.line)
 	return pos
 }
 
+// addConstant adds obj to the constant pool, returning its index. Integers,
+// floats and strings are immutable, so every literal with the same value
+// shares a single constant.
 func (c *Compiler) addConstant(obj object.Object) int {
-	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
-}
-
-type Bytecode struct {
-	Instructions code.Instructions
-	Constants    []object.Object
-}
-
-func (b *Bytecode) UnmarshalBinary(data []byte) error {
-	n, err := b.Instructions.UnmarshalBytes(data)
-	if err != nil {
-		return err
-	}
-	ptr := n
-	numConsts := int(binary.BigEndian.Uint64(data[ptr:]))
-	ptr += 8
-	b.Constants = make([]object.Object, 0, numConsts)
-	for ptr < len(data) {
-		var obj object.Encodable
-
-		switch object.Type(data[ptr]) {
-		case object.IntegerType:
-			obj = &object.Integer{}
-		case object.FloatType:
-			obj = &object.Float{}
-		case object.StringType:
-			obj = &object.String{}
-		case object.CompiledFunctionType:
-			obj = &object.CompiledFunction{}
-		}
-
-		read, err := obj.UnmarshalBytes(data[ptr:])
-		if err != nil {
-			return err
+	key, ok := constantKey(obj)
+	if ok {
+		if idx, ok := c.constantIndex[key]; ok {
+			return idx
 		}
-		ptr += read
-		b.Constants = append(b.Constants, obj.(object.Object))
 	}
-	return nil
+	c.constants = append(c.constants, obj)
+	idx := len(c.constants) - 1
+	if ok {
+		c.constantIndex[key] = idx
+	}
+	return idx
 }
 
-func (b Bytecode) MarshalBinary() ([]byte, error) {
-	out, err := b.Instructions.MarshalBytes()
-
-	numConst := len(b.Constants)
-	consts := make([]byte, 8, 8+(numConst*9))
-	binary.BigEndian.PutUint64(consts, uint64(numConst))
-	for _, c := range b.Constants {
-		obj, ok := c.(object.Encodable)
-		if !ok {
-			return nil, fmt.Errorf("invalid constant: cannot encode %s", c.Type())
-		}
-		b, err := obj.MarshalBytes()
-		if err != nil {
-			return nil, err
-		}
-		consts = append(consts, b...)
+// constantKey returns the key that constants equal to obj are found by in
+// constantIndex, if obj can be shared
+func constantKey(obj object.Object) (any, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return *obj, true
+	case *object.Float:
+		// compared by their bits, so that 0.0 and -0.0 stay apart
+		return math.Float64bits(obj.Value), true
+	case *object.String:
+		return *obj, true
+	default:
+		return nil, false
 	}
+}
 
-	return append(out, consts...), err
+type Bytecode struct {
+	Instructions code.Instructions
+	Lines        code.LineTable
+	Constants    []object.Object
+	// Modules is the module table. Bytecode compiled from a single file has
+	// one module, and a linked program has one for each of its files.
+	Modules []Module
+	// Requires are the names of the modules that a file imports, and Imports
+	// the globals of those modules it uses. Both are empty once linked.
+	Requires []string
+	Imports  []Import
 }
 
 func (b Bytecode) String() string {
@@ -539,6 +946,14 @@ func (b Bytecode) String() string {
 	}
 	sb.WriteString("Instructions:\n")
 	sb.WriteString(b.Instructions.String())
+	sb.WriteString("Modules:\n")
+	sb.WriteString(moduleTableString(b.Modules))
+	for _, name := range b.Requires {
+		fmt.Fprintf(&sb, "\trequires %s\n", name)
+	}
+	for _, imp := range b.Imports {
+		fmt.Fprintf(&sb, "\timports %s.%s as global %d\n", imp.Module, imp.Name, imp.Global)
+	}
 
 	return sb.String()
 }
@@ -2,9 +2,13 @@ package compiler
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/jimmykodes/joker/ast"
+	"github.com/jimmykodes/joker/builtins"
 	"github.com/jimmykodes/joker/code"
 	"github.com/jimmykodes/joker/lexer"
 	"github.com/jimmykodes/joker/object"
@@ -35,7 +39,7 @@ func TestClosures(t *testing.T) {
 					code.Instruction(code.OpReturn),
 				},
 				[]code.Instructions{
-					code.Instruction(code.OpGetLocal, 0),
+					code.Instruction(code.OpCaptureLocal, 0),
 					code.Instruction(code.OpClosure, 0, 1),
 					code.Instruction(code.OpReturn),
 				},
@@ -65,13 +69,13 @@ func TestClosures(t *testing.T) {
 					code.Instruction(code.OpReturn),
 				},
 				[]code.Instructions{
-					code.Instruction(code.OpGetFree, 0),
-					code.Instruction(code.OpGetLocal, 0),
+					code.Instruction(code.OpCaptureFree, 0),
+					code.Instruction(code.OpCaptureLocal, 0),
 					code.Instruction(code.OpClosure, 0, 2),
 					code.Instruction(code.OpReturn),
 				},
 				[]code.Instructions{
-					code.Instruction(code.OpGetLocal, 0),
+					code.Instruction(code.OpCaptureLocal, 0),
 					code.Instruction(code.OpClosure, 1, 1),
 					code.Instruction(code.OpReturn),
 				},
@@ -112,15 +116,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Instruction(code.OpConstant, 2),
 					code.Instruction(code.OpSetLocal, 0),
-					code.Instruction(code.OpGetFree, 0),
-					code.Instruction(code.OpGetLocal, 0),
+					code.Instruction(code.OpCaptureFree, 0),
+					code.Instruction(code.OpCaptureLocal, 0),
 					code.Instruction(code.OpClosure, 4, 2),
 					code.Instruction(code.OpReturn),
 				},
 				[]code.Instructions{
 					code.Instruction(code.OpConstant, 1),
 					code.Instruction(code.OpSetLocal, 0),
-					code.Instruction(code.OpGetLocal, 0),
+					code.Instruction(code.OpCaptureLocal, 0),
 					code.Instruction(code.OpClosure, 5, 1),
 					code.Instruction(code.OpReturn),
 				},
@@ -132,6 +136,49 @@ func TestClosures(t *testing.T) {
 				code.Instruction(code.OpPop),
 			},
 		},
+		{
+			// the locals of a loop body are closed as each iteration ends
+			input: `
+      fn(n) {
+        while true {
+          j := n;
+          if j { break; }
+        }
+      }
+      `,
+			expectedConstants: []any{
+				[]code.Instructions{
+					// 0000
+					code.Instruction(code.OpTrue),
+					// 0001
+					code.Instruction(code.OpJumpNotTruthy, 23),
+					// 0004
+					code.Instruction(code.OpGetLocal, 0),
+					// 0006
+					code.Instruction(code.OpSetLocal, 1),
+					// 0008
+					code.Instruction(code.OpGetLocal, 1),
+					// 0010
+					code.Instruction(code.OpJumpNotTruthy, 18),
+					// 0013
+					code.Instruction(code.OpCloseUpvalues, 1),
+					// 0015
+					code.Instruction(code.OpJump, 23),
+					// 0018
+					code.Instruction(code.OpCloseUpvalues, 1),
+					// 0020
+					code.Instruction(code.OpJump, 0),
+					// 0023
+					code.Instruction(code.OpNull),
+					// 0024
+					code.Instruction(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpClosure, 0, 0),
+				code.Instruction(code.OpPop),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
@@ -341,31 +388,217 @@ func TestFunctions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn f(n) { return f(n); }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Instruction(code.OpGetGlobal, 0),
+					code.Instruction(code.OpGetLocal, 0),
+					code.Instruction(code.OpTailCall, 1),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpClosure, 0, 0),
+				code.Instruction(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			// the catch block must handle what the call raises, so it isn't a
+			// tail call
+			input: `fn(g) { try { return g(); } catch { } }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					// 0000
+					code.Instruction(code.OpTry, 12),
+					// 0003
+					code.Instruction(code.OpGetLocal, 0),
+					// 0005
+					code.Instruction(code.OpCall, 0),
+					// 0007
+					code.Instruction(code.OpReturn),
+					// 0008
+					code.Instruction(code.OpEndTry),
+					// 0009
+					code.Instruction(code.OpJump, 13),
+					// 0012
+					code.Instruction(code.OpPop),
+					// 0013
+					code.Instruction(code.OpNull),
+					// 0014
+					code.Instruction(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpClosure, 0, 0),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			// the end is reached when the condition is false, so null is
+			// returned there
+			input: `fn(c) { if c { return 1; } }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					// 0000
+					code.Instruction(code.OpGetLocal, 0),
+					// 0002
+					code.Instruction(code.OpJumpNotTruthy, 9),
+					// 0005
+					code.Instruction(code.OpConstant, 0),
+					// 0008
+					code.Instruction(code.OpReturn),
+					// 0009
+					code.Instruction(code.OpNull),
+					// 0010
+					code.Instruction(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpClosure, 1, 0),
+				code.Instruction(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestConstants(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1; 1.5; "a"; 1; 1.5; "a"; 0.0; -0.0;`,
+			expectedConstants: []any{1, 1.5, "a", 0.0},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 1),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 2),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 1),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 2),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 3),
+				code.Instruction(code.OpPop),
+				// -0.0 is only folded with optimizations, so the second zero
+				// is 0.0 negated
+				code.Instruction(code.OpConstant, 3),
+				code.Instruction(code.OpMinus),
+				code.Instruction(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []any{7},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input:             "-5; 5; 2.5 / 2;",
+			expectedConstants: []any{-5, 5, 1.25},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 1),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 2),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input:             `"foo" + "bar"; "foobar";`,
+			expectedConstants: []any{"foobar"},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2; !true; !(1 == 1.0); 2 >= 3;",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpTrue),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpFalse),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpFalse),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpFalse),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			// only the part made of literals is folded
+			input:             "let x = 1; x + (2 + 3);",
+			expectedConstants: []any{1, 5},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpSetGlobal, 0),
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpConstant, 1),
+				code.Instruction(code.OpAdd),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			// errors are raised when the program runs
+			input:             "1 / 0; 1 + \"a\";",
+			expectedConstants: []any{1, 0, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpConstant, 1),
+				code.Instruction(code.OpDiv),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpConstant, 2),
+				code.Instruction(code.OpAdd),
+				code.Instruction(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests, WithOptimize(true))
+}
+
 func TestIndexExpression(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][2]",
-			expectedConstants: []any{1, 2, 3, 2},
+			expectedConstants: []any{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Instruction(code.OpConstant, 0),
 				code.Instruction(code.OpConstant, 1),
 				code.Instruction(code.OpConstant, 2),
 				code.Instruction(code.OpArray, 3),
-				code.Instruction(code.OpConstant, 3),
+				code.Instruction(code.OpConstant, 1),
 				code.Instruction(code.OpIndex),
 				code.Instruction(code.OpPop),
 			},
 		},
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []any{1, 2, 3, 1, 1},
+			expectedConstants: []any{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Instruction(code.OpConstant, 0),
 				code.Instruction(code.OpConstant, 1),
 				code.Instruction(code.OpConstant, 2),
 				code.Instruction(code.OpArray, 3),
-				code.Instruction(code.OpConstant, 3),
-				code.Instruction(code.OpConstant, 4),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpConstant, 0),
 				code.Instruction(code.OpAdd),
 				code.Instruction(code.OpIndex),
 				code.Instruction(code.OpPop),
@@ -373,12 +606,12 @@ func TestIndexExpression(t *testing.T) {
 		},
 		{
 			input:             "{1: 12}[1]",
-			expectedConstants: []any{1, 12, 1},
+			expectedConstants: []any{1, 12},
 			expectedInstructions: []code.Instructions{
 				code.Instruction(code.OpConstant, 0),
 				code.Instruction(code.OpConstant, 1),
 				code.Instruction(code.OpMap, 1),
-				code.Instruction(code.OpConstant, 2),
+				code.Instruction(code.OpConstant, 0),
 				code.Instruction(code.OpIndex),
 				code.Instruction(code.OpPop),
 			},
@@ -405,6 +638,235 @@ func TestIndexExpression(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestNativeFunctions(t *testing.T) {
+	r := builtins.NewRegistry()
+	noop := func(rt *object.Runtime, args ...object.Object) object.Object { return nil }
+	if err := r.Register("double", noop); err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+	if err := r.RegisterModule("str", map[string]object.BuiltinFunction{"upper": noop, "lower": noop}); err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+	tests := []compilerTestCase{
+		{
+			input:             "double(1)",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpGetNative, 0),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpCall, 1),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			// module functions are registered in name order
+			input:             `str.upper("a")`,
+			expectedConstants: []any{"a"},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpGetNative, 2),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpCall, 1),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input:             `let f = str.lower; len("a")`,
+			expectedConstants: []any{"a"},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpGetNative, 1),
+				code.Instruction(code.OpSetGlobal, 0),
+				code.Instruction(code.OpGetBuiltin, 4),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpCall, 1),
+				code.Instruction(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests, WithRegistry(r))
+
+	errs := []struct {
+		input string
+		err   string
+	}{
+		{`str.title("a")`, "module str has no function title"},
+		{`let str = 1; str.upper("a")`, "invalid selector str.upper: str is not a module"},
+		{`double.upper`, "invalid selector double.upper: double is not a module"},
+		{`triple(1)`, "could not resolve identifier: triple"},
+	}
+	for _, tt := range errs {
+		err := New(WithRegistry(r)).Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("invalid error for %s: got %v - want %s", tt.input, err, tt.err)
+		}
+	}
+	if err := New().Compile(parse("double(1)")); err == nil {
+		t.Errorf("expected error compiling without a registry")
+	}
+}
+
+func TestImports(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib/math.jk": `let pi = 3;`,
+		"util.jk":     `import "lib/math"; let tau = math.pi * 2;`,
+	})
+	tests := []compilerTestCase{
+		{
+			// each file is compiled on its own, and the globals of the modules
+			// it imports are given slots after its own for the linker to fill
+			input:             `import "util"; import "lib/math"; let pi = 1; math.pi + util.tau`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpSetGlobal, 0),
+				code.Instruction(code.OpGetGlobal, 1),
+				code.Instruction(code.OpGetGlobal, 2),
+				code.Instruction(code.OpAdd),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input: `import "lib/math"; fn f() { return math.pi + math.pi; }`,
+			expectedConstants: []any{
+				[]code.Instructions{
+					code.Instruction(code.OpGetGlobal, 1),
+					code.Instruction(code.OpGetGlobal, 1),
+					code.Instruction(code.OpAdd),
+					code.Instruction(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpClosure, 0, 0),
+				code.Instruction(code.OpSetGlobal, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests, WithFilename(filepath.Join(dir, "main.jk")))
+
+	c := New(WithFilename(filepath.Join(dir, "main.jk")))
+	if err := c.Compile(parse(`import "util"; import "lib/math"; let pi = 1; math.pi + util.tau`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+	if bytecode.Linked() {
+		t.Errorf("bytecode with imports should not be linked")
+	}
+	if want := []string{"util.jk", "lib/math.jk"}; !reflect.DeepEqual(bytecode.Requires, want) {
+		t.Errorf("invalid requires: got %v - want %v", bytecode.Requires, want)
+	}
+	wantImports := []Import{{Module: "lib/math.jk", Name: "pi", Global: 1}, {Module: "util.jk", Name: "tau", Global: 2}}
+	if !reflect.DeepEqual(bytecode.Imports, wantImports) {
+		t.Errorf("invalid imports: got %v - want %v", bytecode.Imports, wantImports)
+	}
+	wantModule := Module{
+		Name:         "main.jk",
+		Instructions: Range{0, 14},
+		Constants:    Range{0, 1},
+		Globals:      Range{0, 3},
+		Exports:      map[string]int{"pi": 0},
+	}
+	if !reflect.DeepEqual(bytecode.Modules, []Module{wantModule}) {
+		t.Errorf("invalid modules: got %v - want %v", bytecode.Modules, []Module{wantModule})
+	}
+	// modules are compiled once, where they're first imported
+	var names []string
+	for _, mod := range c.Modules() {
+		names = append(names, mod.Modules[0].Name)
+	}
+	if want := []string{"util.jk", "lib/math.jk"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("invalid modules: got %v - want %v", names, want)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"math.jk":   `let pi = 3;`,
+		"a.jk":      `import "b";`,
+		"b.jk":      `import "a";`,
+		"main.jk":   `import "loop";`,
+		"loop.jk":   `import "main";`,
+		"bad.jk":    `let x = y;`,
+		"my-mod.jk": `let x = 1;`,
+	})
+	searchDir := writeFiles(t, map[string]string{"found.jk": `let x = 1;`})
+
+	errs := []struct {
+		input string
+		err   string
+	}{
+		{`import "missing";`, "module not found: missing"},
+		{`import "a";`, filepath.Join(dir, "b.jk") + ": import cycle: " + filepath.Join(dir, "a.jk") + " -> " + filepath.Join(dir, "b.jk") + " -> " + filepath.Join(dir, "a.jk")},
+		{`import "loop";`, filepath.Join(dir, "loop.jk") + ": import cycle: " + filepath.Join(dir, "main.jk") + " -> " + filepath.Join(dir, "loop.jk") + " -> " + filepath.Join(dir, "main.jk")},
+		{`import "bad";`, filepath.Join(dir, "bad.jk") + ": could not resolve identifier: y"},
+		{`import "my-mod";`, `invalid module name "my-mod": must be an identifier`},
+		{`import "math"; math.tau`, "module math has no global tau"},
+		{`import "math"; let m = math;`, "module math is not a value, use math.name to access its globals"},
+		{`import "math"; math = 1;`, "cannot assign to module math"},
+		{`fn f() { import "math"; }`, "imports are only allowed at the top level of a file"},
+		{`if true { import "math"; }`, "imports are only allowed at the top level of a file"},
+	}
+	for _, tt := range errs {
+		err := New(WithFilename(filepath.Join(dir, "main.jk"))).Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("invalid error for %s: got %v - want %s", tt.input, err, tt.err)
+		}
+	}
+
+	c := New(WithFilename(filepath.Join(dir, "main.jk")), WithSearchPath(searchDir))
+	if err := c.Compile(parse(`import "found"; found.x`)); err != nil {
+		t.Errorf("compiler error importing from the search path: %s", err)
+	}
+}
+
+// writeFiles writes files, relative to a new temporary directory, and returns
+// the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = [1]; x[0] = 2;",
+			expectedConstants: []any{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpArray, 1),
+				code.Instruction(code.OpSetGlobal, 0),
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpConstant, 1),
+				code.Instruction(code.OpConstant, 2),
+				code.Instruction(code.OpSetIndex),
+			},
+		},
+		{
+			input:             `let m = {}; m["a"][0] = 1;`,
+			expectedConstants: []any{"a", 0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Instruction(code.OpMap, 0),
+				code.Instruction(code.OpSetGlobal, 0),
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpIndex),
+				code.Instruction(code.OpConstant, 1),
+				code.Instruction(code.OpConstant, 2),
+				code.Instruction(code.OpSetIndex),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestMapLiterals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -643,28 +1105,28 @@ func TestForLoop(t *testing.T) {
         a = a + 1;
       }
       a;`,
-			expectedConstants: []any{0, 0, 1, 10, 1},
+			expectedConstants: []any{0, 1, 10},
 			expectedInstructions: []code.Instructions{
 				// 0000 OpConstant 0
 				code.Instruction(code.OpConstant, 0),
 				// 0003 OpSetGlobal 0
 				code.Instruction(code.OpSetGlobal, 0),
-				// 0006 OpConstant 1
-				code.Instruction(code.OpConstant, 1),
+				// 0006 OpConstant 0
+				code.Instruction(code.OpConstant, 0),
 				// 0009 OpSetGlobal 1
 				code.Instruction(code.OpSetGlobal, 1),
 				// 0012 OpJump 25
 				code.Instruction(code.OpJump, 25),
 				// 0015 OpGetGlobal 1
 				code.Instruction(code.OpGetGlobal, 1),
-				// 0018 OpConstant 2
-				code.Instruction(code.OpConstant, 2),
+				// 0018 OpConstant 1
+				code.Instruction(code.OpConstant, 1),
 				// 0021 OpAdd
 				code.Instruction(code.OpAdd),
 				// 0022 OpSetGlobal 1
 				code.Instruction(code.OpSetGlobal, 1),
-				// 0025 OpConstant 3
-				code.Instruction(code.OpConstant, 3),
+				// 0025 OpConstant 2
+				code.Instruction(code.OpConstant, 2),
 				// 0028 OpGetGlobal 1
 				code.Instruction(code.OpGetGlobal, 1),
 				// 0031 OpGT
@@ -673,8 +1135,8 @@ func TestForLoop(t *testing.T) {
 				code.Instruction(code.OpJumpNotTruthy, 48),
 				// 0035 OpGetGlobal 0
 				code.Instruction(code.OpGetGlobal, 0),
-				// 0038 OpConstant 4
-				code.Instruction(code.OpConstant, 4),
+				// 0038 OpConstant 1
+				code.Instruction(code.OpConstant, 1),
 				// 0041 OpAdd
 				code.Instruction(code.OpAdd),
 				// 0042 OpSetGlobal 0
@@ -700,28 +1162,28 @@ func TestForLoop(t *testing.T) {
 		      a = a + 1;
 		    }
 		    a;`,
-			expectedConstants: []any{0, 0, 1, 10, 5, 8, 1},
+			expectedConstants: []any{0, 1, 10, 5, 8},
 			expectedInstructions: []code.Instructions{
 				// 0000 OpConstant 0
 				code.Instruction(code.OpConstant, 0),
 				// 0003 OpSetGlobal 0
 				code.Instruction(code.OpSetGlobal, 0),
-				// 0006 OpConstant 1
-				code.Instruction(code.OpConstant, 1),
+				// 0006 OpConstant 0
+				code.Instruction(code.OpConstant, 0),
 				// 0009 OpSetGlobal 1
 				code.Instruction(code.OpSetGlobal, 1),
 				// 0012 OpJump 25
 				code.Instruction(code.OpJump, 25),
 				// 0015 OpGetGlobal 1
 				code.Instruction(code.OpGetGlobal, 1),
-				// 0018 OpConstant 2
-				code.Instruction(code.OpConstant, 2),
+				// 0018 OpConstant 1
+				code.Instruction(code.OpConstant, 1),
 				// 0021 OpAdd
 				code.Instruction(code.OpAdd),
 				// 0022 OpSetGlobal 1
 				code.Instruction(code.OpSetGlobal, 1),
-				// 0025 OpConstant 3
-				code.Instruction(code.OpConstant, 3),
+				// 0025 OpConstant 2
+				code.Instruction(code.OpConstant, 2),
 				// 0028 OpGetGlobal 1
 				code.Instruction(code.OpGetGlobal, 1),
 				// 0031 OpGT
@@ -730,8 +1192,8 @@ func TestForLoop(t *testing.T) {
 				code.Instruction(code.OpJumpNotTruthy, 74),
 				// 0035 OpGetGlobal 1
 				code.Instruction(code.OpGetGlobal, 1),
-				// 0038 OpConstant 4
-				code.Instruction(code.OpConstant, 4),
+				// 0038 OpConstant 3
+				code.Instruction(code.OpConstant, 3),
 				// 0041 OpEQ
 				code.Instruction(code.OpEQ),
 				// 0042 OpJumpNotTruthy 48
@@ -740,8 +1202,8 @@ func TestForLoop(t *testing.T) {
 				code.Instruction(code.OpJump, 15),
 				// 0048 OpGetGlobal 1
 				code.Instruction(code.OpGetGlobal, 1),
-				// 0051 OpConstant 5
-				code.Instruction(code.OpConstant, 5),
+				// 0051 OpConstant 4
+				code.Instruction(code.OpConstant, 4),
 				// 0054 OpEQ
 				code.Instruction(code.OpEQ),
 				// 0055 OpJumpNotTruthy 61
@@ -750,8 +1212,8 @@ func TestForLoop(t *testing.T) {
 				code.Instruction(code.OpJump, 74),
 				// 0061 OpGetGlobal 0
 				code.Instruction(code.OpGetGlobal, 0),
-				// 0064 OpConstant 6
-				code.Instruction(code.OpConstant, 6),
+				// 0064 OpConstant 1
+				code.Instruction(code.OpConstant, 1),
 				// 0067 OpAdd
 				code.Instruction(code.OpAdd),
 				// 0068 OpSetGlobal 0
@@ -840,6 +1302,58 @@ func TestWhileLoop(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestTryCatch(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `try { raise "boom"; } catch e { e; }`,
+			expectedConstants: []any{"boom"},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTry, 11),
+				// 0003
+				code.Instruction(code.OpConstant, 0),
+				// 0006
+				code.Instruction(code.OpRaise),
+				// 0007
+				code.Instruction(code.OpEndTry),
+				// 0008
+				code.Instruction(code.OpJump, 18),
+				// 0011
+				code.Instruction(code.OpSetGlobal, 0),
+				// 0014
+				code.Instruction(code.OpGetGlobal, 0),
+				// 0017
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input:             `while true { try { break; } catch { } }`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTrue),
+				// 0001
+				code.Instruction(code.OpJumpNotTruthy, 19),
+				// 0004
+				code.Instruction(code.OpTry, 15),
+				// 0007 the break leaves the try block
+				code.Instruction(code.OpEndTry),
+				// 0008
+				code.Instruction(code.OpJump, 19),
+				// 0011
+				code.Instruction(code.OpEndTry),
+				// 0012
+				code.Instruction(code.OpJump, 16),
+				// 0015
+				code.Instruction(code.OpPop),
+				// 0016
+				code.Instruction(code.OpJump, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -884,6 +1398,110 @@ func TestConditionals(t *testing.T) {
 				code.Instruction(code.OpPop),
 			},
 		},
+		{
+			input:             "if true { 10 } else if false { 12 }; 3333;",
+			expectedConstants: []any{10, 12, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTrue),
+				// 0001
+				code.Instruction(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Instruction(code.OpConstant, 0),
+				// 0007
+				code.Instruction(code.OpPop),
+				// 0008
+				code.Instruction(code.OpJump, 19),
+				// 0011
+				code.Instruction(code.OpFalse),
+				// 0012
+				code.Instruction(code.OpJumpNotTruthy, 19),
+				// 0015
+				code.Instruction(code.OpConstant, 1),
+				// 0018
+				code.Instruction(code.OpPop),
+				// 0019
+				code.Instruction(code.OpConstant, 2),
+				// 0022
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = if true { 10 } else if false { 12 };",
+			expectedConstants: []any{10, 12},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTrue),
+				// 0001
+				code.Instruction(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Instruction(code.OpConstant, 0),
+				// 0007
+				code.Instruction(code.OpJump, 21),
+				// 0010
+				code.Instruction(code.OpFalse),
+				// 0011
+				code.Instruction(code.OpJumpNotTruthy, 20),
+				// 0014
+				code.Instruction(code.OpConstant, 1),
+				// 0017
+				code.Instruction(code.OpJump, 21),
+				// 0020
+				code.Instruction(code.OpNull),
+				// 0021
+				code.Instruction(code.OpSetGlobal, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false;",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTrue),
+				// 0001
+				code.Instruction(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Instruction(code.OpFalse),
+				// 0005
+				code.Instruction(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Instruction(code.OpTrue),
+				// 0009
+				code.Instruction(code.OpJump, 13),
+				// 0012
+				code.Instruction(code.OpFalse),
+				// 0013
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false;",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTrue),
+				// 0001
+				code.Instruction(code.OpJumpTruthy, 12),
+				// 0004
+				code.Instruction(code.OpFalse),
+				// 0005
+				code.Instruction(code.OpJumpTruthy, 12),
+				// 0008
+				code.Instruction(code.OpFalse),
+				// 0009
+				code.Instruction(code.OpJump, 13),
+				// 0012
+				code.Instruction(code.OpTrue),
+				// 0013
+				code.Instruction(code.OpPop),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
@@ -1150,11 +1768,11 @@ func parse(input string) *ast.Program {
 	return p.ParseProgram()
 }
 
-func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+func runCompilerTests(t *testing.T, tests []compilerTestCase, opts ...Option) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
-		compiler := New()
+		compiler := New(opts...)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Errorf("compiler error: %s", err)
@@ -0,0 +1,149 @@
+package compiler
+
+import (
+	"github.com/jimmykodes/joker/ast"
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/object"
+)
+
+// maxFoldedString is the longest string that folding will make a constant
+// of. Longer strings are built when the program runs, so that repeatedly
+// concatenating strings can't make a huge .jkb file.
+const maxFoldedString = 1 << 12
+
+// foldExpression returns the value of node if it is made only of literals,
+// and computing it at compile time gives the same result as the VM would. The
+// operators are those of the VM, so that folding never changes what a
+// program does. Expressions that would raise an error are left to raise it
+// when the program runs.
+func foldExpression(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.BooleanLiteral:
+		if node.Value {
+			return object.True, true
+		}
+		return object.False, true
+
+	case *ast.PrefixExpression:
+		right, ok := foldExpression(node.Right)
+		if !ok {
+			return nil, false
+		}
+		switch node.Operator {
+		case "!":
+			if r, ok := right.(object.Booler); ok {
+				return foldResult(r.Bool().Invert())
+			}
+		case "-":
+			if r, ok := right.(object.Negater); ok {
+				return foldResult(r.Negative())
+			}
+		}
+
+	case *ast.InfixExpression:
+		left, ok := foldExpression(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := foldExpression(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+	}
+	return nil, false
+}
+
+func foldInfix(operator string, l, r object.Object) (object.Object, bool) {
+	switch operator {
+	case "+":
+		if left, ok := l.(object.Adder); ok {
+			return foldResult(left.Add(r))
+		}
+	case "-":
+		if left, ok := l.(object.Subber); ok {
+			return foldResult(left.Sub(r))
+		}
+	case "*":
+		if left, ok := l.(object.MultDiver); ok {
+			return foldResult(left.Mult(r))
+		}
+	case "/", "%":
+		if operator == "/" {
+			if left, ok := l.(object.MultDiver); ok {
+				return foldResult(left.Div(r))
+			}
+		} else if left, ok := l.(object.Modder); ok {
+			return foldResult(left.Mod(r))
+		}
+	case "==":
+		if left, ok := l.(object.Equal); ok {
+			return foldResult(left.EQ(r))
+		}
+	case "!=":
+		if left, ok := l.(object.Equal); ok {
+			return foldResult(left.NEQ(r))
+		}
+	case ">":
+		if left, ok := l.(object.Inequality); ok {
+			return foldResult(left.GT(r))
+		}
+	case ">=":
+		if left, ok := l.(object.Inequality); ok {
+			return foldResult(left.GTE(r))
+		}
+	case "<":
+		// compiled with its operands swapped, see Compile
+		if right, ok := r.(object.Inequality); ok {
+			return foldResult(right.GT(l))
+		}
+	case "<=":
+		if right, ok := r.(object.Inequality); ok {
+			return foldResult(right.GTE(l))
+		}
+	}
+	return nil, false
+}
+
+// foldResult returns res if it can be a constant. Errors aren't, so that they
+// are raised when the program runs, and neither are mutable objects, which
+// would be shared by every evaluation of the expression.
+func foldResult(res object.Object) (object.Object, bool) {
+	switch res := res.(type) {
+	case *object.Integer, *object.Float, *object.Boolean, *object.Null:
+		return res, true
+	case *object.String:
+		return res, len(res.Value) <= maxFoldedString
+	default:
+		return nil, false
+	}
+}
+
+// fold returns the value of node if optimizations are enabled and it can be
+// folded
+func (c *Compiler) fold(node ast.Expression) (object.Object, bool) {
+	if !c.optimize {
+		return nil, false
+	}
+	return foldExpression(node)
+}
+
+// emitValue emits the instruction that pushes obj, a result of fold
+func (c *Compiler) emitValue(obj object.Object) {
+	switch obj {
+	case object.True:
+		c.emit(code.OpTrue)
+	case object.False:
+		c.emit(code.OpFalse)
+	case object.NullValue:
+		c.emit(code.OpNull)
+	default:
+		c.emit(code.OpConstant, c.addConstant(obj))
+	}
+}
@@ -0,0 +1,632 @@
+package compiler
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/object"
+)
+
+// Version is the version of the compiler, recorded in the .jkb files it
+// builds
+const Version = "0.1.0"
+
+// FormatVersion is the version of the .jkb file format that MarshalBinary
+// writes. UnmarshalBinary reads only this version. Version 2 changed how
+// closures capture variables, which version 1 files can't run with, and
+// version 3 renumbered the opcodes added since the first release.
+const FormatVersion = 3
+
+// A .jkb file is laid out as
+//
+//	magic             4 bytes, "\x7fJKB"
+//	format version    uint16
+//	flags             uint16
+//	compiler version  uint8 length, then the version
+//	section count     uint8
+//	section table     for each section, its kind as a uint8, then its offset
+//	                  from the start of the file and its length as uint64s
+//	sections
+//	checksum          uint32, the CRC-32 (IEEE) of everything before it
+//
+// Every integer is big endian. Readers skip sections of kinds they don't know.
+//
+// Files written with FlagCompact store integers in the constants, debug and
+// metadata sections as varints, and the strings of constants in a strings
+// section that they index into. With FlagDeflate, everything after the
+// section table is a single deflate stream, and section offsets are where
+// the sections would be if it were inflated in place.
+var magic = []byte("\x7fJKB")
+
+// Flags describe the contents of a .jkb file
+type Flags uint16
+
+const (
+	// FlagLinked is set for a linked program, rather than a module that must
+	// be linked before it can run
+	FlagLinked Flags = 1 << iota
+	// FlagCompact is set when sections use the compact encoding
+	FlagCompact
+	// FlagDeflate is set when the sections are compressed
+	FlagDeflate
+)
+
+// knownFlags are the flags this version of the compiler understands
+const knownFlags = FlagLinked | FlagCompact | FlagDeflate
+
+// SectionKind identifies the contents of a section of a .jkb file
+type SectionKind uint8
+
+const (
+	// SectionCode holds the top level instructions
+	SectionCode SectionKind = iota + 1
+	// SectionConstants holds the constant pool
+	SectionConstants
+	// SectionDebug holds the line table of the top level instructions
+	SectionDebug
+	// SectionMetadata holds the module table, and the requires and imports of
+	// an unlinked module
+	SectionMetadata
+	// SectionStrings holds the string table of compact constants
+	SectionStrings
+)
+
+func (k SectionKind) String() string {
+	switch k {
+	case SectionCode:
+		return "code"
+	case SectionConstants:
+		return "constants"
+	case SectionDebug:
+		return "debug"
+	case SectionMetadata:
+		return "metadata"
+	case SectionStrings:
+		return "strings"
+	default:
+		return fmt.Sprintf("SectionKind(%d)", uint8(k))
+	}
+}
+
+var (
+	// ErrInvalidBytecode is returned when decoding data that isn't a complete,
+	// uncorrupted .jkb file
+	ErrInvalidBytecode = errors.New("invalid bytecode")
+	// ErrBytecodeVersion is returned when decoding a .jkb file written in a
+	// format version that this compiler can't read
+	ErrBytecodeVersion = errors.New("unsupported bytecode version")
+)
+
+func invalidBytecode(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidBytecode}, args...)...)
+}
+
+// Header is the header of a .jkb file
+type Header struct {
+	FormatVersion   int
+	CompilerVersion string
+	Flags           Flags
+	Sections        []Section
+	Checksum        uint32
+
+	// size is the length of the header, up to the end of the section table
+	size int
+}
+
+// Section is an entry of the section table of a .jkb file
+type Section struct {
+	Kind   SectionKind
+	Offset int
+	Length int
+}
+
+func (h Header) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Format version: %d\n", h.FormatVersion)
+	fmt.Fprintf(&sb, "Compiler version: %s\n", h.CompilerVersion)
+	fmt.Fprintf(&sb, "Flags: %s\n", h.Flags)
+	fmt.Fprintf(&sb, "Checksum: %08x\n", h.Checksum)
+	sb.WriteString("Sections:\n")
+	for _, s := range h.Sections {
+		fmt.Fprintf(&sb, "\t%s offset=%d length=%d\n", s.Kind, s.Offset, s.Length)
+	}
+	return sb.String()
+}
+
+func (f Flags) String() string {
+	var names []string
+	if f&FlagLinked != 0 {
+		names = append(names, "linked")
+	}
+	if f&FlagCompact != 0 {
+		names = append(names, "compact")
+	}
+	if f&FlagDeflate != 0 {
+		names = append(names, "deflate")
+	}
+	if unknown := f &^ knownFlags; unknown != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint16(unknown)))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// ReadHeader decodes and validates the header of a .jkb file. The checksum
+// is verified, so an error is returned for a file that has been truncated or
+// corrupted.
+func ReadHeader(data []byte) (Header, error) {
+	var h Header
+	if len(data) < len(magic) || !bytes.Equal(data[:len(magic)], magic) {
+		return h, invalidBytecode("not a .jkb file")
+	}
+	// the format version is checked before anything else, so that files of
+	// other versions are reported as such rather than as corrupt
+	if len(data) < 6 {
+		return h, invalidBytecode("truncated header")
+	}
+	h.FormatVersion = int(binary.BigEndian.Uint16(data[4:]))
+	if h.FormatVersion != FormatVersion {
+		return h, fmt.Errorf("%w: the file is version %d, this compiler reads version %d", ErrBytecodeVersion, h.FormatVersion, FormatVersion)
+	}
+
+	body := data[:len(data)-4]
+	h.Checksum = binary.BigEndian.Uint32(data[len(data)-4:])
+	if sum := crc32.ChecksumIEEE(body); sum != h.Checksum {
+		return h, invalidBytecode("checksum mismatch: got %08x - want %08x, the file is truncated or corrupted", sum, h.Checksum)
+	}
+
+	r := byteReader{data: body, pos: 6}
+	h.Flags = Flags(r.uint16())
+	h.CompilerVersion = string(r.bytes(int(r.uint8())))
+	numSections := int(r.uint8())
+	for i := 0; i < numSections && r.err == nil; i++ {
+		s := Section{Kind: SectionKind(r.uint8()), Offset: r.uint(), Length: r.uint()}
+		h.Sections = append(h.Sections, s)
+	}
+	if r.err != nil {
+		return h, invalidBytecode("invalid header: %s", r.err)
+	}
+	if unknown := h.Flags &^ knownFlags; unknown != 0 {
+		return h, invalidBytecode("unknown flags %#x", uint16(unknown))
+	}
+	h.size = r.pos
+	return h, nil
+}
+
+// maxInflateRatio is how many times larger than the deflate stream the
+// sections of a file may be. Deflate doesn't compress by more than about
+// 1032 to 1, so no file it wrote is larger.
+const maxInflateRatio = 1032
+
+// sections returns the file without its checksum, with its sections inflated
+// if they are compressed, and checks that every section is within it
+func (h Header) sections(data []byte) ([]byte, error) {
+	body := data[:len(data)-4]
+	if h.Flags&FlagDeflate != 0 {
+		size := 0
+		for _, s := range h.Sections {
+			if end := s.Offset + s.Length - h.size; end > size {
+				size = end
+			}
+		}
+		// the section table isn't trusted to say how much to inflate, since a
+		// small stream can inflate to far more than any program needs
+		if limit := maxInflateRatio * (len(body) - h.size); size > limit {
+			return nil, invalidBytecode("the section table needs %d bytes, but the deflated sections can hold at most %d", size, limit)
+		}
+		// reading one byte more than the sections need finds streams that
+		// are too long, without inflating all of them
+		r := flate.NewReader(bytes.NewReader(body[h.size:]))
+		payload, err := io.ReadAll(io.LimitReader(r, int64(size)+1))
+		if err != nil {
+			return nil, invalidBytecode("inflating sections: %s", err)
+		}
+		if len(payload) != size {
+			return nil, invalidBytecode("inflated sections are %d bytes, but the section table needs %d", len(payload), size)
+		}
+		body = append(body[:h.size:h.size], payload...)
+	}
+	for _, s := range h.Sections {
+		if s.Offset < h.size || s.Length > len(body)-s.Offset {
+			return nil, invalidBytecode("%s section is out of bounds", s.Kind)
+		}
+	}
+	return body, nil
+}
+
+// section returns the contents of the section of the given kind, if the file
+// has one
+func (h Header) section(data []byte, kind SectionKind) ([]byte, bool, error) {
+	var found []byte
+	ok := false
+	for _, s := range h.Sections {
+		if s.Kind != kind {
+			continue
+		}
+		if ok {
+			return nil, false, invalidBytecode("duplicate %s section", kind)
+		}
+		found, ok = data[s.Offset:s.Offset+s.Length], true
+	}
+	return found, ok, nil
+}
+
+// EncodeOptions choose how Encode writes a .jkb file
+type EncodeOptions struct {
+	// Compact uses varints and a shared string table, see FlagCompact
+	Compact bool
+	// Deflate compresses the sections, see FlagDeflate
+	Deflate bool
+}
+
+// MarshalBinary encodes b as a .jkb file, without the compact encoding or
+// compression
+func (b Bytecode) MarshalBinary() ([]byte, error) {
+	return b.Encode(EncodeOptions{})
+}
+
+// Encode encodes b as a .jkb file. Files written with any options are read
+// by UnmarshalBinary.
+func (b Bytecode) Encode(opts EncodeOptions) ([]byte, error) {
+	type section struct {
+		kind SectionKind
+		data []byte
+	}
+	sections := []section{{SectionCode, b.Instructions}}
+
+	var flags Flags
+	if b.Linked() {
+		flags |= FlagLinked
+	}
+	if opts.Compact {
+		flags |= FlagCompact
+		cw := object.NewCompactWriter()
+		cw.Uvarint(uint64(len(b.Constants)))
+		for i, c := range b.Constants {
+			if err := cw.Object(c); err != nil {
+				return nil, fmt.Errorf("invalid constant %d: %w", i, err)
+			}
+		}
+		strs := byteWriter{compact: true}
+		strs.uint(len(cw.Strings()))
+		for _, s := range cw.Strings() {
+			strs.string(s)
+		}
+		sections = append(sections,
+			section{SectionStrings, strs.buf},
+			section{SectionConstants, cw.Data()},
+			section{SectionDebug, b.Lines.MarshalCompact()},
+		)
+	} else {
+		lines, err := b.Lines.MarshalBytes()
+		if err != nil {
+			return nil, err
+		}
+		numConst := len(b.Constants)
+		consts := make([]byte, 8, 8+(numConst*9))
+		binary.BigEndian.PutUint64(consts, uint64(numConst))
+		for i, c := range b.Constants {
+			b, err := object.Encode(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constant %d: %w", i, err)
+			}
+			consts = append(consts, b...)
+		}
+		sections = append(sections,
+			section{SectionConstants, consts},
+			section{SectionDebug, lines},
+		)
+	}
+	sections = append(sections, section{SectionMetadata, b.marshalModules(opts.Compact)})
+	if opts.Deflate {
+		flags |= FlagDeflate
+	}
+
+	w := byteWriter{buf: append([]byte(nil), magic...)}
+	w.uint16(FormatVersion)
+	w.uint16(int(flags))
+	w.uint8(len(Version))
+	w.buf = append(w.buf, Version...)
+	w.uint8(len(sections))
+
+	offset := len(w.buf) + len(sections)*17
+	for _, s := range sections {
+		w.uint8(int(s.kind))
+		w.uint(offset)
+		w.uint(len(s.data))
+		offset += len(s.data)
+	}
+	if opts.Deflate {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sections {
+			if _, err := fw.Write(s.data); err != nil {
+				return nil, err
+			}
+		}
+		if err := fw.Close(); err != nil {
+			return nil, err
+		}
+		w.buf = append(w.buf, buf.Bytes()...)
+	} else {
+		for _, s := range sections {
+			w.buf = append(w.buf, s.data...)
+		}
+	}
+	w.uint32(crc32.ChecksumIEEE(w.buf))
+	return w.buf, nil
+}
+
+// UnmarshalBinary decodes a .jkb file written with any EncodeOptions, and
+// verifies its instructions. Errors wrap ErrBytecodeVersion if the file is of
+// another version of the format, and ErrInvalidBytecode if it is otherwise
+// invalid.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	h, err := ReadHeader(data)
+	if err != nil {
+		return err
+	}
+	if data, err = h.sections(data); err != nil {
+		return err
+	}
+	*b = Bytecode{}
+	compact := h.Flags&FlagCompact != 0
+
+	required := []SectionKind{SectionCode, SectionConstants, SectionMetadata}
+	if compact {
+		required = append(required, SectionStrings)
+	}
+	for _, kind := range required {
+		if _, ok, err := h.section(data, kind); err != nil {
+			return err
+		} else if !ok {
+			return invalidBytecode("missing %s section", kind)
+		}
+	}
+
+	ins, _, _ := h.section(data, SectionCode)
+	b.Instructions = append(code.Instructions(nil), ins...)
+
+	lines, ok, err := h.section(data, SectionDebug)
+	if err != nil {
+		return err
+	}
+	if ok {
+		var n int
+		if compact {
+			n, err = b.Lines.UnmarshalCompact(lines)
+		} else {
+			n, err = b.Lines.UnmarshalBytes(lines)
+		}
+		if err != nil {
+			return invalidBytecode("debug section: %s", err)
+		}
+		if n != len(lines) {
+			return invalidBytecode("debug section: %d unexpected bytes", len(lines)-n)
+		}
+	}
+
+	consts, _, _ := h.section(data, SectionConstants)
+	if compact {
+		strs, _, _ := h.section(data, SectionStrings)
+		table, err := unmarshalStrings(strs)
+		if err != nil {
+			return invalidBytecode("strings section: %s", err)
+		}
+		if err := b.unmarshalCompactConstants(consts, table); err != nil {
+			return invalidBytecode("constants section: %s", err)
+		}
+	} else if err := b.unmarshalConstants(consts); err != nil {
+		return invalidBytecode("constants section: %s", err)
+	}
+
+	meta, _, _ := h.section(data, SectionMetadata)
+	n, err := b.unmarshalModules(meta, compact)
+	if err != nil {
+		return invalidBytecode("metadata section: %s", err)
+	}
+	if n != len(meta) {
+		return invalidBytecode("metadata section: %d unexpected bytes", len(meta)-n)
+	}
+	if b.Linked() != (h.Flags&FlagLinked != 0) {
+		return invalidBytecode("linked flag doesn't match the metadata")
+	}
+	// the file may not have been built by this compiler, so its instructions
+	// are checked before anything runs them
+	return b.Verify()
+}
+
+func (b *Bytecode) unmarshalConstants(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("not enough data")
+	}
+	numConsts := binary.BigEndian.Uint64(data)
+	if numConsts > uint64(len(data)) {
+		return fmt.Errorf("invalid number of constants %d", numConsts)
+	}
+	ptr := 8
+	b.Constants = make([]object.Object, 0, numConsts)
+	for i := 0; i < int(numConsts); i++ {
+		obj, read, err := object.Decode(data[ptr:])
+		if err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+		ptr += read
+		b.Constants = append(b.Constants, obj)
+	}
+	if ptr != len(data) {
+		return fmt.Errorf("%d unexpected bytes", len(data)-ptr)
+	}
+	return nil
+}
+
+func (b *Bytecode) unmarshalCompactConstants(data []byte, strings []string) error {
+	r := object.NewCompactReader(data, strings)
+	b.Constants = make([]object.Object, r.Count())
+	for i := range b.Constants {
+		b.Constants[i] = r.Object()
+		if r.Err() != nil {
+			return fmt.Errorf("constant %d: %w", i, r.Err())
+		}
+	}
+	if r.Err() != nil {
+		return r.Err()
+	}
+	if n := r.Remaining(); n != 0 {
+		return fmt.Errorf("%d unexpected bytes", n)
+	}
+	return nil
+}
+
+func unmarshalStrings(data []byte) ([]string, error) {
+	r := byteReader{data: data, compact: true}
+	table := make([]string, r.count())
+	for i := range table {
+		table[i] = r.string()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("%d unexpected bytes", len(data)-r.pos)
+	}
+	return table, nil
+}
+
+// byteWriter writes integers as uint64s, or as varints if compact is set
+type byteWriter struct {
+	buf     []byte
+	compact bool
+}
+
+func (w *byteWriter) uint(n int) {
+	if w.compact {
+		var b [binary.MaxVarintLen64]byte
+		w.buf = append(w.buf, b[:binary.PutUvarint(b[:], uint64(n))]...)
+		return
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(n))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *byteWriter) uint8(n int) {
+	w.buf = append(w.buf, byte(n))
+}
+
+func (w *byteWriter) uint16(n int) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(n))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *byteWriter) uint32(n uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *byteWriter) string(s string) {
+	w.uint(len(s))
+	w.buf = append(w.buf, s...)
+}
+
+// byteReader reads what a byteWriter wrote. Once a read fails, err is set
+// and every later read returns the zero value.
+type byteReader struct {
+	data    []byte
+	pos     int
+	err     error
+	compact bool
+}
+
+func (r *byteReader) uint() int {
+	if r.err != nil {
+		return 0
+	}
+	var n uint64
+	if r.compact {
+		var read int
+		n, read = binary.Uvarint(r.data[r.pos:])
+		if read <= 0 {
+			r.err = errors.New("invalid varint")
+			return 0
+		}
+		r.pos += read
+	} else {
+		if len(r.data)-r.pos < 8 {
+			r.err = errors.New("not enough data")
+			return 0
+		}
+		n = binary.BigEndian.Uint64(r.data[r.pos:])
+		r.pos += 8
+	}
+	if n > math.MaxInt32 {
+		r.err = fmt.Errorf("invalid value %d", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *byteReader) uint8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *byteReader) uint16() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+// bytes reads the next n bytes
+func (r *byteReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data)-r.pos < n {
+		r.err = errors.New("not enough data")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// count reads the number of items that follow, or the length of a string
+func (r *byteReader) count() int {
+	n := r.uint()
+	if n > len(r.data)-r.pos {
+		// every item takes at least a byte, so there can't be more of them
+		// than there is data left
+		r.err = fmt.Errorf("invalid length %d", n)
+		return 0
+	}
+	return n
+}
+
+func (r *byteReader) string() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	return string(r.bytes(n))
+}
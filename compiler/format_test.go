@@ -0,0 +1,386 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/object"
+)
+
+func TestBytecode_MarshalBinary(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse("let s = \"hi\";\nfn add(a, b) { return a + b; }\nadd(1, 2.5);")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	h, err := ReadHeader(data)
+	if err != nil {
+		t.Fatalf("header error: %s", err)
+	}
+	if h.FormatVersion != FormatVersion || h.CompilerVersion != Version || h.Flags != FlagLinked {
+		t.Errorf("invalid header: got %+v", h)
+	}
+	var kinds []SectionKind
+	for _, s := range h.Sections {
+		kinds = append(kinds, s.Kind)
+	}
+	if want := []SectionKind{SectionCode, SectionConstants, SectionDebug, SectionMetadata}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("invalid sections: got %v - want %v", kinds, want)
+	}
+
+	var got Bytecode
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+	if err := testInstructions([]code.Instructions{bytecode.Instructions}, got.Instructions); err != nil {
+		t.Errorf("test instructions failed: %s", err)
+	}
+	if !reflect.DeepEqual(got.Lines, bytecode.Lines) {
+		t.Errorf("invalid lines: got %v - want %v", got.Lines, bytecode.Lines)
+	}
+	if !reflect.DeepEqual(got.Constants, bytecode.Constants) {
+		t.Errorf("invalid constants: got %v - want %v", got.Constants, bytecode.Constants)
+	}
+	if !reflect.DeepEqual(got.Modules, bytecode.Modules) {
+		t.Errorf("invalid modules: got %v - want %v", got.Modules, bytecode.Modules)
+	}
+}
+
+func TestBytecode_MarshalBinaryConstants(t *testing.T) {
+	key := &object.String{Value: "k"}
+	bytecode := &Bytecode{
+		Instructions: concatInstructions([]code.Instructions{
+			code.Instruction(code.OpConstant, 0),
+			code.Instruction(code.OpConstant, 1),
+			code.Instruction(code.OpConstant, 2),
+			code.Instruction(code.OpConstant, 3),
+			code.Instruction(code.OpArray, 4),
+			code.Instruction(code.OpPop),
+		}),
+		Constants: []object.Object{
+			object.True,
+			object.NullValue,
+			&object.Array{Elements: []object.Object{&object.Integer{Value: 1}, object.False}},
+			&object.Map{Pairs: map[object.HashKey]object.HashPair{key.HashKey(): {Key: key, Value: &object.Float{Value: 2.5}}}},
+		},
+	}
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	var got Bytecode
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+	if !reflect.DeepEqual(got.Constants, bytecode.Constants) {
+		t.Errorf("invalid constants: got %v - want %v", got.Constants, bytecode.Constants)
+	}
+	if got.Constants[0] != object.True || got.Constants[1] != object.NullValue {
+		t.Errorf("booleans and null must decode to their singletons")
+	}
+
+	bytecode.Constants = append(bytecode.Constants, &object.Closure{})
+	if _, err := bytecode.MarshalBinary(); err == nil || err.Error() != "invalid constant 4: cannot encode ClosureType" {
+		t.Errorf("invalid error: got %v - want invalid constant 4: cannot encode ClosureType", err)
+	}
+}
+
+func TestBytecode_Encode(t *testing.T) {
+	c := New()
+	input := `
+let greet = fn(name) { return "hello, " + name; };
+let names = ["ann", "bob", "hello, "];
+let counts = {"ann": 1, "bob": -2};
+for let i = 0; i < 300; i = i + 1; { greet(names[i % 2]); }
+`
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+	plain, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  EncodeOptions
+		flags Flags
+	}{
+		{"default", EncodeOptions{}, FlagLinked},
+		{"compact", EncodeOptions{Compact: true}, FlagLinked | FlagCompact},
+		{"deflate", EncodeOptions{Deflate: true}, FlagLinked | FlagDeflate},
+		{"compact and deflate", EncodeOptions{Compact: true, Deflate: true}, FlagLinked | FlagCompact | FlagDeflate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bytecode.Encode(tt.opts)
+			if err != nil {
+				t.Fatalf("encode error: %s", err)
+			}
+			h, err := ReadHeader(data)
+			if err != nil {
+				t.Fatalf("header error: %s", err)
+			}
+			if h.Flags != tt.flags {
+				t.Errorf("invalid flags: got %s - want %s", h.Flags, tt.flags)
+			}
+			if tt.opts != (EncodeOptions{}) && len(data) >= len(plain) {
+				t.Errorf("encoding isn't smaller: got %d bytes - default is %d", len(data), len(plain))
+			}
+
+			var got Bytecode
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatalf("unmarshal error: %s", err)
+			}
+			if err := testInstructions([]code.Instructions{bytecode.Instructions}, got.Instructions); err != nil {
+				t.Errorf("test instructions failed: %s", err)
+			}
+			if !reflect.DeepEqual(got.Lines, bytecode.Lines) {
+				t.Errorf("invalid lines: got %v - want %v", got.Lines, bytecode.Lines)
+			}
+			if !reflect.DeepEqual(got.Constants, bytecode.Constants) {
+				t.Errorf("invalid constants: got %v - want %v", got.Constants, bytecode.Constants)
+			}
+			if !reflect.DeepEqual(got.Modules, bytecode.Modules) {
+				t.Errorf("invalid modules: got %v - want %v", got.Modules, bytecode.Modules)
+			}
+		})
+	}
+}
+
+func TestBytecode_UnmarshalBinaryErrors(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse(`let x = "hello"; x + " world"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := c.Bytecode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	h, err := ReadHeader(data)
+	if err != nil {
+		t.Fatalf("header error: %s", err)
+	}
+	var constants, ins Section
+	for _, s := range h.Sections {
+		switch s.Kind {
+		case SectionConstants:
+			constants = s
+		case SectionCode:
+			ins = s
+		}
+	}
+
+	// edit returns a copy of data changed by f, with a valid checksum
+	edit := func(f func(data []byte) []byte) []byte {
+		out := f(append([]byte(nil), data[:len(data)-4]...))
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(out))
+		return append(out, sum...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+		msg  string
+	}{
+		{
+			name: "empty",
+			data: nil,
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: not a .jkb file",
+		},
+		{
+			name: "not bytecode",
+			data: []byte("let x = 1;"),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: not a .jkb file",
+		},
+		{
+			name: "older version",
+			data: edit(func(data []byte) []byte {
+				binary.BigEndian.PutUint16(data[4:], FormatVersion-1)
+				return data
+			}),
+			want: ErrBytecodeVersion,
+			msg:  "unsupported bytecode version: the file is version 2, this compiler reads version 3",
+		},
+		{
+			name: "truncated",
+			data: data[:len(data)-10],
+			want: ErrInvalidBytecode,
+		},
+		{
+			name: "corrupted",
+			data: func() []byte {
+				out := append([]byte(nil), data...)
+				out[constants.Offset+9]++
+				return out
+			}(),
+			want: ErrInvalidBytecode,
+		},
+		{
+			name: "unknown flags",
+			data: edit(func(data []byte) []byte {
+				data[7] |= 0x80
+				return data
+			}),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: unknown flags 0x80",
+		},
+		{
+			name: "section out of bounds",
+			data: edit(func(data []byte) []byte {
+				return data[:len(data)-1]
+			}),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: metadata section is out of bounds",
+		},
+		{
+			name: "missing section",
+			data: edit(func(data []byte) []byte {
+				// the constants section is given a kind that readers skip
+				data[len(magic)+6+len(Version)+17] = 0xff
+				return data
+			}),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: missing constants section",
+		},
+		{
+			name: "invalid constant",
+			data: edit(func(data []byte) []byte {
+				data[constants.Offset+8] = 0xff
+				return data
+			}),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: constants section: constant 0: cannot decode Type(255)",
+		},
+		{
+			name: "truncated constant",
+			data: edit(func(data []byte) []byte {
+				binary.BigEndian.PutUint64(data[constants.Offset+9:], 1000)
+				return data
+			}),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: constants section: constant 0: invalid string: not enough data",
+		},
+		{
+			name: "invalid instructions",
+			data: edit(func(data []byte) []byte {
+				data[ins.Offset] = 0xff
+				return data
+			}),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: <main>: 0000: opcode 255 undefined",
+		},
+	}
+
+	compact, err := c.Bytecode().Encode(EncodeOptions{Compact: true})
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	deflated, err := c.Bytecode().Encode(EncodeOptions{Deflate: true})
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	// withChecksum returns data with a valid checksum added
+	withChecksum := func(data []byte) []byte {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(data))
+		return append(data, sum...)
+	}
+	h, err = ReadHeader(compact)
+	if err != nil {
+		t.Fatalf("header error: %s", err)
+	}
+	var compactConstants Section
+	for _, s := range h.Sections {
+		if s.Kind == SectionConstants {
+			compactConstants = s
+		}
+	}
+	tests = append(tests, []struct {
+		name string
+		data []byte
+		want error
+		msg  string
+	}{
+		{
+			name: "string out of range",
+			data: func() []byte {
+				out := append([]byte(nil), compact[:len(compact)-4]...)
+				// the first constant is "hello", which is the first of the two
+				// strings in the table
+				out[compactConstants.Offset+2] = 2
+				return withChecksum(out)
+			}(),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: constants section: constant 0: string 2 out of range, there are 2",
+		},
+		{
+			name: "invalid deflate stream",
+			data: func() []byte {
+				h, _ := ReadHeader(deflated)
+				out := append([]byte(nil), deflated[:h.size]...)
+				return withChecksum(append(out, 0xff, 0xff))
+			}(),
+			want: ErrInvalidBytecode,
+		},
+		{
+			name: "short deflate stream",
+			data: func() []byte {
+				var b Bytecode
+				if err := b.UnmarshalBinary(deflated); err != nil {
+					t.Fatalf("unmarshal error: %s", err)
+				}
+				b.Instructions = b.Instructions[:0]
+				short, err := b.Encode(EncodeOptions{Deflate: true})
+				if err != nil {
+					t.Fatalf("encode error: %s", err)
+				}
+				// the header of the full program, with the sections of an
+				// empty one
+				h, _ := ReadHeader(short)
+				out := append([]byte(nil), deflated[:h.size]...)
+				return withChecksum(append(out, short[h.size:len(short)-4]...))
+			}(),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: inflated sections are 166 bytes, but the section table needs 180",
+		},
+		{
+			name: "deflate stream too small for the section table",
+			data: func() []byte {
+				h, _ := ReadHeader(deflated)
+				out := append([]byte(nil), deflated[:len(deflated)-4]...)
+				// the length of the last section is the end of the table
+				binary.BigEndian.PutUint64(out[h.size-8:], 1<<30)
+				return withChecksum(out)
+			}(),
+			want: ErrInvalidBytecode,
+			msg:  "invalid bytecode: the section table needs 1073741899 bytes, but the deflated sections can hold at most 68112",
+		},
+	}...)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Bytecode
+			err := b.UnmarshalBinary(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("invalid error: got %v - want %s", err, tt.want)
+			}
+			if tt.msg != "" && err.Error() != tt.msg {
+				t.Errorf("invalid error message: got %q - want %q", err, tt.msg)
+			}
+		})
+	}
+}
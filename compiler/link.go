@@ -0,0 +1,388 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/object"
+)
+
+// Module is an entry in the module table of a Bytecode. Each module's top
+// level instructions, constants and globals are stored together, in the
+// ranges given here.
+type Module struct {
+	// Name is the module's path relative to the program's main file
+	Name         string
+	Instructions Range
+	Constants    Range
+	Globals      Range
+	// Exports maps the names of the module's globals to their index
+	Exports map[string]int
+}
+
+// Range is the half open range [Start, End)
+type Range struct {
+	Start, End int
+}
+
+func (r Range) Len() int            { return r.End - r.Start }
+func (r Range) Contains(i int) bool { return r.Start <= i && i < r.End }
+func (r Range) String() string      { return fmt.Sprintf("[%d, %d)", r.Start, r.End) }
+
+func newRange(start, length int) Range {
+	return Range{Start: start, End: start + length}
+}
+
+// Import is a global of another module that an unlinked module uses. The
+// module refers to it by the index of a global of its own, Global, which the
+// linker replaces.
+type Import struct {
+	Module string
+	Name   string
+	Global int
+}
+
+// Linked reports whether b is ready to run, rather than a module that still
+// needs to be linked with the modules it imports
+func (b *Bytecode) Linked() bool {
+	return len(b.Requires) == 0 && len(b.Imports) == 0
+}
+
+// Link links main with every module it imports, directly or indirectly, into
+// a single program. Modules are loaded by name with load, and must not
+// themselves be linked.
+//
+// The program runs the top level instructions of each module once, after
+// those of the modules it imports, and ends with main's. main's constants and
+// globals keep their indices, so the globals main defines can still be found
+// with its symbol table.
+func Link(main *Bytecode, load func(name string) (*Bytecode, error)) (*Bytecode, error) {
+	l := &linker{load: load, index: make(map[string]int)}
+	if err := l.add(main); err != nil {
+		return nil, err
+	}
+	if err := l.visit(0); err != nil {
+		return nil, err
+	}
+	return l.link()
+}
+
+// FileLoader returns a loader for Link that reads modules from the .jkb files
+// built for them. A module's file is looked for relative to dir, the
+// directory of the program's main file, then to each directory of searchPath.
+func FileLoader(dir string, searchPath ...string) func(name string) (*Bytecode, error) {
+	return func(name string) (*Bytecode, error) {
+		filename := filepath.FromSlash(name) + "b"
+		candidates := []string{filename}
+		if !filepath.IsAbs(filename) {
+			candidates = candidates[:0]
+			for _, d := range append([]string{dir}, searchPath...) {
+				candidates = append(candidates, filepath.Join(d, filename))
+			}
+		}
+		for _, candidate := range candidates {
+			data, err := os.ReadFile(candidate)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			var obj Bytecode
+			if err := obj.UnmarshalBinary(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", candidate, err)
+			}
+			return &obj, nil
+		}
+		return nil, fmt.Errorf("module not found: %s", name)
+	}
+}
+
+type linker struct {
+	load func(string) (*Bytecode, error)
+	// objs are the modules being linked, in the order their constants and
+	// globals are laid out, and index maps their names to their position
+	objs  []*Bytecode
+	index map[string]int
+	// order is the order the modules are run in, and visiting the modules
+	// whose imports are still being visited
+	order    []int
+	visiting []bool
+}
+
+func (l *linker) add(obj *Bytecode) error {
+	if len(obj.Modules) != 1 {
+		return fmt.Errorf("cannot link bytecode with %d modules, it must have exactly one", len(obj.Modules))
+	}
+	l.index[obj.Modules[0].Name] = len(l.objs)
+	l.objs = append(l.objs, obj)
+	l.visiting = append(l.visiting, false)
+	return nil
+}
+
+// visit orders the modules the module at idx imports before it
+func (l *linker) visit(idx int) error {
+	l.visiting[idx] = true
+	for _, name := range l.objs[idx].Requires {
+		dep, ok := l.index[name]
+		if !ok {
+			obj, err := l.load(name)
+			if err != nil {
+				return err
+			}
+			if err := l.add(obj); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			dep = len(l.objs) - 1
+			if err := l.visit(dep); err != nil {
+				return err
+			}
+			continue
+		}
+		if l.visiting[dep] {
+			return fmt.Errorf("import cycle: %s imports %s", l.objs[idx].Modules[0].Name, name)
+		}
+	}
+	l.visiting[idx] = false
+	l.order = append(l.order, idx)
+	return nil
+}
+
+func (l *linker) link() (*Bytecode, error) {
+	out := &Bytecode{Modules: make([]Module, len(l.objs))}
+
+	// constants and globals are laid out in the order modules were found
+	numGlobals := 0
+	for i, obj := range l.objs {
+		mod := obj.Modules[0]
+		out.Modules[i] = Module{
+			Name:      mod.Name,
+			Constants: newRange(len(out.Constants), len(obj.Constants)),
+			Globals:   newRange(numGlobals, mod.Globals.Len()),
+		}
+		out.Constants = append(out.Constants, obj.Constants...)
+		numGlobals += mod.Globals.Len()
+	}
+	if len(out.Constants) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many constants: %d, the limit is %d", len(out.Constants), math.MaxUint16)
+	}
+	if numGlobals > math.MaxUint16 {
+		return nil, fmt.Errorf("too many globals: %d, the limit is %d", numGlobals, math.MaxUint16)
+	}
+
+	for i, obj := range l.objs {
+		exports := make(map[string]int, len(obj.Modules[0].Exports))
+		for name, idx := range obj.Modules[0].Exports {
+			exports[name] = idx + out.Modules[i].Globals.Start
+		}
+		out.Modules[i].Exports = exports
+	}
+
+	// then the functions of each module, which can refer to the globals of
+	// any module, are relocated
+	for i, obj := range l.objs {
+		globals, err := l.globals(i, out.Modules)
+		if err != nil {
+			return nil, err
+		}
+		rel := relocation{constants: out.Modules[i].Constants.Start, globals: globals}
+		for j, constant := range obj.Constants {
+			fn, ok := constant.(*object.CompiledFunction)
+			if !ok {
+				continue
+			}
+			relocated := *fn
+			if relocated.Instructions, err = rel.apply(fn.Instructions); err != nil {
+				return nil, fmt.Errorf("%s: %w", obj.Modules[0].Name, err)
+			}
+			out.Constants[out.Modules[i].Constants.Start+j] = &relocated
+		}
+	}
+
+	// and finally the top level instructions, in the order modules are run
+	for _, i := range l.order {
+		obj := l.objs[i]
+		globals, err := l.globals(i, out.Modules)
+		if err != nil {
+			return nil, err
+		}
+		start := len(out.Instructions)
+		rel := relocation{constants: out.Modules[i].Constants.Start, globals: globals, jumps: start}
+		ins, err := rel.apply(obj.Instructions)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", obj.Modules[0].Name, err)
+		}
+		out.Instructions = append(out.Instructions, ins...)
+		for _, entry := range obj.Lines {
+			out.Lines = append(out.Lines, code.LineEntry{Offset: entry.Offset + start, Line: entry.Line})
+		}
+		out.Modules[i].Instructions = newRange(start, len(ins))
+	}
+	return out, nil
+}
+
+// globals returns the index in the linked program of each of the globals of
+// the module at idx, including those it imports
+func (l *linker) globals(idx int, mods []Module) ([]int, error) {
+	obj := l.objs[idx]
+	globals := make([]int, obj.Modules[0].Globals.Len())
+	for i := range globals {
+		globals[i] = mods[idx].Globals.Start + i
+	}
+	for _, imp := range obj.Imports {
+		dep, ok := l.index[imp.Module]
+		if !ok {
+			return nil, fmt.Errorf("%s: imports %s, which it doesn't require", obj.Modules[0].Name, imp.Module)
+		}
+		global, ok := mods[dep].Exports[imp.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s: module %s has no global %s", obj.Modules[0].Name, imp.Module, imp.Name)
+		}
+		if imp.Global < 0 || imp.Global >= len(globals) {
+			return nil, fmt.Errorf("%s: invalid global for import of %s.%s: %d", obj.Modules[0].Name, imp.Module, imp.Name, imp.Global)
+		}
+		globals[imp.Global] = global
+	}
+	return globals, nil
+}
+
+// relocation rewrites the operands of instructions that refer to constants,
+// globals or other instructions, for their new positions in a linked program
+type relocation struct {
+	// constants and jumps are added to the indices of constants and the
+	// targets of jumps
+	constants int
+	jumps     int
+	// globals maps the module's globals to the program's
+	globals []int
+}
+
+func (r relocation) apply(ins code.Instructions) (code.Instructions, error) {
+	out := make(code.Instructions, 0, len(ins))
+	for i := 0; i < len(ins); {
+		op := code.Opcode(ins[i])
+		widths, err := code.OpWidths(ins[i])
+		if err != nil {
+			return nil, err
+		}
+		if i+1+sum(widths) > len(ins) {
+			return nil, fmt.Errorf("%s at %d: missing operands", op, i)
+		}
+		operands, read := code.ReadOperands(widths, ins[i+1:])
+		switch op {
+		case code.OpConstant, code.OpClosure:
+			operands[0] += r.constants
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if operands[0] >= len(r.globals) {
+				return nil, fmt.Errorf("%s at %d: invalid global %d", op, i, operands[0])
+			}
+			operands[0] = r.globals[operands[0]]
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpTry:
+			operands[0] += r.jumps
+		}
+		out = append(out, code.Instruction(op, operands...)...)
+		i += 1 + read
+	}
+	return out, nil
+}
+
+func sum(widths []int) int {
+	n := 0
+	for _, w := range widths {
+		n += w
+	}
+	return n
+}
+
+// moduleTableString formats the module table for Bytecode.String
+func moduleTableString(mods []Module) string {
+	var sb strings.Builder
+	for _, mod := range mods {
+		name := mod.Name
+		if name == "" {
+			name = "<main>"
+		}
+		fmt.Fprintf(&sb, "\t%s instructions=%s constants=%s globals=%s\n", name, mod.Instructions, mod.Constants, mod.Globals)
+		exports := make([]string, 0, len(mod.Exports))
+		for export := range mod.Exports {
+			exports = append(exports, export)
+		}
+		sort.Strings(exports)
+		for _, export := range exports {
+			fmt.Fprintf(&sb, "\t\t%s = global %d\n", export, mod.Exports[export])
+		}
+	}
+	return sb.String()
+}
+
+// marshalModules encodes the module table, along with the requires and
+// imports of an unlinked module, with varints if compact is set
+func (b Bytecode) marshalModules(compact bool) []byte {
+	w := byteWriter{compact: compact}
+	w.uint(len(b.Modules))
+	for _, mod := range b.Modules {
+		w.string(mod.Name)
+		for _, r := range []Range{mod.Instructions, mod.Constants, mod.Globals} {
+			w.uint(r.Start)
+			w.uint(r.End)
+		}
+		exports := make([]string, 0, len(mod.Exports))
+		for name := range mod.Exports {
+			exports = append(exports, name)
+		}
+		// sorted, so that building the same program gives the same bytes
+		sort.Strings(exports)
+		w.uint(len(exports))
+		for _, name := range exports {
+			w.string(name)
+			w.uint(mod.Exports[name])
+		}
+	}
+	w.uint(len(b.Requires))
+	for _, name := range b.Requires {
+		w.string(name)
+	}
+	w.uint(len(b.Imports))
+	for _, imp := range b.Imports {
+		w.string(imp.Module)
+		w.string(imp.Name)
+		w.uint(imp.Global)
+	}
+	return w.buf
+}
+
+func (b *Bytecode) unmarshalModules(data []byte, compact bool) (int, error) {
+	r := byteReader{data: data, compact: compact}
+	b.Modules = make([]Module, r.count())
+	for i := range b.Modules {
+		mod := &b.Modules[i]
+		mod.Name = r.string()
+		for _, rng := range []*Range{&mod.Instructions, &mod.Constants, &mod.Globals} {
+			rng.Start, rng.End = r.uint(), r.uint()
+		}
+		numExports := r.count()
+		mod.Exports = make(map[string]int, numExports)
+		for j := 0; j < numExports && r.err == nil; j++ {
+			name := r.string()
+			mod.Exports[name] = r.uint()
+		}
+	}
+	b.Requires = make([]string, r.count())
+	for i := range b.Requires {
+		b.Requires[i] = r.string()
+	}
+	b.Imports = make([]Import, r.count())
+	for i := range b.Imports {
+		b.Imports[i] = Import{Module: r.string(), Name: r.string(), Global: r.uint()}
+	}
+	if r.err != nil {
+		return 0, fmt.Errorf("invalid module table: %w", r.err)
+	}
+	return r.pos, nil
+}
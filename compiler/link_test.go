@@ -0,0 +1,215 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jimmykodes/joker/code"
+)
+
+func TestLink(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib/math.jk": `let pi = 3; fn area(r) { return pi * r * r; }`,
+	})
+	c := New(WithFilename(filepath.Join(dir, "main.jk")))
+	if err := c.Compile(parse(`import "lib/math"; let x = 0; if true { x = math.area(2); }`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode, err := c.Link()
+	if err != nil {
+		t.Fatalf("link error: %s", err)
+	}
+	if !bytecode.Linked() {
+		t.Errorf("linked bytecode should be linked")
+	}
+
+	// math's constants and globals are laid out after main's, but its
+	// instructions run first
+	expectedConstants := []any{
+		0,
+		2,
+		3,
+		[]code.Instructions{
+			code.Instruction(code.OpGetGlobal, 2),
+			code.Instruction(code.OpGetLocal, 0),
+			code.Instruction(code.OpMult),
+			code.Instruction(code.OpGetLocal, 0),
+			code.Instruction(code.OpMult),
+			code.Instruction(code.OpReturn),
+		},
+	}
+	expectedInstructions := []code.Instructions{
+		code.Instruction(code.OpConstant, 2),
+		code.Instruction(code.OpSetGlobal, 2),
+		code.Instruction(code.OpClosure, 3, 0),
+		code.Instruction(code.OpSetGlobal, 3),
+		code.Instruction(code.OpConstant, 0),
+		code.Instruction(code.OpSetGlobal, 0),
+		code.Instruction(code.OpTrue),
+		code.Instruction(code.OpJumpNotTruthy, 34),
+		code.Instruction(code.OpGetGlobal, 3),
+		code.Instruction(code.OpConstant, 1),
+		code.Instruction(code.OpCall, 1),
+		code.Instruction(code.OpSetGlobal, 0),
+	}
+	if err := testInstructions(expectedInstructions, bytecode.Instructions); err != nil {
+		t.Errorf("test instructions failed: %s", err)
+	}
+	if err := testConstants(expectedConstants, bytecode.Constants); err != nil {
+		t.Errorf("test constants failed: %s", err)
+	}
+
+	expectedModules := []Module{
+		{
+			Name:         "main.jk",
+			Instructions: Range{13, 34},
+			Constants:    Range{0, 2},
+			Globals:      Range{0, 2},
+			Exports:      map[string]int{"x": 0},
+		},
+		{
+			Name:         "lib/math.jk",
+			Instructions: Range{0, 13},
+			Constants:    Range{2, 4},
+			Globals:      Range{2, 4},
+			Exports:      map[string]int{"pi": 2, "area": 3},
+		},
+	}
+	if !reflect.DeepEqual(bytecode.Modules, expectedModules) {
+		t.Errorf("invalid modules: got %v - want %v", bytecode.Modules, expectedModules)
+	}
+
+	// the compiled module itself is left as it was
+	expectedModuleConstants := []any{
+		3,
+		[]code.Instructions{
+			code.Instruction(code.OpGetGlobal, 0),
+			code.Instruction(code.OpGetLocal, 0),
+			code.Instruction(code.OpMult),
+			code.Instruction(code.OpGetLocal, 0),
+			code.Instruction(code.OpMult),
+			code.Instruction(code.OpReturn),
+		},
+	}
+	if err := testConstants(expectedModuleConstants, c.Modules()[0].Constants); err != nil {
+		t.Errorf("test module constants failed: %s", err)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"math.jk": `let pi = 3;`,
+		"a.jk":    `let x = 1;`,
+	})
+	c := New(WithFilename(filepath.Join(dir, "main.jk")))
+	if err := c.Compile(parse(`import "math"; import "a"; math.pi + a.x`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	main, math, a := c.Bytecode(), c.Modules()[0], c.Modules()[1]
+
+	errs := []struct {
+		name string
+		load func(string) (*Bytecode, error)
+		err  string
+	}{
+		{
+			name: "missing module",
+			load: func(name string) (*Bytecode, error) {
+				if name == "a.jk" {
+					return nil, errors.New("module not found: a.jk")
+				}
+				return math, nil
+			},
+			err: "module not found: a.jk",
+		},
+		{
+			name: "missing global",
+			load: func(name string) (*Bytecode, error) {
+				if name == "a.jk" {
+					return &Bytecode{Modules: []Module{{Name: "a.jk"}}}, nil
+				}
+				return math, nil
+			},
+			err: "main.jk: module a.jk has no global x",
+		},
+		{
+			name: "cycle",
+			load: func(name string) (*Bytecode, error) {
+				if name == "a.jk" {
+					cycle := *a
+					cycle.Requires = []string{"main.jk"}
+					return &cycle, nil
+				}
+				return math, nil
+			},
+			err: "import cycle: a.jk imports main.jk",
+		},
+		{
+			name: "linked module",
+			load: func(name string) (*Bytecode, error) {
+				return &Bytecode{}, nil
+			},
+			err: "math.jk: cannot link bytecode with 0 modules, it must have exactly one",
+		},
+	}
+	for _, tt := range errs {
+		_, err := Link(main, tt.load)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("invalid error for %s: got %v - want %s", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestBytecode_MarshalModules(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"math.jk": `let pi = 3;`,
+	})
+	c := New(WithFilename(filepath.Join(dir, "main.jk")))
+	if err := c.Compile(parse(`import "math"; let tau = math.pi * 2;`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	linked, err := c.Link()
+	if err != nil {
+		t.Fatalf("link error: %s", err)
+	}
+	for _, bytecode := range []*Bytecode{c.Bytecode(), c.Modules()[0], linked} {
+		data, err := bytecode.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal error: %s", err)
+		}
+		var got Bytecode
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("unmarshal error: %s", err)
+		}
+		if !reflect.DeepEqual(got.Modules, bytecode.Modules) {
+			t.Errorf("invalid modules: got %v - want %v", got.Modules, bytecode.Modules)
+		}
+		if fmt.Sprint(got.Requires, got.Imports) != fmt.Sprint(bytecode.Requires, bytecode.Imports) {
+			t.Errorf("invalid requires and imports: got %v %v - want %v %v", got.Requires, got.Imports, bytecode.Requires, bytecode.Imports)
+		}
+		if got.Linked() != bytecode.Linked() {
+			t.Errorf("invalid linked: got %t - want %t", got.Linked(), bytecode.Linked())
+		}
+	}
+
+	// an object built on its own can be linked with its modules read back
+	// from .jkb files
+	data, err := c.Modules()[0].MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	lib := writeFiles(t, map[string]string{"math.jkb": string(data)})
+	fromFiles, err := Link(c.Bytecode(), FileLoader(dir, lib))
+	if err != nil {
+		t.Fatalf("link error: %s", err)
+	}
+	if err := testInstructions([]code.Instructions{linked.Instructions}, fromFiles.Instructions); err != nil {
+		t.Errorf("test instructions failed: %s", err)
+	}
+	if _, err := Link(c.Bytecode(), FileLoader(dir)); err == nil || err.Error() != "module not found: math.jk" {
+		t.Errorf("invalid error: got %v - want module not found: math.jk", err)
+	}
+}
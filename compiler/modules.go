@@ -0,0 +1,261 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jimmykodes/joker/ast"
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/lexer"
+	"github.com/jimmykodes/joker/parser"
+	"github.com/jimmykodes/joker/token"
+)
+
+// moduleSet holds the modules of a program. It is shared by the compilers of
+// every file in the program, so that each module is compiled once, however
+// many files import it.
+type moduleSet struct {
+	// dir is the directory of the program's main file. Modules are named by
+	// their path relative to it.
+	dir string
+	// modules are in the order they were first imported. The main file, if
+	// it has a name, is the first, so that importing it is reported as a
+	// cycle.
+	modules []*module
+}
+
+// module is a file imported by the program
+type module struct {
+	name     string
+	filename string
+	// bytecode is the module compiled on its own, and is nil while it is
+	// still being compiled
+	bytecode *Bytecode
+}
+
+func newModuleSet(filename string) *moduleSet {
+	set := &moduleSet{dir: filepath.Dir(filename)}
+	if filename != "" {
+		if abs, err := filepath.Abs(filename); err == nil {
+			set.modules = append(set.modules, &module{name: filepath.Base(filename), filename: abs})
+		}
+	}
+	return set
+}
+
+func (set *moduleSet) find(filename string) int {
+	for i, mod := range set.modules {
+		if mod.filename == filename {
+			return i
+		}
+	}
+	return -1
+}
+
+// load returns the compiled module with the given name, for the linker
+func (set *moduleSet) load(name string) (*Bytecode, error) {
+	for _, mod := range set.modules {
+		if mod.name == name && mod.bytecode != nil {
+			return mod.bytecode, nil
+		}
+	}
+	return nil, fmt.Errorf("module not found: %s", name)
+}
+
+// compileImport compiles the imported module, if it hasn't been already, and
+// defines its name in the importing file
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	ident := strings.TrimSuffix(path.Base(node.Path.Value), path.Ext(node.Path.Value))
+	if !isIdent(ident) {
+		return fmt.Errorf("invalid module name %q: must be an identifier", ident)
+	}
+	filename, name, err := c.resolveImport(node.Path.Value)
+	if err != nil {
+		return err
+	}
+
+	idx := c.modules.find(filename)
+	if idx == -1 {
+		if idx, err = c.compileModule(name, filename); err != nil {
+			return err
+		}
+	} else if c.modules.modules[idx].bytecode == nil {
+		return c.importCycle(idx)
+	}
+	c.symbolTable.defineModule(ident, idx)
+	c.requires = appendUnique(c.requires, c.modules.modules[idx].name)
+	return nil
+}
+
+// compileModule parses and compiles the module in filename with a compiler of
+// its own, returning its index in the program's modules
+func (c *Compiler) compileModule(name, filename string) (int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	p := parser.New(lexer.New(string(data), lexer.WithFilename(filename)))
+	prog := p.ParseProgram()
+	if err := errors.Join(p.Errors()...); err != nil {
+		return 0, err
+	}
+
+	mod := &module{name: name, filename: filename}
+	idx := len(c.modules.modules)
+	c.modules.modules = append(c.modules.modules, mod)
+
+	mc := New(WithRegistry(c.registry), WithSearchPath(c.searchPath...), WithOptimize(c.optimize))
+	mc.filename, mc.name, mc.modules = filename, name, c.modules
+	if err := mc.Compile(prog); err != nil {
+		var modErr *moduleError
+		if errors.As(err, &modErr) {
+			// the error is in a module this one imports, which it already names
+			return 0, err
+		}
+		return 0, &moduleError{filename: filename, err: err}
+	}
+
+	mod.bytecode = mc.Bytecode()
+	return idx, nil
+}
+
+// moduleError is an error compiling an imported module
+type moduleError struct {
+	filename string
+	err      error
+}
+
+func (e *moduleError) Error() string { return e.filename + ": " + e.err.Error() }
+func (e *moduleError) Unwrap() error { return e.err }
+
+// compileModuleSelector loads the global name of the module imported as
+// ident. Every global a module defines is exported, but not the modules it
+// imports.
+func (c *Compiler) compileModuleSelector(ident string, mod *module, name string) error {
+	if _, ok := mod.bytecode.Modules[0].Exports[name]; !ok {
+		return fmt.Errorf("module %s has no global %s", ident, name)
+	}
+	for _, imp := range c.imports {
+		if imp.Module == mod.name && imp.Name == name {
+			c.emit(code.OpGetGlobal, imp.Global)
+			return nil
+		}
+	}
+	// the global is given a slot among this file's globals, which the linker
+	// replaces with the global itself
+	imp := Import{Module: mod.name, Name: name, Global: c.globalTable().reserve()}
+	c.imports = append(c.imports, imp)
+	c.emit(code.OpGetGlobal, imp.Global)
+	return nil
+}
+
+// globalTable returns the symbol table of the file's globals
+func (c *Compiler) globalTable() *SymbolTable {
+	st := c.symbolTable
+	for st.outer != nil {
+		st = st.outer
+	}
+	return st
+}
+
+// isIdent reports whether name is lexed as a single identifier
+func isIdent(name string) bool {
+	l := lexer.New(name)
+	tok, _, _, lit := l.NextToken()
+	if tok != token.Ident || lit != name {
+		return false
+	}
+	tok, _, _, _ = l.NextToken()
+	return tok == token.EOF
+}
+
+// resolveImport returns the absolute filename of the module imported as
+// importPath, and its name in the program. Relative paths are looked for next
+// to the importing file first, then in each directory of the search path. The
+// .jk extension may be left out of the path.
+func (c *Compiler) resolveImport(importPath string) (string, string, error) {
+	name := filepath.FromSlash(importPath)
+	if filepath.Ext(name) == "" {
+		name += ".jk"
+	}
+
+	if filepath.IsAbs(name) {
+		if isFile(name) {
+			return name, c.moduleName(name, ""), nil
+		}
+		return "", "", fmt.Errorf("module not found: %s", importPath)
+	}
+	if filename := filepath.Join(filepath.Dir(c.filename), name); isFile(filename) {
+		abs, err := filepath.Abs(filename)
+		return abs, c.moduleName(abs, ""), err
+	}
+	for _, dir := range c.searchPath {
+		if filename := filepath.Join(dir, name); isFile(filename) {
+			abs, err := filepath.Abs(filename)
+			return abs, c.moduleName(abs, dir), err
+		}
+	}
+	return "", "", fmt.Errorf("module not found: %s", importPath)
+}
+
+// moduleName returns the name of the module in filename. Modules are named by
+// their slash separated path relative to the directory of the program's main
+// file or, failing that, to dir, the directory of the search path they were
+// found in.
+func (c *Compiler) moduleName(filename, dir string) string {
+	if name, ok := relPath(c.modules.dir, filename); ok {
+		return name
+	}
+	if dir != "" {
+		if name, ok := relPath(dir, filename); ok {
+			return name
+		}
+	}
+	return filepath.ToSlash(filename)
+}
+
+// relPath returns the slash separated path of filename relative to dir, if
+// filename is inside dir
+func relPath(dir, filename string) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(abs, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func isFile(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && !info.IsDir()
+}
+
+// importCycle returns the error for importing the module at idx while it is
+// still being compiled. The modules still being compiled are the chain of
+// imports that led back to it.
+func (c *Compiler) importCycle(idx int) error {
+	var chain []string
+	for _, mod := range c.modules.modules[idx:] {
+		if mod.bytecode == nil {
+			chain = append(chain, mod.filename)
+		}
+	}
+	chain = append(chain, c.modules.modules[idx].filename)
+	return fmt.Errorf("import cycle: %s", strings.Join(chain, " -> "))
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,268 @@
+package compiler
+
+import (
+	"github.com/jimmykodes/joker/code"
+)
+
+// optimizeInstructions returns ins, with the line table lines, rewritten by
+// a peephole optimizer. It
+//
+//   - threads jumps to jumps, so they go straight to where the last one does
+//   - removes jumps to the next instruction
+//   - turns conditional jumps on true, false and null into jumps, or removes
+//     them
+//   - removes instructions that can't be reached
+//   - removes OpSetLocal x; OpGetLocal x when that is the only read of x, as
+//     the value is already on the stack
+//   - removes values that are pushed and popped straight away, in functions
+//
+// and fixes up the targets of every jump and try block. fn is set when ins
+// are the instructions of a function, rather than the top level, whose last
+// popped value is kept for the REPL.
+func optimizeInstructions(ins code.Instructions, lines code.LineTable, fn bool) (code.Instructions, code.LineTable) {
+	p := decodePeephole(ins, lines)
+	for p.pass(fn) {
+	}
+	return p.encode()
+}
+
+// peepholeInstruction is an instruction being optimized. Jumps refer to the
+// index of their target, rather than its offset, so that instructions can be
+// removed without breaking them.
+type peepholeInstruction struct {
+	op       code.Opcode
+	operands []int
+	line     int
+	// target is the index of the instruction a jump or OpTry goes to, or the
+	// number of instructions for their end
+	target int
+}
+
+type peephole struct {
+	ins []peepholeInstruction
+}
+
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpTry:
+		return true
+	}
+	return false
+}
+
+func decodePeephole(ins code.Instructions, lines code.LineTable) *peephole {
+	p := &peephole{}
+	// at maps offsets to the index of the instruction there
+	at := make(map[int]int)
+	for i := 0; i < len(ins); {
+		op := code.Opcode(ins[i])
+		widths, _ := code.OpWidths(ins[i])
+		operands, read := code.ReadOperands(widths, ins[i+1:])
+		at[i] = len(p.ins)
+		p.ins = append(p.ins, peepholeInstruction{op: op, operands: operands, line: lines.Line(i)})
+		i += 1 + read
+	}
+	at[len(ins)] = len(p.ins)
+	for i, in := range p.ins {
+		if isJump(in.op) {
+			p.ins[i].target = at[in.operands[0]]
+		}
+	}
+	return p
+}
+
+// encode returns the instructions and their line table, with the operands of
+// jumps set to the offsets of their targets
+func (p *peephole) encode() (code.Instructions, code.LineTable) {
+	offsets := make([]int, len(p.ins)+1)
+	for i, in := range p.ins {
+		offsets[i+1] = offsets[i] + len(code.Instruction(in.op, in.operands...))
+	}
+	var out code.Instructions
+	var lines code.LineTable
+	for i, in := range p.ins {
+		if isJump(in.op) {
+			in.operands[0] = offsets[in.target]
+		}
+		lines.Add(offsets[i], in.line)
+		out = append(out, code.Instruction(in.op, in.operands...)...)
+	}
+	return out, lines
+}
+
+// targets returns the number of jumps to each instruction
+func (p *peephole) targets() []int {
+	targets := make([]int, len(p.ins)+1)
+	for _, in := range p.ins {
+		if isJump(in.op) {
+			targets[in.target]++
+		}
+	}
+	return targets
+}
+
+// remove removes the instructions at the indexes set in removed. Jumps to
+// them go to the next instruction that is kept instead.
+func (p *peephole) remove(removed []bool) {
+	index := make([]int, len(p.ins)+1)
+	kept := p.ins[:0]
+	for i, in := range p.ins {
+		index[i] = len(kept)
+		if !removed[i] {
+			kept = append(kept, in)
+		}
+	}
+	index[len(p.ins)] = len(kept)
+	for i, in := range kept {
+		if isJump(in.op) {
+			kept[i].target = index[in.target]
+		}
+	}
+	p.ins = kept
+}
+
+// pass runs each optimization once, returning whether any changed the
+// instructions
+func (p *peephole) pass(fn bool) bool {
+	changed := p.threadJumps()
+	changed = p.simplify(fn) || changed
+	changed = p.removeUnreachable() || changed
+	return changed
+}
+
+// threadJumps points jumps to unconditional jumps at where those go
+func (p *peephole) threadJumps() bool {
+	changed := false
+	for i, in := range p.ins {
+		if !isJump(in.op) || in.op == code.OpTry {
+			continue
+		}
+		target := in.target
+		seen := map[int]bool{i: true}
+		for target < len(p.ins) && p.ins[target].op == code.OpJump && !seen[target] {
+			seen[target] = true
+			target = p.ins[target].target
+		}
+		if seen[target] {
+			// a loop of jumps that never ends, which is left as it is
+			continue
+		}
+		if target != in.target {
+			p.ins[i].target = target
+			changed = true
+		}
+	}
+	return changed
+}
+
+// simplify rewrites sequences of instructions with shorter ones that do the
+// same. Sequences are only rewritten if nothing jumps into the middle of
+// them.
+func (p *peephole) simplify(fn bool) bool {
+	targets := p.targets()
+	// reads counts the reads of each local. A captured local is read by the
+	// closures that share it, as well.
+	reads := make(map[int]int)
+	for _, in := range p.ins {
+		if in.op == code.OpGetLocal || in.op == code.OpCaptureLocal {
+			reads[in.operands[0]]++
+		}
+	}
+
+	removed := make([]bool, len(p.ins))
+	changed := false
+	for i := 0; i < len(p.ins); i++ {
+		in := p.ins[i]
+		if (in.op == code.OpJump || in.op == code.OpJumpNotTruthy || in.op == code.OpJumpTruthy) && in.target == i+1 {
+			if in.op == code.OpJump {
+				removed[i] = true
+			} else {
+				// the condition is still popped
+				p.ins[i] = peepholeInstruction{op: code.OpPop, line: in.line}
+			}
+			changed = true
+			continue
+		}
+		if i+1 >= len(p.ins) || targets[i+1] > 0 {
+			continue
+		}
+		next := p.ins[i+1]
+		switch {
+		case (in.op == code.OpTrue || in.op == code.OpFalse || in.op == code.OpNull) &&
+			(next.op == code.OpJumpNotTruthy || next.op == code.OpJumpTruthy):
+			truthy := in.op == code.OpTrue
+			if truthy == (next.op == code.OpJumpTruthy) {
+				// always jumps
+				p.ins[i] = peepholeInstruction{op: code.OpJump, operands: []int{0}, line: next.line, target: next.target}
+				removed[i+1] = true
+			} else {
+				// never jumps
+				removed[i], removed[i+1] = true, true
+			}
+		case in.op == code.OpSetLocal && next.op == code.OpGetLocal &&
+			in.operands[0] == next.operands[0] && reads[in.operands[0]] == 1:
+			removed[i], removed[i+1] = true, true
+		case fn && next.op == code.OpPop && pushesOnly(in.op):
+			removed[i], removed[i+1] = true, true
+		default:
+			continue
+		}
+		changed = true
+		i++
+	}
+	if changed {
+		p.remove(removed)
+	}
+	return changed
+}
+
+// pushesOnly reports whether op only pushes a value, with no other effect
+func pushesOnly(op code.Opcode) bool {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpGetBuiltin, code.OpGetNative,
+		code.OpCaptureLocal, code.OpCaptureFree:
+		return true
+	}
+	return false
+}
+
+// removeUnreachable removes instructions that no path from the first one
+// reaches
+func (p *peephole) removeUnreachable() bool {
+	reached := make([]bool, len(p.ins)+1)
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if reached[i] {
+			continue
+		}
+		reached[i] = true
+		if i == len(p.ins) {
+			continue
+		}
+		in := p.ins[i]
+		if isJump(in.op) {
+			work = append(work, in.target)
+		}
+		switch in.op {
+		case code.OpJump, code.OpReturn, code.OpTailCall, code.OpRaise:
+		default:
+			work = append(work, i+1)
+		}
+	}
+
+	removed := make([]bool, len(p.ins))
+	changed := false
+	for i := range p.ins {
+		if !reached[i] {
+			removed[i] = true
+			changed = true
+		}
+	}
+	if changed {
+		p.remove(removed)
+	}
+	return changed
+}
@@ -0,0 +1,329 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/object"
+)
+
+func TestOptimizeInstructions(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       bool
+		input    []code.Instructions
+		expected []code.Instructions
+	}{
+		{
+			name: "jump to the next instruction",
+			input: []code.Instructions{
+				code.Instruction(code.OpJump, 3),
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpJumpNotTruthy, 9),
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			name: "jump to a jump",
+			input: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpGetGlobal, 0),
+				// 0003
+				code.Instruction(code.OpJumpNotTruthy, 13),
+				// 0006
+				code.Instruction(code.OpNull),
+				// 0007
+				code.Instruction(code.OpPop),
+				// 0008
+				code.Instruction(code.OpJump, 0),
+				// 0011
+				code.Instruction(code.OpNull),
+				// 0012
+				code.Instruction(code.OpPop),
+				// 0013
+				code.Instruction(code.OpJump, 0),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpJumpNotTruthy, 0),
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpJump, 0),
+			},
+		},
+		{
+			name: "code after a return",
+			fn:   true,
+			input: []code.Instructions{
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpReturn),
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpReturn),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpReturn),
+			},
+		},
+		{
+			name: "set and get a local",
+			fn:   true,
+			input: []code.Instructions{
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpSetLocal, 1),
+				code.Instruction(code.OpGetLocal, 1),
+				code.Instruction(code.OpReturn),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpReturn),
+			},
+		},
+		{
+			name: "set and get a local that is read again",
+			fn:   true,
+			input: []code.Instructions{
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpSetLocal, 1),
+				code.Instruction(code.OpGetLocal, 1),
+				code.Instruction(code.OpGetLocal, 1),
+				code.Instruction(code.OpAdd),
+				code.Instruction(code.OpReturn),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpSetLocal, 1),
+				code.Instruction(code.OpGetLocal, 1),
+				code.Instruction(code.OpGetLocal, 1),
+				code.Instruction(code.OpAdd),
+				code.Instruction(code.OpReturn),
+			},
+		},
+		{
+			name: "while true",
+			input: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTrue),
+				// 0001
+				code.Instruction(code.OpJumpNotTruthy, 9),
+				// 0004
+				code.Instruction(code.OpGetGlobal, 0),
+				// 0007
+				code.Instruction(code.OpPop),
+				// 0008
+				code.Instruction(code.OpJump, 0),
+				// 0011
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpJump, 0),
+			},
+		},
+		{
+			name: "false or null condition",
+			input: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpFalse),
+				// 0001
+				code.Instruction(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Instruction(code.OpTrue),
+				// 0005
+				code.Instruction(code.OpJumpTruthy, 9),
+				// 0008
+				code.Instruction(code.OpNull),
+				// 0009
+				code.Instruction(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			name: "values popped in a function",
+			fn:   true,
+			input: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpPop),
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpReturn),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpReturn),
+			},
+		},
+		{
+			name: "values popped at the top level",
+			input: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpConstant, 0),
+				code.Instruction(code.OpPop),
+			},
+		},
+		{
+			name: "jump into a sequence",
+			input: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpGetGlobal, 0),
+				// 0003
+				code.Instruction(code.OpJumpNotTruthy, 10),
+				// 0006
+				code.Instruction(code.OpTrue),
+				// 0007
+				code.Instruction(code.OpJump, 11),
+				// 0010
+				code.Instruction(code.OpFalse),
+				// 0011
+				code.Instruction(code.OpJumpNotTruthy, 0),
+			},
+			expected: []code.Instructions{
+				code.Instruction(code.OpGetGlobal, 0),
+				code.Instruction(code.OpJumpNotTruthy, 10),
+				code.Instruction(code.OpTrue),
+				code.Instruction(code.OpJump, 11),
+				code.Instruction(code.OpFalse),
+				code.Instruction(code.OpJumpNotTruthy, 0),
+			},
+		},
+		{
+			name: "try block",
+			fn:   true,
+			input: []code.Instructions{
+				// 0000
+				code.Instruction(code.OpTry, 8),
+				// 0003
+				code.Instruction(code.OpEndTry),
+				// 0004
+				code.Instruction(code.OpJump, 7),
+				// 0007
+				code.Instruction(code.OpNull),
+				// 0008, the catch block, which is only reached from OpTry
+				code.Instruction(code.OpSetLocal, 0),
+				// 0010
+				code.Instruction(code.OpGetLocal, 0),
+				// 0012
+				code.Instruction(code.OpReturn),
+			},
+			// the error is left on the stack for OpReturn, instead of going
+			// through the local
+			expected: []code.Instructions{
+				code.Instruction(code.OpTry, 5),
+				code.Instruction(code.OpEndTry),
+				code.Instruction(code.OpNull),
+				code.Instruction(code.OpReturn),
+			},
+		},
+		{
+			name: "set and get a captured local",
+			fn:   true,
+			input: []code.Instructions{
+				code.Instruction(code.OpCaptureLocal, 0),
+				code.Instruction(code.OpClosure, 0, 1),
+				code.Instruction(code.OpSetLocal, 0),
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpReturn),
+			},
+			// the closure reads the local through its upvalue
+			expected: []code.Instructions{
+				code.Instruction(code.OpCaptureLocal, 0),
+				code.Instruction(code.OpClosure, 0, 1),
+				code.Instruction(code.OpSetLocal, 0),
+				code.Instruction(code.OpGetLocal, 0),
+				code.Instruction(code.OpReturn),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := concatInstructions(tt.input)
+			var lines code.LineTable
+			lines.Add(0, 1)
+			got, gotLines := optimizeInstructions(input, lines, tt.fn)
+			if err := testInstructions(tt.expected, got); err != nil {
+				t.Errorf("test instructions failed: %s", err)
+			}
+			if want := (code.LineTable{{Offset: 0, Line: 1}}); len(got) > 0 && !reflect.DeepEqual(gotLines, want) {
+				t.Errorf("invalid lines: got %v - want %v", gotLines, want)
+			}
+		})
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	input := `
+fn f(n) {
+  let y = n * 2;
+  while true {
+    if y > 10 { return y; }
+    y = y + 1;
+  }
+  return 0;
+}
+f(3);`
+	c := New(WithOptimize(true))
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+	if err := bytecode.Verify(); err != nil {
+		t.Fatalf("verify error: %s", err)
+	}
+	fn := bytecode.Constants[4].(*object.CompiledFunction)
+	expected := []code.Instructions{
+		// 0000
+		code.Instruction(code.OpGetLocal, 0),
+		// 0002
+		code.Instruction(code.OpConstant, 0),
+		// 0005
+		code.Instruction(code.OpMult),
+		// 0006
+		code.Instruction(code.OpSetLocal, 1),
+		// 0008, the loop starts at its body, as its condition is always true
+		code.Instruction(code.OpGetLocal, 1),
+		// 0010
+		code.Instruction(code.OpConstant, 1),
+		// 0013
+		code.Instruction(code.OpGT),
+		// 0014
+		code.Instruction(code.OpJumpNotTruthy, 20),
+		// 0017
+		code.Instruction(code.OpGetLocal, 1),
+		// 0019
+		code.Instruction(code.OpReturn),
+		// 0020
+		code.Instruction(code.OpGetLocal, 1),
+		// 0022
+		code.Instruction(code.OpConstant, 2),
+		// 0025
+		code.Instruction(code.OpAdd),
+		// 0026
+		code.Instruction(code.OpSetLocal, 1),
+		// 0028, return 0 after the loop can't be reached
+		code.Instruction(code.OpJump, 8),
+	}
+	if err := testInstructions(expected, fn.Instructions); err != nil {
+		t.Errorf("test instructions failed: %s", err)
+	}
+	wantLines := code.LineTable{{Offset: 0, Line: 3}, {Offset: 8, Line: 5}, {Offset: 20, Line: 6}, {Offset: 28, Line: 4}}
+	if !reflect.DeepEqual(fn.Lines, wantLines) {
+		t.Errorf("invalid lines: got %v - want %v", fn.Lines, wantLines)
+	}
+}
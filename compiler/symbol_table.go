@@ -1,11 +1,17 @@
 package compiler
 
+import "sort"
+
 type SymbolScope string
 
 const (
 	GlobalScope = "GLOBAL"
 	LocalScope  = "LOCAL"
 	FreeScope   = "FREE"
+	// ModuleScope symbols name an imported module. Their index is the
+	// module's position in the order the program's modules were first
+	// imported.
+	ModuleScope = "MODULE"
 )
 
 type Symbol struct {
@@ -45,6 +51,32 @@ func (s *SymbolTable) Define(name string) Symbol {
 	return sym
 }
 
+// reserve allocates a slot for a value without giving it a name. In a global
+// table, it is used for the globals of other modules.
+func (s *SymbolTable) reserve() int {
+	s.numDefinitions++
+	return s.numDefinitions - 1
+}
+
+func (s *SymbolTable) defineModule(name string, index int) Symbol {
+	sym := Symbol{Name: name, Scope: ModuleScope, Index: index}
+	s.store[name] = sym
+	return sym
+}
+
+// Symbols returns the variables defined in this table, ordered by index.
+// If a name was defined more than once, only its latest definition is included.
+func (s *SymbolTable) Symbols() []Symbol {
+	syms := make([]Symbol, 0, len(s.store))
+	for _, sym := range s.store {
+		if sym.Scope != FreeScope && sym.Scope != ModuleScope {
+			syms = append(syms, sym)
+		}
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Index < syms[j].Index })
+	return syms
+}
+
 func (s *SymbolTable) defineFree(orig Symbol) Symbol {
 	sym := Symbol{
 		Name:  orig.Name,
@@ -63,7 +95,7 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 		if !ok {
 			return sym, ok
 		}
-		if sym.Scope == GlobalScope {
+		if sym.Scope == GlobalScope || sym.Scope == ModuleScope {
 			return sym, ok
 		}
 		free := s.defineFree(sym)
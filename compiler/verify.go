@@ -0,0 +1,319 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jimmykodes/joker/builtins"
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/object"
+)
+
+// Verify checks that b can be run without the VM reading outside of its
+// instructions, constants or stack. It checks that
+//
+//   - every opcode is defined and has all of its operands
+//   - constants, globals, locals, free variables and builtins that operands
+//     refer to exist, and OpClosure is only given functions
+//   - jumps land on the start of an instruction
+//   - the stack has the same depth however an instruction is reached, never
+//     has fewer values than an instruction pops, and functions always return
+//
+// Errors wrap ErrInvalidBytecode.
+func (b *Bytecode) Verify() error {
+	v := verifier{
+		constants: b.Constants,
+		globals:   b.numGlobals(),
+		numFree:   make(map[int]int),
+	}
+
+	units := []*unit{{name: "<main>", ins: b.Instructions}}
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			units = append(units, &unit{name: functionName(i, fn), ins: fn.Instructions, fn: fn})
+		}
+	}
+	for _, u := range units {
+		if err := u.decode(); err != nil {
+			return invalidBytecode("%s: %s", u.name, err)
+		}
+	}
+	// functions can only use as many free variables as every closure made
+	// from them is given
+	for _, u := range units {
+		for _, in := range u.decoded {
+			if in.op != code.OpClosure {
+				continue
+			}
+			if err := v.checkConstant(in.operands[0]); err != nil {
+				return invalidBytecode("%s: %s", u.name, in.errorf("%s", err))
+			}
+			idx := in.operands[0]
+			if _, ok := b.Constants[idx].(*object.CompiledFunction); !ok {
+				return invalidBytecode("%s: %s", u.name, in.errorf("constant %d is %s, not a function", idx, b.Constants[idx].Type()))
+			}
+			if n, ok := v.numFree[idx]; !ok || in.operands[1] < n {
+				v.numFree[idx] = in.operands[1]
+			}
+		}
+	}
+	for _, u := range units {
+		if u.fn != nil {
+			u.numFree = v.numFree[constantIndex(b.Constants, u.fn)]
+			if u.fn.NumParams > u.fn.NumLocals {
+				return invalidBytecode("%s: has %d params but only %d locals", u.name, u.fn.NumParams, u.fn.NumLocals)
+			}
+		}
+		if err := v.verify(u); err != nil {
+			return invalidBytecode("%s: %s", u.name, err)
+		}
+	}
+	return nil
+}
+
+// numGlobals returns the number of globals b uses, according to its module
+// table
+func (b *Bytecode) numGlobals() int {
+	if len(b.Modules) == 0 {
+		// bytecode built without a module table can use every global the VM
+		// has room for
+		return math.MaxUint16
+	}
+	n := 0
+	for _, mod := range b.Modules {
+		if mod.Globals.End > n {
+			n = mod.Globals.End
+		}
+	}
+	return n
+}
+
+func functionName(idx int, fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return fmt.Sprintf("function (constant %d)", idx)
+	}
+	return fmt.Sprintf("function %s (constant %d)", fn.Name, idx)
+}
+
+func constantIndex(constants []object.Object, obj object.Object) int {
+	for i, constant := range constants {
+		if constant == obj {
+			return i
+		}
+	}
+	return -1
+}
+
+type verifier struct {
+	constants []object.Object
+	globals   int
+	// numFree maps functions, by their constant index, to the fewest free
+	// variables that a closure of them is given
+	numFree map[int]int
+}
+
+// unit is a sequence of instructions that runs in a frame of its own, either
+// the top level of the program or a function
+type unit struct {
+	name string
+	ins  code.Instructions
+	// fn is nil for the top level
+	fn      *object.CompiledFunction
+	numFree int
+
+	decoded []instruction
+	// at maps the offset of each instruction to its index in decoded
+	at map[int]int
+}
+
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	operands []int
+	// next is the offset of the instruction that follows
+	next int
+}
+
+func (in instruction) errorf(format string, args ...any) error {
+	return fmt.Errorf("%04d %s: %s", in.offset, in.op, fmt.Sprintf(format, args...))
+}
+
+// decode splits the unit's instructions, checking that every opcode is
+// defined and has all of its operands
+func (u *unit) decode() error {
+	u.at = make(map[int]int)
+	for i := 0; i < len(u.ins); {
+		widths, err := code.OpWidths(u.ins[i])
+		if err != nil {
+			return fmt.Errorf("%04d: %w", i, err)
+		}
+		op := code.Opcode(u.ins[i])
+		if i+1+sum(widths) > len(u.ins) {
+			return fmt.Errorf("%04d %s: missing operands", i, op)
+		}
+		operands, read := code.ReadOperands(widths, u.ins[i+1:])
+		u.at[i] = len(u.decoded)
+		u.decoded = append(u.decoded, instruction{offset: i, op: op, operands: operands, next: i + 1 + read})
+		i += 1 + read
+	}
+	return nil
+}
+
+func (v *verifier) checkConstant(idx int) error {
+	if idx >= len(v.constants) {
+		return fmt.Errorf("constant %d out of range, there are %d", idx, len(v.constants))
+	}
+	return nil
+}
+
+// checkOperands checks that the operands of in refer to things that exist
+func (v *verifier) checkOperands(u *unit, in instruction) error {
+	switch in.op {
+	case code.OpConstant:
+		if err := v.checkConstant(in.operands[0]); err != nil {
+			return in.errorf("%s", err)
+		}
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if in.operands[0] >= v.globals {
+			return in.errorf("global %d out of range, there are %d", in.operands[0], v.globals)
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal, code.OpCloseUpvalues:
+		if u.fn == nil {
+			return in.errorf("locals can only be used in functions")
+		}
+		if in.operands[0] >= u.fn.NumLocals {
+			return in.errorf("local %d out of range, there are %d", in.operands[0], u.fn.NumLocals)
+		}
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		if in.operands[0] >= u.numFree {
+			return in.errorf("free variable %d out of range, there are %d", in.operands[0], u.numFree)
+		}
+	case code.OpGetBuiltin:
+		if _, ok := builtins.Func(in.operands[0]); !ok {
+			return in.errorf("builtin %d undefined", in.operands[0])
+		}
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpTry:
+		if _, ok := u.at[in.operands[0]]; !ok && in.operands[0] != len(u.ins) {
+			return in.errorf("target %d is not the start of an instruction", in.operands[0])
+		}
+	case code.OpReturn, code.OpTailCall:
+		if u.fn == nil {
+			return in.errorf("cannot return from the top level")
+		}
+	}
+	return nil
+}
+
+// stackEffect returns the number of values in pops from the stack, and the
+// number it pushes
+func stackEffect(in instruction) (int, int) {
+	switch in.op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpGetBuiltin, code.OpGetNative,
+		code.OpCaptureLocal, code.OpCaptureFree:
+		return 0, 1
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpSetFree,
+		code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpReturn, code.OpRaise:
+		return 1, 0
+	case code.OpAdd, code.OpSub, code.OpMult, code.OpDiv, code.OpMod,
+		code.OpEQ, code.OpNEQ, code.OpGT, code.OpGTE, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang:
+		return 1, 1
+	case code.OpSetIndex:
+		return 3, 0
+	case code.OpArray:
+		return in.operands[0], 1
+	case code.OpMap:
+		return 2 * in.operands[0], 1
+	case code.OpCall:
+		return in.operands[0] + 1, 1
+	case code.OpTailCall:
+		// the value the function called returns is returned from this frame
+		return in.operands[0] + 1, 0
+	case code.OpClosure:
+		return in.operands[1], 1
+	default:
+		// OpJump, OpTry, OpEndTry and OpCloseUpvalues
+		return 0, 0
+	}
+}
+
+// verify checks the operands of each of the unit's instructions, then follows
+// every path through them to check the depth of the stack
+func (v *verifier) verify(u *unit) error {
+	for _, in := range u.decoded {
+		if err := v.checkOperands(u, in); err != nil {
+			return err
+		}
+	}
+
+	// depths holds the depth of the stack before each instruction, or -1 if
+	// no path reaching it has been followed yet
+	depths := make([]int, len(u.decoded))
+	for i := range depths {
+		depths[i] = -1
+	}
+	var work []int
+	reach := func(from instruction, offset, depth int) error {
+		idx, ok := u.at[offset]
+		if !ok {
+			// the end of the instructions, which stops the program
+			if u.fn != nil {
+				return from.errorf("function can reach the end of its instructions without returning")
+			}
+			return nil
+		}
+		switch depths[idx] {
+		case -1:
+			depths[idx] = depth
+			work = append(work, idx)
+		case depth:
+		default:
+			return from.errorf("stack depth at %04d is %d, but %d on another path", offset, depth, depths[idx])
+		}
+		return nil
+	}
+
+	if len(u.decoded) == 0 {
+		if u.fn != nil {
+			return fmt.Errorf("function has no instructions")
+		}
+		return nil
+	}
+	depths[0] = 0
+	work = append(work, 0)
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		in, depth := u.decoded[i], depths[i]
+
+		pops, pushes := stackEffect(in)
+		if depth < pops {
+			return in.errorf("pops %d values from a stack of %d", pops, depth)
+		}
+		after := depth - pops + pushes
+
+		var err error
+		switch in.op {
+		case code.OpReturn, code.OpTailCall, code.OpRaise:
+		case code.OpJump:
+			err = reach(in, in.operands[0], after)
+		case code.OpJumpNotTruthy, code.OpJumpTruthy:
+			if err = reach(in, in.operands[0], after); err == nil {
+				err = reach(in, in.next, after)
+			}
+		case code.OpTry:
+			// the error raised in the try block is pushed for the catch block
+			if err = reach(in, in.operands[0], after+1); err == nil {
+				err = reach(in, in.next, after)
+			}
+		default:
+			err = reach(in, in.next, after)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
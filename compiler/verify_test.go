@@ -0,0 +1,188 @@
+package compiler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jimmykodes/joker/code"
+	"github.com/jimmykodes/joker/object"
+)
+
+func TestBytecode_Verify(t *testing.T) {
+	inputs := []string{
+		`let x = 1; if x > 0 { x = x + 1; } else { x = 0; }`,
+		`let f = fn(a) { let b = a; fn() { b }; }; f(1)();`,
+		`fn f() { let n = 0; let g = fn() { n = n + 1; return fn() { n }; }; return g; }`,
+		`let a = [1, 2]; let m = {"a": 1}; a[0] = m["a"];`,
+		`for let i = 0; i < 10; i = i + 1; { if i == 5 { break; } if i == 2 { continue; } }`,
+		`try { raise "boom"; } catch err { print(err); }`,
+		`fn f(n) { try { return n / 2; } catch { return 0; } } f(4);`,
+		`let x = true && false || true; let y = if x { 1 };`,
+	}
+	for _, input := range inputs {
+		c := New()
+		if err := c.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := c.Bytecode().Verify(); err != nil {
+			t.Errorf("verify error for %s: %s", input, err)
+		}
+	}
+
+	fn := func(numLocals, numParams int, ins ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concatInstructions(ins), NumLocals: numLocals, NumParams: numParams, Name: "f"}
+	}
+	tests := []struct {
+		name      string
+		ins       []code.Instructions
+		constants []object.Object
+		err       string
+	}{
+		{
+			name: "undefined opcode",
+			ins:  []code.Instructions{{0xff}},
+			err:  "invalid bytecode: <main>: 0000: opcode 255 undefined",
+		},
+		{
+			name: "missing operands",
+			ins:  []code.Instructions{code.Instruction(code.OpTrue), {byte(code.OpConstant), 0}},
+			err:  "invalid bytecode: <main>: 0001 OpConstant: missing operands",
+		},
+		{
+			name:      "constant out of range",
+			ins:       []code.Instructions{code.Instruction(code.OpConstant, 1), code.Instruction(code.OpPop)},
+			constants: []object.Object{&object.Integer{Value: 1}},
+			err:       "invalid bytecode: <main>: 0000 OpConstant: constant 1 out of range, there are 1",
+		},
+		{
+			name:      "closure of a non function",
+			ins:       []code.Instructions{code.Instruction(code.OpClosure, 0, 0), code.Instruction(code.OpPop)},
+			constants: []object.Object{&object.Integer{Value: 1}},
+			err:       "invalid bytecode: <main>: 0000 OpClosure: constant 0 is IntegerType, not a function",
+		},
+		{
+			name: "global out of range",
+			ins:  []code.Instructions{code.Instruction(code.OpGetGlobal, 1), code.Instruction(code.OpPop)},
+			err:  "invalid bytecode: <main>: 0000 OpGetGlobal: global 1 out of range, there are 1",
+		},
+		{
+			name: "local at the top level",
+			ins:  []code.Instructions{code.Instruction(code.OpGetLocal, 0), code.Instruction(code.OpPop)},
+			err:  "invalid bytecode: <main>: 0000 OpGetLocal: locals can only be used in functions",
+		},
+		{
+			name: "local out of range",
+			ins:  []code.Instructions{code.Instruction(code.OpClosure, 0, 0), code.Instruction(code.OpPop)},
+			constants: []object.Object{
+				fn(1, 1, code.Instruction(code.OpGetLocal, 1), code.Instruction(code.OpReturn)),
+			},
+			err: "invalid bytecode: function f (constant 0): 0000 OpGetLocal: local 1 out of range, there are 1",
+		},
+		{
+			name: "free variable out of range",
+			ins: []code.Instructions{
+				code.Instruction(code.OpTrue),
+				code.Instruction(code.OpClosure, 0, 1),
+				code.Instruction(code.OpPop),
+			},
+			constants: []object.Object{
+				fn(0, 0, code.Instruction(code.OpGetFree, 1), code.Instruction(code.OpReturn)),
+			},
+			err: "invalid bytecode: function f (constant 0): 0000 OpGetFree: free variable 1 out of range, there are 1",
+		},
+		{
+			name: "capture a local at the top level",
+			ins: []code.Instructions{
+				code.Instruction(code.OpCaptureLocal, 0),
+				code.Instruction(code.OpClosure, 0, 1),
+				code.Instruction(code.OpPop),
+			},
+			constants: []object.Object{
+				fn(0, 0, code.Instruction(code.OpGetFree, 0), code.Instruction(code.OpReturn)),
+			},
+			err: "invalid bytecode: <main>: 0000 OpCaptureLocal: locals can only be used in functions",
+		},
+		{
+			name: "close upvalues at the top level",
+			ins:  []code.Instructions{code.Instruction(code.OpCloseUpvalues, 0)},
+			err:  "invalid bytecode: <main>: 0000 OpCloseUpvalues: locals can only be used in functions",
+		},
+		{
+			name: "capture a free variable out of range",
+			ins:  []code.Instructions{code.Instruction(code.OpClosure, 0, 0), code.Instruction(code.OpPop)},
+			constants: []object.Object{
+				fn(0, 0, code.Instruction(code.OpCaptureFree, 0), code.Instruction(code.OpReturn)),
+			},
+			err: "invalid bytecode: function f (constant 0): 0000 OpCaptureFree: free variable 0 out of range, there are 0",
+		},
+		{
+			name: "undefined builtin",
+			ins:  []code.Instructions{code.Instruction(code.OpGetBuiltin, 200), code.Instruction(code.OpPop)},
+			err:  "invalid bytecode: <main>: 0000 OpGetBuiltin: builtin 200 undefined",
+		},
+		{
+			name:      "jump into an instruction",
+			ins:       []code.Instructions{code.Instruction(code.OpJump, 4), code.Instruction(code.OpConstant, 0)},
+			constants: []object.Object{&object.Integer{Value: 1}},
+			err:       "invalid bytecode: <main>: 0000 OpJump: target 4 is not the start of an instruction",
+		},
+		{
+			name: "return from the top level",
+			ins:  []code.Instructions{code.Instruction(code.OpTrue), code.Instruction(code.OpReturn)},
+			err:  "invalid bytecode: <main>: 0001 OpReturn: cannot return from the top level",
+		},
+		{
+			name: "tail call from the top level",
+			ins:  []code.Instructions{code.Instruction(code.OpTrue), code.Instruction(code.OpTailCall, 0)},
+			err:  "invalid bytecode: <main>: 0001 OpTailCall: cannot return from the top level",
+		},
+		{
+			name: "stack underflow",
+			ins:  []code.Instructions{code.Instruction(code.OpTrue), code.Instruction(code.OpAdd)},
+			err:  "invalid bytecode: <main>: 0001 OpAdd: pops 2 values from a stack of 1",
+		},
+		{
+			name: "unbalanced branches",
+			ins: []code.Instructions{
+				code.Instruction(code.OpTrue),
+				code.Instruction(code.OpJumpNotTruthy, 6),
+				code.Instruction(code.OpTrue),
+				code.Instruction(code.OpTrue),
+				code.Instruction(code.OpPop),
+			},
+			err: "invalid bytecode: <main>: 0005 OpTrue: stack depth at 0006 is 2, but 0 on another path",
+		},
+		{
+			name: "function without a return",
+			ins:  []code.Instructions{code.Instruction(code.OpClosure, 0, 0), code.Instruction(code.OpPop)},
+			constants: []object.Object{
+				fn(0, 0, code.Instruction(code.OpTrue), code.Instruction(code.OpPop)),
+			},
+			err: "invalid bytecode: function f (constant 0): 0001 OpPop: function can reach the end of its instructions without returning",
+		},
+		{
+			name: "more params than locals",
+			ins:  []code.Instructions{code.Instruction(code.OpClosure, 0, 0), code.Instruction(code.OpPop)},
+			constants: []object.Object{
+				fn(0, 1, code.Instruction(code.OpNull), code.Instruction(code.OpReturn)),
+			},
+			err: "invalid bytecode: function f (constant 0): has 1 params but only 0 locals",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bytecode{
+				Instructions: concatInstructions(tt.ins),
+				Constants:    tt.constants,
+				Modules:      []Module{{Globals: Range{0, 1}}},
+			}
+			err := b.Verify()
+			if !errors.Is(err, ErrInvalidBytecode) {
+				t.Fatalf("invalid error: got %v - want %s", err, ErrInvalidBytecode)
+			}
+			if err.Error() != tt.err {
+				t.Errorf("invalid error message:\ngot  %s\nwant %s", err, tt.err)
+			}
+		})
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/jimmykodes/joker/object"
 )
 
-var Null = &object.Null{}
+var Null = object.NullValue
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch n := node.(type) {
@@ -42,6 +42,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return r
 		}
 		env.Assign(n.Name.Value, r)
+	case *ast.IndexAssignStatement:
+		return evalIndexAssign(n, env)
 	case *ast.FuncStatement:
 		if obj, ok := env.GetLocal(n.Name.Value); ok && obj.Type() != object.FunctionType {
 			return newError("declaring function with already initialized name: %s", n.Name.Value)
@@ -54,11 +56,33 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.BlockStatement:
 		return evalBlockStatements(n, env)
 	case *ast.ReturnStatement:
+		if call, ok := n.Value.(*ast.CallExpression); ok {
+			return evalTailCall(call, env)
+		}
 		r := Eval(n.Value, env)
 		if isError(r) {
 			return r
 		}
 		return &object.Return{Value: r}
+	case *ast.TryStatement:
+		res := evalTry(n, env)
+		declare(n, env)
+		return res
+	case *ast.RaiseStatement:
+		r := Eval(n.Value, env)
+		if isError(r) {
+			return r
+		}
+		switch r := r.(type) {
+		case *object.Error:
+			return &object.Exception{Err: r}
+		case *object.String:
+			return &object.Exception{Err: &object.Error{Message: r.Value}}
+		default:
+			return newError("cannot raise %s, must be %s or %s", r.Type(), object.ErrorType, object.StringType)
+		}
+	case *ast.ImportStatement:
+		return newError("imports are not supported by the interpreter: %s", n.Path.Value)
 	case *ast.ContinueStatement:
 		return &object.Continue{}
 	case *ast.BreakStatement:
@@ -70,8 +94,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(r) {
 			return r
 		}
-		return evalPrefix(n.Operator, r)
+		return raise(evalPrefix(n.Operator, r))
 	case *ast.InfixExpression:
+		if n.Operator == "&&" || n.Operator == "||" {
+			return evalLogical(n, env)
+		}
 		l := Eval(n.Left, env)
 		if isError(l) {
 			return l
@@ -80,11 +107,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(r) {
 			return r
 		}
-		return evalInfix(n.Operator, l, r)
+		return raise(evalInfix(n.Operator, l, r))
 	case *ast.IfExpression:
-		return evalIf(n, env)
+		res := evalIf(n, env)
+		declare(n, env)
+		return res
 	case *ast.WhileExpression:
-		return evalWhile(n, env)
+		res := evalWhile(n, env)
+		declare(n, env)
+		return res
 	case *ast.ForExpression:
 		return evalFor(n, env)
 	case *ast.CallExpression:
@@ -99,6 +130,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return applyFunc(f, args, env)
 	case *ast.IndexExpression:
 		return evalIndex(n, env)
+	case *ast.SelectorExpression:
+		// modules are only provided by a native function registry, which the
+		// interpreter doesn't support
+		return newError("module not found: %s", n.Left)
 	case *ast.Identifier:
 		return evalIdent(n, env)
 	case *ast.FunctionLiteral:
@@ -138,36 +173,88 @@ func toBoolObject(b bool) object.Object {
 	return object.False
 }
 
-func newError(format string, a ...any) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+// newError returns a new error, raised
+func newError(format string, a ...any) *object.Exception {
+	return &object.Exception{Err: &object.Error{Message: fmt.Sprintf(format, a...)}}
+}
+
+// raise raises obj if it is an error, the way operations on objects report
+// failure
+func raise(obj object.Object) object.Object {
+	if errOb, ok := obj.(*object.Error); ok {
+		return &object.Exception{Err: errOb}
+	}
+	return obj
 }
 
+// isError reports whether o is an error being raised
 func isError(o object.Object) bool {
-	return o != nil && o.Type() == object.ErrorType
+	return o != nil && o.Type() == object.ExceptionType
+}
+
+// tailCall is what a function returns when it returns the result of a call.
+// The call is made by applyFunc once the function has returned, rather than
+// from within it, so recursion in tail position runs in a loop instead of
+// growing the Go stack. It is a kind of return, and is passed up through
+// blocks and loops the same way.
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+}
+
+func (t *tailCall) Type() object.Type { return object.ReturnType }
+func (t *tailCall) Inspect() string   { return "tail call to " + t.fn.Inspect() }
+
+// call makes the call and returns its result
+func (t *tailCall) call(env *object.Environment) object.Object {
+	return applyFunc(t.fn, t.args, env)
+}
+
+func evalTailCall(n *ast.CallExpression, env *object.Environment) object.Object {
+	f := Eval(n.Function, env)
+	if isError(f) {
+		return f
+	}
+	args, err := evalExpressions(n.Arguments, env)
+	if isError(err) {
+		return err
+	}
+	return &tailCall{fn: f, args: args}
 }
 
 func applyFunc(fn object.Object, args []object.Object, env *object.Environment) object.Object {
-	switch f := fn.(type) {
-	case *object.Builtin:
-		if res := f.Fn(args...); res != nil {
-			return res
-		}
-		return Null
-	case *object.Function:
-		if len(args) != len(f.Parameters) {
-			return newError("invalid number of args. got %d - want %d", len(args), len(f.Parameters))
-		}
-		wrappedEnv := object.NewEnvironment(object.EncloseOuterOption(f.Env))
-		for i, parameter := range f.Parameters {
-			wrappedEnv.Define(parameter.Value, args[i])
-		}
-		ret := Eval(f.Body, wrappedEnv)
-		if r, ok := ret.(*object.Return); ok {
-			return r.Value
+	for {
+		switch f := fn.(type) {
+		case *object.Builtin:
+			res := f.Fn(env.Runtime(), args...)
+			if errOb, ok := builtins.Raised(f, res); ok {
+				return &object.Exception{Err: errOb}
+			}
+			if res != nil {
+				return res
+			}
+			return Null
+		case *object.Function:
+			if len(args) != len(f.Parameters) {
+				return newError("invalid number of args. got %d - want %d", len(args), len(f.Parameters))
+			}
+			wrappedEnv := object.NewEnvironment(object.EncloseOuterOption(f.Env))
+			for i, parameter := range f.Parameters {
+				wrappedEnv.Define(parameter.Value, args[i])
+			}
+			switch ret := Eval(f.Body, wrappedEnv).(type) {
+			case *tailCall:
+				// the function returns whatever the call does, so the call
+				// takes its place
+				fn, args = ret.fn, ret.args
+			case *object.Return:
+				return ret.Value
+			default:
+				return ret
+			}
+		default:
+			return newError("cannot call a non-function: %s", fn.Type())
 		}
-		return ret
-	default:
-		return newError("cannot call a non-function: %s", fn.Type())
 	}
 }
 
@@ -182,11 +269,105 @@ func evalIndex(index *ast.IndexExpression, env *object.Environment) object.Objec
 	}
 	l, ok := left.(object.Indexer)
 	if !ok {
-		return object.ErrUnsupportedType
+		return raise(object.ErrUnsupportedType)
 	}
 	return l.Idx(i)
 }
 
+func evalIndexAssign(n *ast.IndexAssignStatement, env *object.Environment) object.Object {
+	left := Eval(n.Target.Left, env)
+	if isError(left) {
+		return left
+	}
+	i := Eval(n.Target.Index, env)
+	if isError(i) {
+		return i
+	}
+	v := Eval(n.Value, env)
+	if isError(v) {
+		return v
+	}
+	s, ok := left.(object.Settable)
+	if !ok {
+		return newError("%s does not support index assignment", left.Type())
+	}
+	if err := env.Runtime().Charge(object.SetSize(left, i)); err != nil {
+		return raise(object.ErrorFromGo(err))
+	}
+	if res := raise(s.Set(i, v)); isError(res) {
+		return res
+	}
+	return Null
+}
+
+// declare declares the variables defined in node, once it has run, that it
+// didn't give a value because the statement defining them didn't run. The
+// variables of a function belong to all of it, so they can still be read, as
+// null, like in the VM.
+func declare(node ast.Node, env *object.Environment) {
+	switch n := node.(type) {
+	case *ast.BlockStatement:
+		if n == nil {
+			return
+		}
+		for _, stmt := range n.Statements {
+			declare(stmt, env)
+		}
+	case *ast.LetStatement:
+		env.Declare(n.Name.Value)
+	case *ast.DefineStatement:
+		env.Declare(n.Name.Value)
+	case *ast.FuncStatement:
+		env.Declare(n.Name.Value)
+	case *ast.ExpressionStatement:
+		declare(n.Expression, env)
+	case *ast.IfExpression:
+		declare(n.Consequence, env)
+		declare(n.Alternative, env)
+	case *ast.WhileExpression:
+		declare(n.Body, env)
+	case *ast.TryStatement:
+		declare(n.Body, env)
+		if n.Name != nil {
+			env.Declare(n.Name.Value)
+		}
+		declare(n.Catch, env)
+	}
+}
+
+// evalTry evaluates a try statement. Like other statements, it has no value
+// of its own, but passes on return, break and continue from its blocks.
+func evalTry(n *ast.TryStatement, env *object.Environment) object.Object {
+	res := Eval(n.Body, env)
+	if tc, ok := res.(*tailCall); ok {
+		// a call returned from a try block is made inside it, so that what it
+		// raises can be caught
+		res = tc.call(env)
+		if !isError(res) {
+			return &object.Return{Value: res}
+		}
+	}
+	exc, ok := res.(*object.Exception)
+	if !ok {
+		return control(res)
+	}
+	if n.Name != nil {
+		env.Define(n.Name.Value, exc.Err)
+	}
+	return control(Eval(n.Catch, env))
+}
+
+// control returns res if it is a raised error or changes the flow of
+// control, and null otherwise
+func control(res object.Object) object.Object {
+	switch res.(type) {
+	case *object.Exception, *object.Return, *tailCall, *object.Break, *object.Continue:
+		return res
+	default:
+		return Null
+	}
+}
+
 func evalMap(m *ast.MapLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 	for k, v := range m.Pairs {
@@ -236,7 +417,9 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		switch r := res.(type) {
 		case *object.Return:
 			return r.Value
-		case *object.Error:
+		case *tailCall:
+			return r.call(env)
+		case *object.Exception:
 			return r
 		}
 	}
@@ -345,6 +528,28 @@ func evalInfix(operator string, left, right object.Object) object.Object {
 	}
 }
 
+func evalLogical(n *ast.InfixExpression, env *object.Environment) object.Object {
+	// && is decided by the first false operand, || by the first true one
+	decider := object.False
+	if n.Operator == "||" {
+		decider = object.True
+	}
+	for _, operand := range []ast.Expression{n.Left, n.Right} {
+		o := Eval(operand, env)
+		if isError(o) {
+			return o
+		}
+		b := raise(object.Truthy(o))
+		if isError(b) {
+			return b
+		}
+		if b == decider {
+			return decider
+		}
+	}
+	return decider.Invert()
+}
+
 func evalBang(obj object.Object) object.Object {
 	b, ok := obj.(object.Booler)
 	if !ok {
@@ -358,11 +563,11 @@ func evalIf(n *ast.IfExpression, env *object.Environment) object.Object {
 	if isError(condition) {
 		return condition
 	}
-	b, ok := condition.(object.Booler)
-	if !ok {
-		return newError("cannot implicitly convert %s to bool", condition.Type())
+	b := raise(object.Truthy(condition))
+	if isError(b) {
+		return b
 	}
-	if b.Bool() == object.True {
+	if b == object.True {
 		return Eval(n.Consequence, env)
 	}
 	if n.Alternative != nil {
@@ -374,7 +579,21 @@ func evalIf(n *ast.IfExpression, env *object.Environment) object.Object {
 func evalFor(n *ast.ForExpression, env *object.Environment) object.Object {
 	var res object.Object = Null
 	forEnv := object.NewEnvironment(object.EncloseOuterOption(env))
-	for Eval(n.Init, forEnv); Eval(n.Condition, forEnv) == object.True; Eval(n.Increment, forEnv) {
+	if init := Eval(n.Init, forEnv); isError(init) {
+		return init
+	}
+	for {
+		condition := Eval(n.Condition, forEnv)
+		if isError(condition) {
+			return condition
+		}
+		b := raise(object.Truthy(condition))
+		if isError(b) {
+			return b
+		}
+		if b == object.False {
+			return res
+		}
 		loopRes := Eval(n.Body, forEnv)
 		if isError(loopRes) {
 			return loopRes
@@ -388,8 +607,10 @@ func evalFor(n *ast.ForExpression, env *object.Environment) object.Object {
 		if loopRes.Type() != object.ContinueType {
 			res = loopRes
 		}
+		if inc := Eval(n.Increment, forEnv); isError(inc) {
+			return inc
+		}
 	}
-	return res
 }
 
 func evalWhile(n *ast.WhileExpression, env *object.Environment) object.Object {
@@ -399,11 +620,11 @@ func evalWhile(n *ast.WhileExpression, env *object.Environment) object.Object {
 		if isError(condition) {
 			return condition
 		}
-		b, ok := condition.(object.Booler)
-		if !ok {
-			return newError("cannot implicitly convert %s to bool", condition.Type())
+		b := raise(object.Truthy(condition))
+		if isError(b) {
+			return b
 		}
-		if b.Bool() == object.False {
+		if b == object.False {
 			return res
 		}
 		loopRes := Eval(n.Body, env)
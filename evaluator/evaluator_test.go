@@ -0,0 +1,187 @@
+package evaluator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jimmykodes/joker/compiler"
+	"github.com/jimmykodes/joker/lexer"
+	"github.com/jimmykodes/joker/object"
+	"github.com/jimmykodes/joker/parser"
+	"github.com/jimmykodes/joker/vm"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "map index assignment",
+			input: "let m = {}; let i = 0; while i < 1000000 { m[i] = i; i = i + 1; }",
+			err:   object.ErrMemoryLimit.Error(),
+		},
+		{
+			name:  "map set",
+			input: "let m = {}; let i = 0; while i < 1000000 { set(m, i, i); i = i + 1; }",
+			err:   object.ErrMemoryLimit.Error(),
+		},
+		{
+			name:  "map reassignment",
+			input: "let m = {}; let i = 0; while i < 100000 { m[0] = i; i = i + 1; }",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &object.Runtime{Meter: object.NewMeter(1 << 20)}
+			res := eval(tt.input, object.NewEnvironment(object.WithRuntime(rt)))
+			testRaised(t, tt.err, res)
+		})
+	}
+}
+
+func TestRaisedErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"let z = 0; 1 / z;", "division by zero"},
+		{"1 % 0;", "division by zero"},
+		{"1.0 / 0;", ""},
+		{"[1, 2, 3][-1];", "index out of range [-1] with length 3"},
+		{"let x = [1, 2, 3]; x[-1] = 5;", "index out of range [-1] with length 3"},
+		{`"abc"[-1];`, "index out of range [-1] with length 3"},
+		{"[1, 2, 3][3];", "index out of range [3] with length 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			testRaised(t, tt.err, eval(tt.input, object.NewEnvironment()))
+		})
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`let r = ""; try { 1 / 0; } catch e { r = e["message"]; } r`, "division by zero"},
+		{`let r = ""; try { 1 % 0; } catch e { r = e["message"]; } r`, "division by zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			res := eval(tt.input, object.NewEnvironment())
+			str, ok := res.(*object.String)
+			if !ok {
+				t.Fatalf("expected string, got %T (%+v)", res, res)
+			}
+			if str.Value != tt.want {
+				t.Errorf("invalid result: got %q - want %q", str.Value, tt.want)
+			}
+		})
+	}
+}
+
+// TestTruthiness runs each input through the evaluator and the VM, which
+// must agree on which values are true
+func TestTruthiness(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{input: "0 && true", want: "false"},
+		{input: "1 && true", want: "true"},
+		{input: "0.0 || false", want: "false"},
+		{input: `"" || true`, want: "true"},
+		{input: `"a" && 2`, want: "true"},
+		{input: "let n = 0; n || n", want: "false"},
+		{input: "let r = 0; if 0 { r = 1; } else { r = 2; } r", want: "2"},
+		{input: "let r = 0; while r { r = r - 1; } r", want: "0"},
+		{input: "[1] && true", err: "cannot implicitly convert ArrayType to bool"},
+		{input: "let r = 0; if {} { r = 1; } r", err: "cannot implicitly convert MapType to bool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			testEngines(t, tt.input, tt.want, tt.err)
+		})
+	}
+}
+
+// TestUnsetVariables checks that variables whose definition didn't run read
+// as null in the evaluator, like in the VM
+func TestUnsetVariables(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{input: "if false { y := 1; } y", want: "null"},
+		{input: "if false { let y = 1; } else { z := 2; } let r = [y, z]; r", want: "[null, 2]"},
+		{input: "if false { y := 1; } y := 2; y", want: "2"},
+		{input: "fn f() { if false { y := 1; } return y; } f()", want: "null"},
+		{input: "let i = 0; while i < 0 { y := 1; } y", want: "null"},
+		{input: `try { raise "x"; y := 1; } catch e { } y`, want: "null"},
+		{input: "if false { y := 1; } y()", err: "NullType"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			testEngines(t, tt.input, tt.want, tt.err)
+		})
+	}
+}
+
+// testEngines runs input through the evaluator and the VM, and checks that
+// both give want, or both raise an error containing err
+func testEngines(t *testing.T, input, want, err string) {
+	t.Helper()
+	res := eval(input, object.NewEnvironment())
+	testRaised(t, err, res)
+	if err == "" && res.Inspect() != want {
+		t.Errorf("invalid evaluator result: got %s - want %s", res.Inspect(), want)
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := vm.New(comp.Bytecode())
+	runErr := machine.Run()
+	if err != "" {
+		if runErr == nil || !strings.Contains(runErr.Error(), err) {
+			t.Errorf("invalid vm error: got %v - want %q", runErr, err)
+		}
+		return
+	}
+	if runErr != nil {
+		t.Fatalf("vm error: %s", runErr)
+	}
+	if got := machine.LastPoppedStackElem().Inspect(); got != want {
+		t.Errorf("invalid vm result: got %s - want %s", got, want)
+	}
+}
+
+func eval(input string, env *object.Environment) object.Object {
+	p := parser.New(lexer.New(input))
+	return Eval(p.ParseProgram(), env)
+}
+
+// testRaised checks that res raised an error containing want, or that it
+// raised nothing if want is empty
+func testRaised(t *testing.T, want string, res object.Object) {
+	t.Helper()
+	exc, ok := res.(*object.Exception)
+	if want == "" {
+		if ok {
+			t.Fatalf("unexpected error: %s", exc.Err.Message)
+		}
+		return
+	}
+	if !ok {
+		t.Fatalf("expected error %q, got %v", want, res)
+	}
+	if !strings.Contains(exc.Err.Message, want) {
+		t.Errorf("invalid error: got %q - want %q", exc.Err.Message, want)
+	}
+}
@@ -0,0 +1,240 @@
+// Package joker compiles and runs joker programs from Go.
+//
+//	prog, err := joker.Compile(src, joker.WithGlobals("name"))
+//	if err != nil {
+//		return err
+//	}
+//	res, err := prog.Run(ctx, joker.RunOptions{
+//		Globals: map[string]any{"name": "world"},
+//	})
+package joker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jimmykodes/joker/builtins"
+	"github.com/jimmykodes/joker/compiler"
+	"github.com/jimmykodes/joker/lexer"
+	"github.com/jimmykodes/joker/object"
+	"github.com/jimmykodes/joker/parser"
+	"github.com/jimmykodes/joker/sandbox"
+	"github.com/jimmykodes/joker/vm"
+)
+
+var ErrUndeclaredGlobal = errors.New("undeclared global")
+
+// Program is a compiled joker program. It is safe to run a Program any number
+// of times, including concurrently.
+type Program struct {
+	bytecode *compiler.Bytecode
+	registry *builtins.Registry
+	// inputs maps the globals declared with WithGlobals to their index
+	inputs map[string]int
+	// globals maps the names of the program's globals to the index of their
+	// latest definition
+	globals map[string]int
+}
+
+type compileConfig struct {
+	filename   string
+	globals    []string
+	registry   *builtins.Registry
+	searchPath []string
+}
+
+type CompileOption func(*compileConfig)
+
+// WithFilename sets the file name used in error messages. Modules the program
+// imports are looked for relative to its directory.
+func WithFilename(filename string) CompileOption {
+	return func(c *compileConfig) {
+		c.filename = filename
+	}
+}
+
+// WithGlobals declares globals that the program can use without defining them.
+// Their values are provided by RunOptions.Globals when the program is run.
+func WithGlobals(names ...string) CompileOption {
+	return func(c *compileConfig) {
+		c.globals = append(c.globals, names...)
+	}
+}
+
+// WithRegistry makes the native functions in r available to the program
+func WithRegistry(r *builtins.Registry) CompileOption {
+	return func(c *compileConfig) {
+		c.registry = r
+	}
+}
+
+// WithSearchPath sets the directories that modules are looked for in when
+// they aren't found relative to the importing file
+func WithSearchPath(dirs ...string) CompileOption {
+	return func(c *compileConfig) {
+		c.searchPath = append(c.searchPath, dirs...)
+	}
+}
+
+// Compile parses and compiles src. If src can't be parsed, the returned error
+// joins every parser error found.
+func Compile(src string, opts ...CompileOption) (*Program, error) {
+	var cfg compileConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	p := parser.New(lexer.New(src, lexer.WithFilename(cfg.filename)))
+	prog := p.ParseProgram()
+	if err := errors.Join(p.Errors()...); err != nil {
+		return nil, err
+	}
+
+	c := compiler.New(
+		compiler.WithRegistry(cfg.registry),
+		compiler.WithFilename(cfg.filename),
+		compiler.WithSearchPath(cfg.searchPath...),
+	)
+	inputs := make(map[string]int, len(cfg.globals))
+	for _, name := range cfg.globals {
+		inputs[name] = c.SymbolTable().Define(name).Index
+	}
+	if err := c.Compile(prog); err != nil {
+		return nil, err
+	}
+
+	bytecode, err := c.Link()
+	if err != nil {
+		return nil, err
+	}
+
+	// main is laid out first when linked, so its globals keep their indices
+	globals := make(map[string]int)
+	for _, sym := range c.SymbolTable().Symbols() {
+		globals[sym.Name] = sym.Index
+	}
+	return &Program{bytecode: bytecode, registry: cfg.registry, inputs: inputs, globals: globals}, nil
+}
+
+// Globals returns the names of the program's globals, both those declared
+// with WithGlobals and those the program defines itself.
+func (p *Program) Globals() []string {
+	names := make([]string, 0, len(p.globals))
+	for name := range p.globals {
+		names = append(names, name)
+	}
+	return names
+}
+
+type RunOptions struct {
+	// Globals are set before the program starts. Values are converted with
+	// ToObject, and each name must have been declared with WithGlobals.
+	Globals map[string]any
+	// Stdin, Stdout and Stderr are the program's standard streams. If nil,
+	// os.Stdin, os.Stdout and os.Stderr are used.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// StepLimit is the maximum number of instructions the program may execute
+	// before it is stopped with vm.ErrStepLimit. Zero means no limit.
+	StepLimit int
+	// Timeout is how long the program may run before it is stopped with
+	// vm.ErrCanceled. Zero means no limit.
+	Timeout time.Duration
+	// MemoryLimit is the number of bytes the program may allocate for arrays,
+	// maps and strings before it is stopped with object.ErrMemoryLimit. Zero
+	// means no limit.
+	MemoryLimit int
+	// MaxStackSize is the number of values the VM's stack may hold, and
+	// MaxRecursionDepth the number of function calls that may be running at
+	// once, before the program is stopped with vm.ErrStackOverflow. Zero means
+	// vm.StackSize and vm.FrameStackSize.
+	MaxStackSize      int
+	MaxRecursionDepth int
+	// Permissions limits what the program may do on the host, such as which
+	// files it can open. If nil, the program has full access.
+	Permissions *sandbox.Permissions
+}
+
+// Result holds the state of a program after it has run.
+type Result struct {
+	globals map[string]object.Object
+}
+
+// Global returns the value of the named global. It returns false if the
+// program has no such global, or it was never set.
+func (r *Result) Global(name string) (object.Object, bool) {
+	obj, ok := r.globals[name]
+	return obj, ok
+}
+
+// Globals returns the values of all globals that were set.
+func (r *Result) Globals() map[string]object.Object {
+	out := make(map[string]object.Object, len(r.globals))
+	for name, obj := range r.globals {
+		out[name] = obj
+	}
+	return out
+}
+
+// Run runs the program. If ctx is done before the program finishes, it is
+// stopped with vm.ErrCanceled.
+func (p *Program) Run(ctx context.Context, opts RunOptions) (*Result, error) {
+	vmOpts := []vm.Option{
+		vm.WithRegistry(p.registry),
+		vm.WithContext(ctx),
+		vm.WithStepLimit(opts.StepLimit),
+		vm.WithTimeout(opts.Timeout),
+		vm.WithPermissions(opts.Permissions),
+	}
+	if opts.Stdin != nil {
+		vmOpts = append(vmOpts, vm.WithStdin(opts.Stdin))
+	}
+	if opts.Stdout != nil {
+		vmOpts = append(vmOpts, vm.WithStdout(opts.Stdout))
+	}
+	if opts.Stderr != nil {
+		vmOpts = append(vmOpts, vm.WithStderr(opts.Stderr))
+	}
+	if opts.MemoryLimit > 0 {
+		vmOpts = append(vmOpts, vm.WithMemoryLimit(opts.MemoryLimit))
+	}
+	if opts.MaxStackSize > 0 {
+		vmOpts = append(vmOpts, vm.WithMaxStackSize(opts.MaxStackSize))
+	}
+	if opts.MaxRecursionDepth > 0 {
+		vmOpts = append(vmOpts, vm.WithMaxRecursionDepth(opts.MaxRecursionDepth))
+	}
+	machine := vm.New(p.bytecode, vmOpts...)
+
+	// declared globals that aren't given a value start as null
+	for _, idx := range p.inputs {
+		machine.SetGlobal(idx, vm.Null)
+	}
+	for name, val := range opts.Globals {
+		idx, ok := p.inputs[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUndeclaredGlobal, name)
+		}
+		obj, err := ToObject(val)
+		if err != nil {
+			return nil, fmt.Errorf("global %s: %w", name, err)
+		}
+		machine.SetGlobal(idx, obj)
+	}
+
+	if err := machine.Run(); err != nil {
+		return nil, err
+	}
+
+	res := &Result{globals: make(map[string]object.Object, len(p.globals))}
+	for name, idx := range p.globals {
+		if obj := machine.Global(idx); obj != nil {
+			res.globals[name] = obj
+		}
+	}
+	return res, nil
+}
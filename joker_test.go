@@ -0,0 +1,290 @@
+package joker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jimmykodes/joker/builtins"
+	"github.com/jimmykodes/joker/object"
+	"github.com/jimmykodes/joker/parser"
+	"github.com/jimmykodes/joker/vm"
+)
+
+func TestProgram_Run(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		declared []string
+		globals  map[string]any
+		stdout   string
+		want     map[string]any
+	}{
+		{
+			name:   "print",
+			input:  `print("hello", 1 + 2);`,
+			stdout: "hello 3\n",
+			want:   map[string]any{},
+		},
+		{
+			name:     "set globals",
+			input:    `let greeting = "hello " + name; print(greeting);`,
+			declared: []string{"name"},
+			globals:  map[string]any{"name": "world"},
+			stdout:   "hello world\n",
+			want:     map[string]any{"name": "world", "greeting": "hello world"},
+		},
+		{
+			name:     "unset globals are null",
+			input:    `let missing = x;`,
+			declared: []string{"x"},
+			want:     map[string]any{"x": nil, "missing": nil},
+		},
+		{
+			name:     "composites",
+			input:    `let total = 0; for let i = 0; i < len(nums); i = i + 1; { total = total + nums[i]; }; let first = opts["first"];`,
+			declared: []string{"nums", "opts"},
+			globals:  map[string]any{"nums": []any{1, 2, 3}, "opts": map[string]any{"first": true}},
+			want: map[string]any{
+				"nums":  []any{int64(1), int64(2), int64(3)},
+				"opts":  map[any]any{"first": true},
+				"total": int64(6),
+				"i":     int64(3),
+				"first": true,
+			},
+		},
+		{
+			name:     "redefined global",
+			input:    `let x = x * 2.5;`,
+			declared: []string{"x"},
+			globals:  map[string]any{"x": 2},
+			want:     map[string]any{"x": 5.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := Compile(tt.input, WithGlobals(tt.declared...))
+			if err != nil {
+				t.Fatalf("compile error: %s", err)
+			}
+			var stdout bytes.Buffer
+			res, err := prog.Run(context.Background(), RunOptions{Globals: tt.globals, Stdout: &stdout})
+			if err != nil {
+				t.Fatalf("run error: %s", err)
+			}
+			if got := stdout.String(); got != tt.stdout {
+				t.Errorf("invalid stdout: got %q - want %q", got, tt.stdout)
+			}
+			got := make(map[string]any)
+			for name, obj := range res.Globals() {
+				got[name] = FromObject(obj)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid globals: got %v - want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgram_RunIO(t *testing.T) {
+	prog, err := Compile(`let name = input(); print("hello", name); eprint("done");`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	var stdout, stderr bytes.Buffer
+	opts := RunOptions{Stdin: strings.NewReader("world\n"), Stdout: &stdout, Stderr: &stderr}
+	if _, err := prog.Run(context.Background(), opts); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if got := stdout.String(); got != "hello world\n" {
+		t.Errorf("invalid stdout: got %q - want %q", got, "hello world\n")
+	}
+	if got := stderr.String(); got != "done\n" {
+		t.Errorf("invalid stderr: got %q - want %q", got, "done\n")
+	}
+}
+
+func TestProgram_RunTwice(t *testing.T) {
+	prog, err := Compile(`let out = n * n;`, WithGlobals("n"))
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	for _, n := range []int{2, 3} {
+		res, err := prog.Run(context.Background(), RunOptions{Globals: map[string]any{"n": n}})
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+		out, ok := res.Global("out")
+		if !ok {
+			t.Fatalf("missing global out")
+		}
+		if got := FromObject(out); got != int64(n*n) {
+			t.Errorf("invalid out: got %v - want %d", got, n*n)
+		}
+	}
+}
+
+func TestProgram_RunNative(t *testing.T) {
+	r := builtins.NewRegistry()
+	err := r.RegisterModule("host", map[string]object.BuiltinFunction{
+		"greet": func(rt *object.Runtime, args ...object.Object) object.Object {
+			return &object.String{Value: "hello " + args[0].(*object.String).Value}
+		},
+	})
+	if err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+	prog, err := Compile(`let out = host.greet("world");`, WithRegistry(r))
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	res, err := prog.Run(context.Background(), RunOptions{})
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	out, _ := res.Global("out")
+	if got := FromObject(out); got != "hello world" {
+		t.Errorf("invalid out: got %v - want hello world", got)
+	}
+}
+
+func TestProgram_RunImports(t *testing.T) {
+	dir, lib := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "greet.jk"), []byte(`fn hello(n) { return "hello " + n; }`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lib, "names.jk"), []byte(`let world = "world";`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Compile(
+		`import "greet"; import "names"; let out = greet.hello(names.world);`,
+		WithFilename(filepath.Join(dir, "main.jk")),
+		WithSearchPath(lib),
+	)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	res, err := prog.Run(context.Background(), RunOptions{})
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	out, _ := res.Global("out")
+	if got := FromObject(out); got != "hello world" {
+		t.Errorf("invalid out: got %v - want hello world", got)
+	}
+	// the globals of imported modules aren't the program's
+	if got := prog.Globals(); len(got) != 1 {
+		t.Errorf("invalid globals: got %v - want [out]", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		_, err := Compile("let x = ;\nlet y 2;", WithFilename("test.jk"))
+		if !errors.Is(err, parser.ErrParserError) {
+			t.Fatalf("invalid error: got %v - want %s", err, parser.ErrParserError)
+		}
+		if got := strings.Count(err.Error(), "test.jk:"); got != 2 {
+			t.Errorf("invalid number of errors reported: got %d - want 2", got)
+		}
+	})
+	t.Run("compile", func(t *testing.T) {
+		if _, err := Compile(`print(missing);`); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+	prog, err := Compile(`let x = 1; x[0];`, WithGlobals("y"))
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	t.Run("undeclared global", func(t *testing.T) {
+		_, err := prog.Run(context.Background(), RunOptions{Globals: map[string]any{"x": 1}})
+		if !errors.Is(err, ErrUndeclaredGlobal) {
+			t.Errorf("invalid error: got %v - want %s", err, ErrUndeclaredGlobal)
+		}
+	})
+	t.Run("unsupported value", func(t *testing.T) {
+		_, err := prog.Run(context.Background(), RunOptions{Globals: map[string]any{"y": struct{}{}}})
+		if !errors.Is(err, ErrUnsupportedValue) {
+			t.Errorf("invalid error: got %v - want %s", err, ErrUnsupportedValue)
+		}
+	})
+	t.Run("runtime", func(t *testing.T) {
+		if _, err := prog.Run(context.Background(), RunOptions{}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+	t.Run("step limit", func(t *testing.T) {
+		loop, err := Compile(`while true { }`)
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		if _, err := loop.Run(context.Background(), RunOptions{StepLimit: 100}); !errors.Is(err, vm.ErrStepLimit) {
+			t.Errorf("invalid error: got %v - want %s", err, vm.ErrStepLimit)
+		}
+	})
+	t.Run("memory limit", func(t *testing.T) {
+		big, err := Compile(`let s = "x"; while true { s = s + s; }`)
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		if _, err := big.Run(context.Background(), RunOptions{MemoryLimit: 1000}); !errors.Is(err, object.ErrMemoryLimit) {
+			t.Errorf("invalid error: got %v - want %s", err, object.ErrMemoryLimit)
+		}
+	})
+	t.Run("recursion depth", func(t *testing.T) {
+		deep, err := Compile(`fn f(n) { if n == 0 { return 0; } return 1 + f(n - 1); } f(100);`)
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		if _, err := deep.Run(context.Background(), RunOptions{MaxRecursionDepth: 100}); !errors.Is(err, vm.ErrStackOverflow) {
+			t.Errorf("invalid error: got %v - want %s", err, vm.ErrStackOverflow)
+		}
+		if _, err := deep.Run(context.Background(), RunOptions{MaxRecursionDepth: 101}); err != nil {
+			t.Errorf("run error: %s", err)
+		}
+	})
+	t.Run("unset global", func(t *testing.T) {
+		unset, err := Compile(`if false { y := 1; } y();`)
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		var rtErr *vm.RuntimeError
+		if _, err := unset.Run(context.Background(), RunOptions{}); !errors.As(err, &rtErr) {
+			t.Errorf("invalid error: got %v - want runtime error", err)
+		}
+	})
+	t.Run("panic", func(t *testing.T) {
+		r := builtins.NewRegistry()
+		err := r.RegisterModule("host", map[string]object.BuiltinFunction{
+			"crash": func(rt *object.Runtime, args ...object.Object) object.Object {
+				panic("crash")
+			},
+		})
+		if err != nil {
+			t.Fatalf("register error: %s", err)
+		}
+		crash, err := Compile(`host.crash();`, WithRegistry(r))
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		_, err = crash.Run(context.Background(), RunOptions{})
+		var rtErr *vm.RuntimeError
+		if !errors.Is(err, vm.ErrPanic) || !errors.As(err, &rtErr) {
+			t.Errorf("invalid error: got %v - want %s", err, vm.ErrPanic)
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := prog.Run(ctx, RunOptions{})
+		if !errors.Is(err, vm.ErrCanceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("invalid error: got %v - want %s", err, vm.ErrCanceled)
+		}
+	})
+}
@@ -1,32 +1,57 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/jimmykodes/joker/token"
 )
 
-func New(input string) *Lexer {
+func New(input string, opts ...Option) *Lexer {
 	l := &Lexer{input: input, lineNum: 1}
+	for _, opt := range opts {
+		opt(l)
+	}
 	l.next()
 	return l
 }
 
+type Option func(*Lexer)
+
+// WithFilename sets the file name reported in token positions
+func WithFilename(filename string) Option {
+	return func(l *Lexer) {
+		l.filename = filename
+	}
+}
+
 type Lexer struct {
 	input        string
+	filename     string
 	position     int
 	readPosition int
 	lineNum      int
-	ch           byte
+	// lineStart is the offset of the first character of the current line
+	lineStart int
+	ch        byte
 }
 
-func (l *Lexer) NextToken() (token.Token, int, string) {
+// NextToken returns the next token in the input, along with the position it
+// starts at, the position immediately after it, and its literal value. When
+// the input is invalid, the token will be token.Illegal and the literal will
+// describe the problem.
+func (l *Lexer) NextToken() (token.Token, token.Position, token.Position, string) {
 	l.stripWhitespace()
+	pos := l.pos()
 	switch {
 	case isLetter(l.ch):
 		tok, lit := l.readIdent()
-		return tok, l.lineNum, lit
+		return tok, pos, l.pos(), lit
 	case isDigit(l.ch):
 		tok, lit := l.readNumber()
-		return tok, l.lineNum, lit
+		return tok, pos, l.pos(), lit
 	default:
 		var (
 			lit          string
@@ -44,6 +69,10 @@ func (l *Lexer) NextToken() (token.Token, int, string) {
 			tok = l.switchEQ(token.NOT, token.NEQ)
 		case '=':
 			tok = l.switchEQ(token.Assign, token.EQ)
+		case '&':
+			tok = l.switchNext('&', token.Illegal, token.AND)
+		case '|':
+			tok = l.switchNext('|', token.Illegal, token.OR)
 		case '(':
 			tok = token.LParen
 		case ')':
@@ -70,6 +99,8 @@ func (l *Lexer) NextToken() (token.Token, int, string) {
 			tok = token.Comma
 		case ';':
 			tok = token.SemiCol
+		case '.':
+			tok = token.Dot
 		case ':':
 			tok = l.switchEQ(token.Colon, token.Define)
 		case '#':
@@ -78,19 +109,42 @@ func (l *Lexer) NextToken() (token.Token, int, string) {
 			tok = token.Comment
 			litFromToken = false
 		case '"':
-			l.next()
-			lit = l.readMultiple(func(b byte) bool { return b != '"' })
-			tok = token.String
+			tok, lit = l.readString()
+			litFromToken = false
+		case '`':
+			tok, lit = l.readRawString()
 			litFromToken = false
 		}
 		if litFromToken {
 			lit = tok.String()
+			if tok == token.Illegal {
+				lit = fmt.Sprintf("unexpected character %q", l.ch)
+			}
+		}
+		if tok == token.EOF {
+			return tok, pos, pos, lit
 		}
 		l.next()
-		return tok, l.lineNum, lit
+		return tok, pos, l.pos(), lit
+	}
+}
+
+// pos returns the position of the current character
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		File:   l.filename,
+		Line:   l.lineNum,
+		Column: l.position - l.lineStart + 1,
+		Offset: l.position,
 	}
 }
 
+// newline records that the current character is a newline
+func (l *Lexer) newline() {
+	l.lineNum++
+	l.lineStart = l.position + 1
+}
+
 func (l *Lexer) next() {
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
@@ -122,8 +176,8 @@ func (l *Lexer) readMultiple(tester func(byte) bool) string {
 
 func (l *Lexer) stripWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
-		if l.ch == '\n' || l.ch == '\r' {
-			l.lineNum++
+		if l.ch == '\n' {
+			l.newline()
 		}
 		l.next()
 	}
@@ -137,6 +191,10 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
 func (l *Lexer) readNumber() (token.Token, string) {
 	startPos := l.position
 	tok := token.Illegal
@@ -163,6 +221,105 @@ func (l *Lexer) readIdent() (token.Token, string) {
 	return token.Lookup(ident), ident
 }
 
+// readString reads a double quoted string, resolving escape sequences. It
+// stops with l.ch on the closing quote. Strings cannot span lines, use a raw
+// string for that.
+func (l *Lexer) readString() (token.Token, string) {
+	var (
+		sb     strings.Builder
+		errMsg string
+	)
+	for {
+		l.next()
+		switch l.ch {
+		case '"':
+			if errMsg != "" {
+				return token.Illegal, errMsg
+			}
+			return token.String, sb.String()
+		case 0:
+			return token.Illegal, "unterminated string"
+		case '\n':
+			l.newline()
+			return token.Illegal, "unterminated string"
+		case '\r':
+			return token.Illegal, "unterminated string"
+		case '\\':
+			if next := l.peekChar(); next == 0 || next == '\n' || next == '\r' {
+				// nothing to escape, the string is unterminated
+				continue
+			}
+			l.next()
+			r, msg := l.readEscape()
+			if msg != "" && errMsg == "" {
+				// keep reading to the end of the string, but only report the first bad escape
+				errMsg = msg
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(l.ch)
+		}
+	}
+}
+
+// readEscape resolves the escape sequence starting at l.ch, the character
+// after the backslash. On failure it returns an error message.
+func (l *Lexer) readEscape() (rune, string) {
+	switch l.ch {
+	case 'n':
+		return '\n', ""
+	case 't':
+		return '\t', ""
+	case 'r':
+		return '\r', ""
+	case '\\':
+		return '\\', ""
+	case '"':
+		return '"', ""
+	case 'u':
+		if l.peekChar() != '{' {
+			return 0, `invalid unicode escape: expected \u{...}`
+		}
+		l.next()
+		var hex strings.Builder
+		for l.peekChar() != '}' {
+			if !isHexDigit(l.peekChar()) || hex.Len() == 6 {
+				return 0, `invalid unicode escape: expected up to 6 hex digits in \u{...}`
+			}
+			l.next()
+			hex.WriteByte(l.ch)
+		}
+		l.next()
+		if hex.Len() == 0 {
+			return 0, `invalid unicode escape: expected up to 6 hex digits in \u{...}`
+		}
+		v, _ := strconv.ParseUint(hex.String(), 16, 32)
+		if !utf8.ValidRune(rune(v)) {
+			return 0, fmt.Sprintf("invalid unicode escape: %s is not a valid code point", hex.String())
+		}
+		return rune(v), ""
+	default:
+		return 0, fmt.Sprintf("invalid escape sequence: \\%c", l.ch)
+	}
+}
+
+// readRawString reads a backtick quoted string. Raw strings have no escape
+// sequences and can span lines. It stops with l.ch on the closing backtick.
+func (l *Lexer) readRawString() (token.Token, string) {
+	l.next()
+	startPos := l.position
+	for l.ch != '`' {
+		switch l.ch {
+		case 0:
+			return token.Illegal, "unterminated raw string"
+		case '\n':
+			l.newline()
+		}
+		l.next()
+	}
+	return token.String, l.input[startPos:l.position]
+}
+
 func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.next()
@@ -170,7 +327,13 @@ func (l *Lexer) readDigits() {
 }
 
 func (l *Lexer) switchEQ(tok0, tok1 token.Token) token.Token {
-	if l.peekChar() == '=' {
+	return l.switchNext('=', tok0, tok1)
+}
+
+// switchNext returns tok1 and consumes the next character if it is next,
+// otherwise it returns tok0.
+func (l *Lexer) switchNext(next byte, tok0, tok1 token.Token) token.Token {
+	if l.peekChar() == next {
 		l.advancePos()
 		return tok1
 	}
@@ -31,6 +31,18 @@ func TestLexer_NextToken(t *testing.T) {
 				{token.SemiCol, 1, ";"},
 			},
 		},
+		{
+			name:  "selector",
+			input: "math.sqrt(2.5)",
+			want: []result{
+				{token.Ident, 1, "math"},
+				{token.Dot, 1, "."},
+				{token.Ident, 1, "sqrt"},
+				{token.LParen, 1, "("},
+				{token.Float, 1, "2.5"},
+				{token.RParen, 1, ")"},
+			},
+		},
 		{
 			name:  "bools",
 			input: "true false",
@@ -49,6 +61,18 @@ func TestLexer_NextToken(t *testing.T) {
 				{token.LTE, 1, "<="},
 			},
 		},
+		{
+			name:  "logical operators",
+			input: "a && b || not c",
+			want: []result{
+				{token.Ident, 1, "a"},
+				{token.AND, 1, "&&"},
+				{token.Ident, 1, "b"},
+				{token.OR, 1, "||"},
+				{token.NOT, 1, "not"},
+				{token.Ident, 1, "c"},
+			},
+		},
 		{
 			name:  "assignment of string",
 			input: `let my_val = "test";`,
@@ -60,6 +84,58 @@ func TestLexer_NextToken(t *testing.T) {
 				{token.SemiCol, 1, ";"},
 			},
 		},
+		{
+			name:  "string escapes",
+			input: `"a\nb\t\"c\"\\" "\u{48}\u{1F600}"`,
+			want: []result{
+				{token.String, 1, "a\nb\t\"c\"\\"},
+				{token.String, 1, "H\U0001F600"},
+			},
+		},
+		{
+			name:  "raw string",
+			input: "let x = `first\nsecond \\n \"third\"`;\nx;",
+			want: []result{
+				{token.Let, 1, "let"},
+				{token.Ident, 1, "x"},
+				{token.Assign, 1, "="},
+				{token.String, 1, "first\nsecond \\n \"third\""},
+				{token.SemiCol, 2, ";"},
+				{token.Ident, 3, "x"},
+				{token.SemiCol, 3, ";"},
+			},
+		},
+		{
+			name:  "invalid escape",
+			input: `"bad \q" x`,
+			want: []result{
+				{token.Illegal, 1, "invalid escape sequence: \\q"},
+				{token.Ident, 1, "x"},
+			},
+		},
+		{
+			name:  "invalid unicode escape",
+			input: `"\u{110000}" "\u{zz}"`,
+			want: []result{
+				{token.Illegal, 1, "invalid unicode escape: 110000 is not a valid code point"},
+				{token.Illegal, 1, "invalid unicode escape: expected up to 6 hex digits in \\u{...}"},
+			},
+		},
+		{
+			name:  "unterminated string",
+			input: "\"open\nx",
+			want: []result{
+				{token.Illegal, 1, "unterminated string"},
+				{token.Ident, 2, "x"},
+			},
+		},
+		{
+			name:  "unterminated raw string",
+			input: "`open\nx",
+			want: []result{
+				{token.Illegal, 1, "unterminated raw string"},
+			},
+		},
 		{
 			name:  "assignment of int",
 			input: "let my_int = 5;",
@@ -236,13 +312,13 @@ func TestLexer_NextToken(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			l := New(tt.input)
 			for i, want := range tt.want {
-				gotToken, gotLine, gotLit := l.NextToken()
+				gotToken, gotPos, _, gotLit := l.NextToken()
 				if gotToken != want.token {
 					t.Errorf("invalid token %d: got %s - want %s", i, gotToken, want.token)
 					return
 				}
-				if gotLine != want.line {
-					t.Errorf("invalid line for token %d: got %d - want %d", i, gotLine, want.line)
+				if gotPos.Line != want.line {
+					t.Errorf("invalid line for token %d: got %d - want %d", i, gotPos.Line, want.line)
 					return
 				}
 				if !strings.EqualFold(gotLit, want.lit) {
@@ -250,7 +326,7 @@ func TestLexer_NextToken(t *testing.T) {
 					return
 				}
 			}
-			tok, _, _ := l.NextToken()
+			tok, _, _, _ := l.NextToken()
 			if tok != token.EOF {
 				t.Errorf("tokens remain: got %s", tok)
 				return
@@ -350,3 +426,40 @@ func TestLexer_readIdent(t *testing.T) {
 		})
 	}
 }
+
+func TestLexer_positions(t *testing.T) {
+	type span struct {
+		token      token.Token
+		start, end token.Position
+	}
+	pos := func(line, col, offset int) token.Position {
+		return token.Position{File: "test.jk", Line: line, Column: col, Offset: offset}
+	}
+	input := "let abc = 10;\n  x >= \"hi\"\n`a\nb` y"
+	want := []span{
+		{token.Let, pos(1, 1, 0), pos(1, 4, 3)},
+		{token.Ident, pos(1, 5, 4), pos(1, 8, 7)},
+		{token.Assign, pos(1, 9, 8), pos(1, 10, 9)},
+		{token.Int, pos(1, 11, 10), pos(1, 13, 12)},
+		{token.SemiCol, pos(1, 13, 12), pos(1, 14, 13)},
+		{token.Ident, pos(2, 3, 16), pos(2, 4, 17)},
+		{token.GTE, pos(2, 5, 18), pos(2, 7, 20)},
+		{token.String, pos(2, 8, 21), pos(2, 12, 25)},
+		{token.String, pos(3, 1, 26), pos(4, 3, 31)},
+		{token.Ident, pos(4, 4, 32), pos(4, 5, 33)},
+		{token.EOF, pos(4, 5, 33), pos(4, 5, 33)},
+	}
+	l := New(input, WithFilename("test.jk"))
+	for i, w := range want {
+		tok, start, end, _ := l.NextToken()
+		if tok != w.token {
+			t.Fatalf("invalid token %d: got %s - want %s", i, tok, w.token)
+		}
+		if start != w.start {
+			t.Errorf("invalid start for token %d: got %#v - want %#v", i, start, w.start)
+		}
+		if end != w.end {
+			t.Errorf("invalid end for token %d: got %#v - want %#v", i, end, w.end)
+		}
+	}
+}
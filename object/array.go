@@ -1,6 +1,7 @@
 package object
 
 import (
+	"encoding/binary"
 	"fmt"
 	"strings"
 )
@@ -18,6 +19,66 @@ func (a *Array) Inspect() string {
 	return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
 }
 
+func (a *Array) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 9 {
+		return 0, fmt.Errorf("invalid array: not enough data")
+	}
+	if t := Type(data[0]); t != a.Type() {
+		return 0, fmt.Errorf("invalid type: got %s - want %s", t, a.Type())
+	}
+	numElems := binary.BigEndian.Uint64(data[1:])
+	// every element takes at least a byte
+	if numElems > uint64(len(data)-9) {
+		return 0, fmt.Errorf("invalid array: not enough data")
+	}
+	ptr := 9
+	a.Elements = make([]Object, numElems)
+	for i := range a.Elements {
+		elem, n, err := Decode(data[ptr:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid array element %d: %w", i, err)
+		}
+		a.Elements[i] = elem
+		ptr += n
+	}
+	return ptr, nil
+}
+
+func (a *Array) MarshalBytes() ([]byte, error) {
+	out := make([]byte, 9)
+	out[0] = byte(a.Type())
+	binary.BigEndian.PutUint64(out[1:], uint64(len(a.Elements)))
+	for i, elem := range a.Elements {
+		b, err := Encode(elem)
+		if err != nil {
+			return nil, fmt.Errorf("array element %d: %w", i, err)
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+func (a *Array) MarshalCompact(w *CompactWriter) error {
+	w.Uvarint(uint64(len(a.Elements)))
+	for i, elem := range a.Elements {
+		if err := w.Object(elem); err != nil {
+			return fmt.Errorf("array element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (a *Array) UnmarshalCompact(r *CompactReader) error {
+	a.Elements = make([]Object, r.Count())
+	for i := range a.Elements {
+		a.Elements[i] = r.Object()
+		if r.Err() != nil {
+			return fmt.Errorf("invalid array element %d: %w", i, r.Err())
+		}
+	}
+	return r.Err()
+}
+
 func (a *Array) Len() *Integer {
 	return &Integer{Value: int64(len(a.Elements))}
 }
@@ -25,10 +86,10 @@ func (a *Array) Len() *Integer {
 func (a *Array) Idx(obj Object) Object {
 	o, ok := obj.(*Integer)
 	if !ok {
-		return ErrUnsupportedType
+		return &Exception{Err: ErrUnsupportedType}
 	}
-	if o.Value >= int64(len(a.Elements)) {
-		return &Error{Message: fmt.Sprintf("index out of range [%d] with length %d", o.Value, len(a.Elements))}
+	if o.Value < 0 || o.Value >= int64(len(a.Elements)) {
+		return &Exception{Err: &Error{Message: fmt.Sprintf("index out of range [%d] with length %d", o.Value, len(a.Elements))}}
 	}
 	return a.Elements[o.Value]
 }
@@ -38,7 +99,7 @@ func (a *Array) Set(key, value Object) Object {
 	if !ok {
 		return ErrUnsupportedType
 	}
-	if o.Value >= int64(len(a.Elements)) {
+	if o.Value < 0 || o.Value >= int64(len(a.Elements)) {
 		return &Error{Message: fmt.Sprintf("index out of range [%d] with length %d", o.Value, len(a.Elements))}
 	}
 	a.Elements[o.Value] = value
@@ -1,6 +1,7 @@
 package object
 
 import (
+	"fmt"
 	"strconv"
 )
 
@@ -16,6 +17,55 @@ type Boolean struct {
 func (b *Boolean) Type() Type      { return BoolType }
 func (b *Boolean) Inspect() string { return strconv.FormatBool(b.Value) }
 
+func (b *Boolean) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 2 {
+		return 0, fmt.Errorf("invalid boolean: not enough data")
+	}
+	if t := Type(data[0]); t != b.Type() {
+		return 0, fmt.Errorf("invalid type: got %s - want %s", t, b.Type())
+	}
+	switch data[1] {
+	case 0:
+		b.Value = false
+	case 1:
+		b.Value = true
+	default:
+		return 0, fmt.Errorf("invalid boolean: %d", data[1])
+	}
+	return 2, nil
+}
+
+func (b *Boolean) MarshalBytes() ([]byte, error) {
+	out := []byte{byte(b.Type()), 0}
+	if b.Value {
+		out[1] = 1
+	}
+	return out, nil
+}
+
+func (b *Boolean) MarshalCompact(w *CompactWriter) error {
+	if b.Value {
+		w.Uvarint(1)
+	} else {
+		w.Uvarint(0)
+	}
+	return nil
+}
+
+func (b *Boolean) UnmarshalCompact(r *CompactReader) error {
+	switch v := r.Uvarint(); v {
+	case 0:
+		b.Value = false
+	case 1:
+		b.Value = true
+	default:
+		if r.Err() == nil {
+			return fmt.Errorf("invalid boolean: %d", v)
+		}
+	}
+	return r.Err()
+}
+
 func (b *Boolean) Bool() *Boolean {
 	return b
 }
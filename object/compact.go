@@ -0,0 +1,265 @@
+package object
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// CompactEncodable is implemented by objects that can be written in the
+// compact encoding, where integers are varints and strings are indexes into a
+// table shared by every object written with the same CompactWriter
+type CompactEncodable interface {
+	MarshalCompact(w *CompactWriter) error
+	UnmarshalCompact(r *CompactReader) error
+}
+
+// CompactDecoder decodes an object of a single type from r, after its type
+// has been read
+type CompactDecoder func(r *CompactReader) (Object, error)
+
+var (
+	compactDecodersMu sync.RWMutex
+	compactDecoders   = make(map[Type]CompactDecoder)
+)
+
+func init() {
+	RegisterCompactDecoder(NullType, func(r *CompactReader) (Object, error) { return NullValue, nil })
+	RegisterCompactDecoder(BoolType, decodeCompactBool)
+	RegisterCompactDecoder(IntegerType, CompactInto(func() CompactEncodable { return &Integer{} }))
+	RegisterCompactDecoder(FloatType, CompactInto(func() CompactEncodable { return &Float{} }))
+	RegisterCompactDecoder(StringType, CompactInto(func() CompactEncodable { return &String{} }))
+	RegisterCompactDecoder(ArrayType, CompactInto(func() CompactEncodable { return &Array{} }))
+	RegisterCompactDecoder(MapType, CompactInto(func() CompactEncodable { return &Map{} }))
+	RegisterCompactDecoder(CompiledFunctionType, CompactInto(func() CompactEncodable { return &CompiledFunction{} }))
+}
+
+// RegisterCompactDecoder sets the compact decoder for objects of type t,
+// replacing any that was registered before
+func RegisterCompactDecoder(t Type, d CompactDecoder) {
+	compactDecodersMu.Lock()
+	defer compactDecodersMu.Unlock()
+	compactDecoders[t] = d
+}
+
+// CompactInto returns a CompactDecoder that unmarshals into a new object made
+// by newObj. The object must be an Object as well as CompactEncodable.
+func CompactInto(newObj func() CompactEncodable) CompactDecoder {
+	return func(r *CompactReader) (Object, error) {
+		obj := newObj()
+		if err := obj.UnmarshalCompact(r); err != nil {
+			return nil, err
+		}
+		return obj.(Object), nil
+	}
+}
+
+func decodeCompactBool(r *CompactReader) (Object, error) {
+	var b Boolean
+	if err := b.UnmarshalCompact(r); err != nil {
+		return nil, err
+	}
+	if b.Value {
+		return True, nil
+	}
+	return False, nil
+}
+
+// CompactWriter writes objects in the compact encoding. Strings are collected
+// in a table, which must be stored alongside the data for a CompactReader.
+type CompactWriter struct {
+	buf     []byte
+	strings []string
+	index   map[string]int
+}
+
+func NewCompactWriter() *CompactWriter {
+	return &CompactWriter{index: make(map[string]int)}
+}
+
+// Data returns everything that has been written
+func (w *CompactWriter) Data() []byte { return w.buf }
+
+// Strings returns the string table, in the order strings were first written
+func (w *CompactWriter) Strings() []string { return w.strings }
+
+// Uvarint writes n as an unsigned varint
+func (w *CompactWriter) Uvarint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutUvarint(b[:], n)]...)
+}
+
+// Varint writes n as a zigzag encoded varint, so that small negative numbers
+// are as short as small positive ones
+func (w *CompactWriter) Varint(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutVarint(b[:], n)]...)
+}
+
+// Fixed writes b as is, for data that is always the same length
+func (w *CompactWriter) Fixed(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+// Bytes writes the length of b, then b
+func (w *CompactWriter) Bytes(b []byte) {
+	w.Uvarint(uint64(len(b)))
+	w.Fixed(b)
+}
+
+// String writes the index of s in the string table, adding s if it isn't
+// there yet
+func (w *CompactWriter) String(s string) {
+	idx, ok := w.index[s]
+	if !ok {
+		idx = len(w.strings)
+		w.index[s] = idx
+		w.strings = append(w.strings, s)
+	}
+	w.Uvarint(uint64(idx))
+}
+
+// Object writes the type of obj, then obj, which must be CompactEncodable
+func (w *CompactWriter) Object(obj Object) error {
+	e, ok := obj.(CompactEncodable)
+	if !ok {
+		return fmt.Errorf("cannot encode %s", obj.Type())
+	}
+	w.buf = append(w.buf, byte(obj.Type()))
+	return e.MarshalCompact(w)
+}
+
+// CompactReader reads what a CompactWriter wrote. Once a read fails, Err
+// returns the error and every later read returns the zero value.
+type CompactReader struct {
+	data    []byte
+	pos     int
+	strings []string
+	err     error
+}
+
+// NewCompactReader returns a reader of data, which was written with the given
+// string table
+func NewCompactReader(data []byte, strings []string) *CompactReader {
+	return &CompactReader{data: data, strings: strings}
+}
+
+// Err returns the error of the first read that failed
+func (r *CompactReader) Err() error { return r.err }
+
+// Remaining returns the number of bytes that haven't been read
+func (r *CompactReader) Remaining() int { return len(r.data) - r.pos }
+
+func (r *CompactReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Uvarint reads an unsigned varint
+func (r *CompactReader) Uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, read := binary.Uvarint(r.data[r.pos:])
+	if read <= 0 {
+		r.fail(errors.New("invalid varint"))
+		return 0
+	}
+	r.pos += read
+	return n
+}
+
+// Varint reads a zigzag encoded varint
+func (r *CompactReader) Varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	n, read := binary.Varint(r.data[r.pos:])
+	if read <= 0 {
+		r.fail(errors.New("invalid varint"))
+		return 0
+	}
+	r.pos += read
+	return n
+}
+
+// Int reads an unsigned varint that is used as a size or an index
+func (r *CompactReader) Int() int {
+	n := r.Uvarint()
+	if n > math.MaxInt32 {
+		r.fail(fmt.Errorf("invalid value %d", n))
+		return 0
+	}
+	return int(n)
+}
+
+// Count reads the number of items that follow. Every item takes at least a
+// byte, so there can't be more of them than there is data left.
+func (r *CompactReader) Count() int {
+	n := r.Int()
+	if n > r.Remaining() {
+		r.fail(fmt.Errorf("invalid length %d", n))
+		return 0
+	}
+	return n
+}
+
+// Fixed reads the next n bytes
+func (r *CompactReader) Fixed(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.Remaining() < n {
+		r.fail(errors.New("not enough data"))
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// Bytes reads what CompactWriter.Bytes wrote
+func (r *CompactReader) Bytes() []byte {
+	n := r.Count()
+	return r.Fixed(n)
+}
+
+// String reads what CompactWriter.String wrote
+func (r *CompactReader) String() string {
+	idx := r.Int()
+	if r.err != nil {
+		return ""
+	}
+	if idx >= len(r.strings) {
+		r.fail(fmt.Errorf("string %d out of range, there are %d", idx, len(r.strings)))
+		return ""
+	}
+	return r.strings[idx]
+}
+
+// Object reads what CompactWriter.Object wrote, with the decoder registered
+// for its type
+func (r *CompactReader) Object() Object {
+	b := r.Fixed(1)
+	if b == nil {
+		return nil
+	}
+	t := Type(b[0])
+	compactDecodersMu.RLock()
+	d, ok := compactDecoders[t]
+	compactDecodersMu.RUnlock()
+	if !ok {
+		r.fail(fmt.Errorf("cannot decode %s", t))
+		return nil
+	}
+	obj, err := d(r)
+	if err != nil {
+		// the error may wrap one that was set by a read, so it replaces it
+		r.err = err
+		return nil
+	}
+	return obj
+}
@@ -0,0 +1,94 @@
+package object
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/jimmykodes/joker/code"
+)
+
+func TestCompactEncoding(t *testing.T) {
+	key := &String{Value: "k"}
+	tests := []struct {
+		obj          Object
+		expectedSize int
+		strings      []string
+	}{
+		{&Integer{Value: 0}, 2, nil},
+		{&Integer{Value: -12}, 2, nil},
+		{&Integer{Value: 300}, 3, nil},
+		{&Integer{Value: math.MaxInt64}, 11, nil},
+		{&Integer{Value: math.MinInt64}, 11, nil},
+		{&Float{Value: -1.5}, 9, nil},
+		{&String{Value: "hello, world"}, 2, []string{"hello, world"}},
+		{True, 2, nil},
+		{NullValue, 1, nil},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}, &String{Value: "a"}}}, 8, []string{"a"}},
+		{&Map{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: &Float{Value: 2.5}}}}, 13, []string{"k"}},
+		{
+			&CompiledFunction{
+				Instructions: code.Instruction(code.OpGetLocal, 0),
+				NumLocals:    1,
+				NumParams:    1,
+				Name:         "id",
+				Lines:        code.LineTable{{Offset: 0, Line: 3}},
+			},
+			// type, locals, params, name, instructions, then the line table
+			1 + 1 + 1 + 1 + 3 + 4,
+			[]string{"id"},
+		},
+	}
+	for _, tt := range tests {
+		w := NewCompactWriter()
+		if err := w.Object(tt.obj); err != nil {
+			t.Errorf("marshal error for %s: %s", tt.obj.Inspect(), err)
+			continue
+		}
+		if got := len(w.Data()); got != tt.expectedSize {
+			t.Errorf("invalid size for %s: got %d - want %d", tt.obj.Inspect(), got, tt.expectedSize)
+		}
+		if !reflect.DeepEqual(w.Strings(), tt.strings) {
+			t.Errorf("invalid strings for %s: got %q - want %q", tt.obj.Inspect(), w.Strings(), tt.strings)
+		}
+
+		r := NewCompactReader(w.Data(), w.Strings())
+		obj := r.Object()
+		if r.Err() != nil {
+			t.Errorf("unmarshal error for %s: %s", tt.obj.Inspect(), r.Err())
+			continue
+		}
+		if r.Remaining() != 0 {
+			t.Errorf("invalid bytes read for %s: %d left", tt.obj.Inspect(), r.Remaining())
+		}
+		if !reflect.DeepEqual(obj, tt.obj) {
+			t.Errorf("invalid unmarshal object: got %+v - want %+v", obj, tt.obj)
+		}
+	}
+}
+
+func TestCompactDecodeErrors(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		strings []string
+		err     string
+	}{
+		{nil, nil, "not enough data"},
+		{[]byte{0xff}, nil, "cannot decode Type(255)"},
+		{[]byte{byte(IntegerType), 0x80}, nil, "invalid varint"},
+		{[]byte{byte(StringType), 1}, []string{"a"}, "string 1 out of range, there are 1"},
+		{[]byte{byte(BoolType), 2}, nil, "invalid boolean: 2"},
+		{[]byte{byte(ArrayType), 5, byte(NullType)}, nil, "invalid length 5"},
+		{[]byte{byte(ArrayType), 1, 0xff}, nil, "invalid array element 0: cannot decode Type(255)"},
+		{[]byte{byte(MapType), 1, byte(ArrayType), 0, byte(NullType)}, nil, "invalid map key: ArrayType is not hashable"},
+	}
+	for _, tt := range tests {
+		r := NewCompactReader(tt.data, tt.strings)
+		if obj := r.Object(); obj != nil {
+			t.Errorf("expected no object for %v, got %s", tt.data, obj.Inspect())
+		}
+		if r.Err() == nil || r.Err().Error() != tt.err {
+			t.Errorf("invalid error for %v: got %v - want %s", tt.data, r.Err(), tt.err)
+		}
+	}
+}
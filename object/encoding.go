@@ -0,0 +1,96 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Decoder decodes an object from the start of data, which begins with the
+// object's type, returning the number of bytes read
+type Decoder func(data []byte) (Object, int, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = make(map[Type]Decoder)
+)
+
+func init() {
+	RegisterDecoder(NullType, decodeNull)
+	RegisterDecoder(BoolType, decodeBool)
+	RegisterDecoder(IntegerType, DecodeInto(func() Encodable { return &Integer{} }))
+	RegisterDecoder(FloatType, DecodeInto(func() Encodable { return &Float{} }))
+	RegisterDecoder(StringType, DecodeInto(func() Encodable { return &String{} }))
+	RegisterDecoder(ArrayType, DecodeInto(func() Encodable { return &Array{} }))
+	RegisterDecoder(MapType, DecodeInto(func() Encodable { return &Map{} }))
+	RegisterDecoder(CompiledFunctionType, DecodeInto(func() Encodable { return &CompiledFunction{} }))
+}
+
+// RegisterDecoder sets the decoder for objects of type t, replacing any that
+// was registered before
+func RegisterDecoder(t Type, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[t] = d
+}
+
+// DecodeInto returns a Decoder that unmarshals into a new object made by
+// newObj. The object must be an Object as well as Encodable.
+func DecodeInto(newObj func() Encodable) Decoder {
+	return func(data []byte) (Object, int, error) {
+		obj := newObj()
+		n, err := obj.UnmarshalBytes(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		return obj.(Object), n, nil
+	}
+}
+
+// Decode decodes the object at the start of data with the decoder registered
+// for its type, returning the number of bytes read
+func Decode(data []byte) (Object, int, error) {
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("invalid object: not enough data")
+	}
+	t := Type(data[0])
+	decodersMu.RLock()
+	d, ok := decoders[t]
+	decodersMu.RUnlock()
+	if !ok {
+		return nil, 0, fmt.Errorf("cannot decode %s", t)
+	}
+	return d(data)
+}
+
+// Encode encodes obj, which must be Encodable
+func Encode(obj Object) ([]byte, error) {
+	e, ok := obj.(Encodable)
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %s", obj.Type())
+	}
+	return e.MarshalBytes()
+}
+
+// decodeBool decodes to True or False, since booleans are compared by
+// identity
+func decodeBool(data []byte) (Object, int, error) {
+	var b Boolean
+	n, err := b.UnmarshalBytes(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if b.Value {
+		return True, n, nil
+	}
+	return False, n, nil
+}
+
+// decodeNull decodes to NullValue, since null is compared by identity
+func decodeNull(data []byte) (Object, int, error) {
+	var null Null
+	n, err := null.UnmarshalBytes(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return NullValue, n, nil
+}
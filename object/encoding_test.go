@@ -4,6 +4,8 @@ import (
 	"math"
 	"reflect"
 	"testing"
+
+	"github.com/jimmykodes/joker/code"
 )
 
 func TestIntegerEncoding(t *testing.T) {
@@ -114,7 +116,17 @@ func TestCompiledFunctionEncoding(t *testing.T) {
 				NumLocals:    5,
 				NumParams:    3,
 			},
-			expectedRead: 46,
+			expectedRead: 62,
+		},
+		{
+			obj: &CompiledFunction{
+				Instructions: []byte{0, 0, 0, 0, 0, 1, 7, 8, 0, 0, 2, 0, 0, 3, 12, 22, 0, 5, 18, 0, 0},
+				NumLocals:    1,
+				NumParams:    1,
+				Name:         "add",
+				Lines:        code.LineTable{{Offset: 0, Line: 2}, {Offset: 6, Line: 3}},
+			},
+			expectedRead: 97,
 		},
 		{
 			obj: &CompiledFunction{
@@ -122,7 +134,7 @@ func TestCompiledFunctionEncoding(t *testing.T) {
 				NumLocals:    0,
 				NumParams:    0,
 			},
-			expectedRead: 25,
+			expectedRead: 41,
 		},
 	}
 	for _, tt := range tests {
@@ -149,3 +161,204 @@ func TestCompiledFunctionEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestBooleanEncoding(t *testing.T) {
+	tests := []struct {
+		obj          *Boolean
+		expectedRead int
+	}{
+		{&Boolean{Value: true}, 2},
+		{&Boolean{Value: false}, 2},
+	}
+	for _, tt := range tests {
+		gotBytes, err := tt.obj.MarshalBytes()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		var obj Boolean
+		gotRead, err := obj.UnmarshalBytes(gotBytes)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if gotRead != tt.expectedRead {
+			t.Errorf("invalid bytes read: got %v - want %v", gotRead, tt.expectedRead)
+			continue
+		}
+
+		if !reflect.DeepEqual(&obj, tt.obj) {
+			t.Errorf("invalid unmarshal object: got %+v - want %+v", &obj, tt.obj)
+			continue
+		}
+	}
+}
+
+func TestNullEncoding(t *testing.T) {
+	gotBytes, err := NullValue.MarshalBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var obj Null
+	gotRead, err := obj.UnmarshalBytes(gotBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotRead != 1 {
+		t.Errorf("invalid bytes read: got %v - want %v", gotRead, 1)
+	}
+}
+
+func TestArrayEncoding(t *testing.T) {
+	tests := []struct {
+		obj          *Array
+		expectedRead int
+	}{
+		{&Array{Elements: []Object{}}, 9},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "two"}, True}}, 32},
+		{
+			obj: &Array{Elements: []Object{
+				&Array{Elements: []Object{&Float{Value: 1.5}, NullValue}},
+				&Map{Pairs: map[HashKey]HashPair{
+					(&String{Value: "a"}).HashKey(): {Key: &String{Value: "a"}, Value: False},
+				}},
+			}},
+			expectedRead: 49,
+		},
+	}
+	for _, tt := range tests {
+		gotBytes, err := tt.obj.MarshalBytes()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		var obj Array
+		gotRead, err := obj.UnmarshalBytes(gotBytes)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if gotRead != tt.expectedRead {
+			t.Errorf("invalid bytes read: got %v - want %v", gotRead, tt.expectedRead)
+			continue
+		}
+
+		if !reflect.DeepEqual(&obj, tt.obj) {
+			t.Errorf("invalid unmarshal object: got %+v - want %+v", &obj, tt.obj)
+			continue
+		}
+	}
+}
+
+func TestMapEncoding(t *testing.T) {
+	pairs := func(kv ...Object) map[HashKey]HashPair {
+		m := make(map[HashKey]HashPair)
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i].(Hashable).HashKey()] = HashPair{Key: kv[i], Value: kv[i+1]}
+		}
+		return m
+	}
+	tests := []struct {
+		obj          *Map
+		expectedRead int
+	}{
+		{&Map{Pairs: pairs()}, 9},
+		{&Map{Pairs: pairs(&String{Value: "a"}, &Integer{Value: 1}, &Integer{Value: 2}, True, True, NullValue)}, 42},
+		{&Map{Pairs: pairs(&String{Value: "nested"}, &Array{Elements: []Object{&Integer{Value: 1}}})}, 42},
+	}
+	for _, tt := range tests {
+		gotBytes, err := tt.obj.MarshalBytes()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		var obj Map
+		gotRead, err := obj.UnmarshalBytes(gotBytes)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if gotRead != tt.expectedRead {
+			t.Errorf("invalid bytes read: got %v - want %v", gotRead, tt.expectedRead)
+			continue
+		}
+
+		if !reflect.DeepEqual(&obj, tt.obj) {
+			t.Errorf("invalid unmarshal object: got %+v - want %+v", &obj, tt.obj)
+			continue
+		}
+
+		// maps encode the same however their pairs are ordered
+		again, err := obj.MarshalBytes()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(again, gotBytes) {
+			t.Errorf("invalid re-encoding: got %v - want %v", again, gotBytes)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		obj          Object
+		expectedRead int
+	}{
+		{NullValue, 1},
+		{True, 2},
+		{False, 2},
+		{&Integer{Value: 12}, 9},
+		{&Float{Value: 1.5}, 9},
+		{&String{Value: "hello"}, 14},
+		{&Array{Elements: []Object{True, NullValue}}, 12},
+		{&CompiledFunction{Instructions: []byte{}, Name: "f"}, 42},
+	}
+	for _, tt := range tests {
+		gotBytes, err := Encode(tt.obj)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		obj, gotRead, err := Decode(gotBytes)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if gotRead != tt.expectedRead {
+			t.Errorf("invalid bytes read: got %v - want %v", gotRead, tt.expectedRead)
+			continue
+		}
+
+		if !reflect.DeepEqual(obj, tt.obj) {
+			t.Errorf("invalid decoded object: got %+v - want %+v", obj, tt.obj)
+			continue
+		}
+	}
+
+	// booleans and null are compared by identity, so they decode to the same
+	// objects
+	for _, obj := range []Object{True, False, NullValue} {
+		data, _ := Encode(obj)
+		if got, _, _ := Decode(data); got != obj {
+			t.Errorf("invalid decoded object: got %p - want %p", got, obj)
+		}
+	}
+
+	if _, err := Encode(&Closure{}); err == nil || err.Error() != "cannot encode ClosureType" {
+		t.Errorf("invalid encode error: got %v - want cannot encode ClosureType", err)
+	}
+	if _, _, err := Decode([]byte{byte(ClosureType)}); err == nil || err.Error() != "cannot decode ClosureType" {
+		t.Errorf("invalid decode error: got %v - want cannot decode ClosureType", err)
+	}
+	for _, data := range [][]byte{nil, {byte(ArrayType), 0, 0, 0, 0, 0, 0, 0, 9}, {byte(BoolType), 2}} {
+		if _, _, err := Decode(data); err == nil {
+			t.Errorf("expected error decoding %v", data)
+		}
+	}
+}
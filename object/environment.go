@@ -2,7 +2,6 @@ package object
 
 import (
 	"io"
-	"os"
 )
 
 func NewEnvironment(opts ...EnvOption) *Environment {
@@ -22,9 +21,23 @@ func EncloseOuterOption(outer *Environment) EnvOption {
 	}
 }
 
+// WithOut sets the stdout of the environment's runtime, creating the runtime
+// if it doesn't have one
 func WithOut(out io.Writer) EnvOption {
 	return func(e *Environment) *Environment {
-		e.out = out
+		if e.rt == nil {
+			e.rt = &Runtime{}
+		}
+		e.rt.IO.Stdout = out
+		return e
+	}
+}
+
+// WithRuntime sets the runtime passed to builtins called in the environment
+// and any environment enclosed by it
+func WithRuntime(rt *Runtime) EnvOption {
+	return func(e *Environment) *Environment {
+		e.rt = rt
 		return e
 	}
 }
@@ -32,11 +45,17 @@ func WithOut(out io.Writer) EnvOption {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
-	out   io.Writer
+	rt    *Runtime
+	// unset holds the variables that are declared but were never given a
+	// value, see Declare
+	unset map[string]bool
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
 	o, ok := e.store[name]
+	if !ok && e.unset[name] {
+		return NullValue, true
+	}
 	if !ok && e.outer != nil {
 		o, ok = e.outer.Get(name)
 	}
@@ -52,6 +71,18 @@ func (e *Environment) Define(name string, val Object) {
 	e.store[name] = val
 }
 
+// Declare declares name as a variable of the environment without giving it a
+// value, if it isn't defined already. It is null until it is defined.
+func (e *Environment) Declare(name string) {
+	if _, ok := e.store[name]; ok {
+		return
+	}
+	if e.unset == nil {
+		e.unset = make(map[string]bool)
+	}
+	e.unset[name] = true
+}
+
 func (e *Environment) Assign(name string, val Object) {
 	if e.outer != nil {
 		if _, ok := e.outer.Get(name); ok {
@@ -62,12 +93,17 @@ func (e *Environment) Assign(name string, val Object) {
 	e.store[name] = val
 }
 
-func (e *Environment) Out() io.Writer {
-	if e.out != nil {
-		return e.out
-	}
-	if e.outer != nil && e.outer.Out() != nil {
-		return e.outer.Out()
+// Runtime returns the runtime of the environment, or the nearest environment
+// enclosing it. It returns nil if none of them have one.
+func (e *Environment) Runtime() *Runtime {
+	for ; e != nil; e = e.outer {
+		if e.rt != nil {
+			return e.rt
+		}
 	}
-	return os.Stdout
+	return nil
+}
+
+func (e *Environment) Out() io.Writer {
+	return e.Runtime().Stdout()
 }
@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
 )
 
 type FileState int
@@ -17,13 +17,14 @@ const (
 )
 
 type File struct {
-	Value   *os.File
+	Name    string
+	Value   fs.File
 	scanner *bufio.Scanner
 	State   FileState
 }
 
 func (f *File) Type() Type      { return FileType }
-func (f *File) Inspect() string { return fmt.Sprintf("File[%s]", f.Value.Name()) }
+func (f *File) Inspect() string { return fmt.Sprintf("File[%s]", f.Name) }
 
 func (f *File) Read() Object {
 	if f.State == UnknownFileState {
@@ -59,7 +60,8 @@ func (f *File) Readline() Object {
 }
 
 func (f *File) Write(obj Object) Object {
-	if f.State != WriteFileState {
+	w, ok := f.Value.(io.Writer)
+	if f.State != WriteFileState || !ok {
 		return &Error{Message: "file not in 'write' state"}
 	}
 	var data string
@@ -68,7 +70,7 @@ func (f *File) Write(obj Object) Object {
 	} else {
 		data = obj.Inspect()
 	}
-	n, err := fmt.Fprintln(f.Value, data)
+	n, err := fmt.Fprintln(w, data)
 	if err != nil {
 		return ErrorFromGo(err)
 	}
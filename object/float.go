@@ -14,6 +14,9 @@ func (f *Float) Type() Type      { return FloatType }
 func (f *Float) Inspect() string { return fmt.Sprintf("%f", f.Value) }
 
 func (f *Float) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 9 {
+		return 0, fmt.Errorf("invalid float: not enough data")
+	}
 	if t := Type(data[0]); t != f.Type() {
 		return 0, fmt.Errorf("invalid type: got %s - want %s", t, f.Type())
 	}
@@ -29,6 +32,20 @@ func (f *Float) MarshalBytes() ([]byte, error) {
 	return out, nil
 }
 
+func (f *Float) MarshalCompact(w *CompactWriter) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], math.Float64bits(f.Value))
+	w.Fixed(b[:])
+	return nil
+}
+
+func (f *Float) UnmarshalCompact(r *CompactReader) error {
+	if b := r.Fixed(8); b != nil {
+		f.Value = math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return r.Err()
+}
+
 func (f *Float) Bool() *Boolean {
 	if f.Value != 0 {
 		return True
@@ -26,7 +26,7 @@ func (f *Function) Inspect() string {
 	return sb.String()
 }
 
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(rt *Runtime, args ...Object) Object
 
 type Builtin struct {
 	Name string
@@ -40,12 +40,18 @@ type CompiledFunction struct {
 	Instructions code.Instructions
 	NumLocals    int
 	NumParams    int
+	// Name and Lines are debug info, used to build stack traces
+	Name  string
+	Lines code.LineTable
 }
 
 func (f *CompiledFunction) Type() Type      { return CompiledFunctionType }
 func (f *CompiledFunction) Inspect() string { return fmt.Sprintf("CompiledFunction[%p]", f) }
 
 func (f *CompiledFunction) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 25 {
+		return 0, fmt.Errorf("invalid compiled function: not enough data")
+	}
 	if t := Type(data[0]); t != f.Type() {
 		return 0, fmt.Errorf("invalid type: got %s - want %s", t, f.Type())
 	}
@@ -53,33 +59,96 @@ func (f *CompiledFunction) UnmarshalBytes(data []byte) (int, error) {
 	f.NumLocals = int(binary.BigEndian.Uint64(data[1:])) // this is probably less than uint64
 	f.NumParams = int(binary.BigEndian.Uint64(data[9:])) // this is probably less than uint64
 
-	lenIns, err := f.Instructions.UnmarshalBytes(data[17:])
+	nameLen := binary.BigEndian.Uint64(data[17:])
+	if nameLen > uint64(len(data)-25) {
+		return 0, fmt.Errorf("invalid compiled function: not enough data")
+	}
+	f.Name = string(data[25 : 25+nameLen])
+	ptr := 25 + int(nameLen)
+
+	lenIns, err := f.Instructions.UnmarshalBytes(data[ptr:])
 	if err != nil {
 		return 0, err
 	}
+	ptr += lenIns
 
-	return lenIns + 17, nil
+	lenLines, err := f.Lines.UnmarshalBytes(data[ptr:])
+	if err != nil {
+		return 0, err
+	}
+
+	return ptr + lenLines, nil
 }
 
 func (f *CompiledFunction) MarshalBytes() ([]byte, error) {
-	out := make([]byte, 17)
+	out := make([]byte, 25, 25+len(f.Name))
 
 	out[0] = byte(f.Type())
 	binary.BigEndian.PutUint64(out[1:], uint64(f.NumLocals))
 	binary.BigEndian.PutUint64(out[9:], uint64(f.NumParams))
+	binary.BigEndian.PutUint64(out[17:], uint64(len(f.Name)))
+	out = append(out, f.Name...)
 
 	ins, err := f.Instructions.MarshalBytes()
 	if err != nil {
 		return nil, err
 	}
+	out = append(out, ins...)
+
+	lines, err := f.Lines.MarshalBytes()
+	if err != nil {
+		return nil, err
+	}
 
-	return append(out, ins...), nil
+	return append(out, lines...), nil
+}
+
+func (f *CompiledFunction) MarshalCompact(w *CompactWriter) error {
+	w.Uvarint(uint64(f.NumLocals))
+	w.Uvarint(uint64(f.NumParams))
+	w.String(f.Name)
+	w.Bytes(f.Instructions)
+	w.Bytes(f.Lines.MarshalCompact())
+	return nil
+}
+
+func (f *CompiledFunction) UnmarshalCompact(r *CompactReader) error {
+	f.NumLocals = r.Int()
+	f.NumParams = r.Int()
+	f.Name = r.String()
+	f.Instructions = append(code.Instructions(nil), r.Bytes()...)
+	lines := r.Bytes()
+	if r.Err() != nil {
+		return fmt.Errorf("invalid compiled function: %w", r.Err())
+	}
+	n, err := f.Lines.UnmarshalCompact(lines)
+	if err != nil {
+		return err
+	}
+	if n != len(lines) {
+		return fmt.Errorf("invalid line table: %d unexpected bytes", len(lines)-n)
+	}
+	return nil
 }
 
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object
+	Free []*Upvalue
 }
 
 func (c *Closure) Type() Type      { return ClosureType }
 func (c *Closure) Inspect() string { return fmt.Sprintf("Closure[%p]", c) }
+
+// Upvalue is a variable captured by closures. While the function the
+// variable belongs to is running, the upvalue is open, and the variable is
+// the stack slot Slot, which the function and every closure capturing it
+// share. When the function returns, the upvalue is closed: the variable's
+// value moves into Value, where the closures go on sharing it.
+type Upvalue struct {
+	Slot   int
+	Closed bool
+	Value  Object
+}
+
+func (u *Upvalue) Type() Type      { return UpvalueType }
+func (u *Upvalue) Inspect() string { return fmt.Sprintf("Upvalue[%p]", u) }
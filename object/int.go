@@ -14,6 +14,9 @@ func (i *Integer) Type() Type      { return IntegerType }
 func (i *Integer) Inspect() string { return strconv.FormatInt(i.Value, 10) }
 
 func (i *Integer) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 9 {
+		return 0, fmt.Errorf("invalid integer: not enough data")
+	}
 	if t := Type(data[0]); t != i.Type() {
 		return 0, fmt.Errorf("invalid type: got %s - want %s", t, i.Type())
 	}
@@ -28,6 +31,16 @@ func (i *Integer) MarshalBytes() ([]byte, error) {
 	return out, nil
 }
 
+func (i *Integer) MarshalCompact(w *CompactWriter) error {
+	w.Varint(i.Value)
+	return nil
+}
+
+func (i *Integer) UnmarshalCompact(r *CompactReader) error {
+	i.Value = r.Varint()
+	return r.Err()
+}
+
 func (i *Integer) Bool() *Boolean {
 	if i.Value != 0 {
 		return True
@@ -79,6 +92,9 @@ func (i *Integer) Mult(obj Object) Object {
 func (i *Integer) Div(obj Object) Object {
 	switch o := obj.(type) {
 	case *Integer:
+		if o.Value == 0 {
+			return ErrDivisionByZero
+		}
 		return &Integer{Value: i.Value / o.Value}
 	case *Float:
 		return &Float{Value: float64(i.Value) / o.Value}
@@ -90,6 +106,9 @@ func (i *Integer) Div(obj Object) Object {
 func (i *Integer) Mod(obj Object) Object {
 	switch o := obj.(type) {
 	case *Integer:
+		if o.Value == 0 {
+			return ErrDivisionByZero
+		}
 		return &Integer{Value: i.Value % o.Value}
 	default:
 		return ErrUnsupportedType
@@ -1,7 +1,10 @@
 package object
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -28,15 +31,124 @@ func (m *Map) Inspect() string {
 	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
 }
 
+func (m *Map) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 9 {
+		return 0, fmt.Errorf("invalid map: not enough data")
+	}
+	if t := Type(data[0]); t != m.Type() {
+		return 0, fmt.Errorf("invalid type: got %s - want %s", t, m.Type())
+	}
+	numPairs := binary.BigEndian.Uint64(data[1:])
+	// every pair takes at least two bytes
+	if numPairs > uint64(len(data)-9)/2 {
+		return 0, fmt.Errorf("invalid map: not enough data")
+	}
+	ptr := 9
+	m.Pairs = make(map[HashKey]HashPair, numPairs)
+	for i := 0; i < int(numPairs); i++ {
+		key, n, err := Decode(data[ptr:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid map key: %w", err)
+		}
+		ptr += n
+		value, n, err := Decode(data[ptr:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid map value: %w", err)
+		}
+		ptr += n
+		hashable, ok := key.(Hashable)
+		if !ok {
+			return 0, fmt.Errorf("invalid map key: %s is not hashable", key.Type())
+		}
+		m.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+	}
+	return ptr, nil
+}
+
+func (m *Map) MarshalBytes() ([]byte, error) {
+	pairs := make([][]byte, 0, len(m.Pairs))
+	for _, pair := range m.Pairs {
+		key, err := Encode(pair.Key)
+		if err != nil {
+			return nil, fmt.Errorf("map key: %w", err)
+		}
+		value, err := Encode(pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("map value: %w", err)
+		}
+		pairs = append(pairs, append(key, value...))
+	}
+	// pairs are sorted so that the same map always encodes to the same bytes
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i], pairs[j]) < 0 })
+
+	out := make([]byte, 9)
+	out[0] = byte(m.Type())
+	binary.BigEndian.PutUint64(out[1:], uint64(len(pairs)))
+	for _, pair := range pairs {
+		out = append(out, pair...)
+	}
+	return out, nil
+}
+
+func (m *Map) MarshalCompact(w *CompactWriter) error {
+	type pair struct {
+		HashPair
+		sortKey []byte
+	}
+	pairs := make([]pair, 0, len(m.Pairs))
+	for _, p := range m.Pairs {
+		key, err := Encode(p.Key)
+		if err != nil {
+			return fmt.Errorf("map key: %w", err)
+		}
+		pairs = append(pairs, pair{HashPair: p, sortKey: key})
+	}
+	// keys are unique, so sorting by them is enough to always write the same
+	// map the same way
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].sortKey, pairs[j].sortKey) < 0 })
+
+	w.Uvarint(uint64(len(pairs)))
+	for _, p := range pairs {
+		if err := w.Object(p.Key); err != nil {
+			return fmt.Errorf("map key: %w", err)
+		}
+		if err := w.Object(p.Value); err != nil {
+			return fmt.Errorf("map value: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Map) UnmarshalCompact(r *CompactReader) error {
+	numPairs := r.Count()
+	m.Pairs = make(map[HashKey]HashPair, numPairs)
+	for i := 0; i < numPairs; i++ {
+		key := r.Object()
+		if r.Err() != nil {
+			return fmt.Errorf("invalid map key: %w", r.Err())
+		}
+		value := r.Object()
+		if r.Err() != nil {
+			return fmt.Errorf("invalid map value: %w", r.Err())
+		}
+		hashable, ok := key.(Hashable)
+		if !ok {
+			return fmt.Errorf("invalid map key: %s is not hashable", key.Type())
+		}
+		m.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+	}
+	return r.Err()
+}
+
 func (m *Map) Idx(obj Object) Object {
 	hashable, ok := obj.(Hashable)
 	if !ok {
-		return ErrUnsupportedType
+		return &Exception{Err: ErrUnsupportedType}
 	}
 	hk := hashable.HashKey()
 	p, ok := m.Pairs[hk]
 	if !ok {
-		return &Error{Message: "key not present"}
+		return &Exception{Err: &Error{Message: "key not present"}}
 	}
 	return p.Value
 }
@@ -0,0 +1,117 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// Meter counts the memory allocated by a running program against a limit. It
+// counts every allocation rather than the memory still in use, so a program
+// that keeps building and discarding values will eventually exhaust it.
+//
+// A nil *Meter has no limit.
+type Meter struct {
+	limit int
+	used  int
+	err   error
+}
+
+func NewMeter(limit int) *Meter {
+	return &Meter{limit: limit}
+}
+
+// Charge records an allocation of n bytes. It returns an error wrapping
+// ErrMemoryLimit if the allocation would exceed the limit, in which case the
+// allocation must not be made. Once the limit has been exceeded, every
+// following call fails.
+func (m *Meter) Charge(n int) error {
+	if m == nil {
+		return nil
+	}
+	if m.err != nil {
+		return m.err
+	}
+	if n < 0 || n > m.limit-m.used {
+		m.err = fmt.Errorf("%w: %d byte limit", ErrMemoryLimit, m.limit)
+		return m.err
+	}
+	m.used += n
+	return nil
+}
+
+// Used returns the number of bytes charged so far
+func (m *Meter) Used() int {
+	if m == nil {
+		return 0
+	}
+	return m.used
+}
+
+// Err returns the error from the first charge that exceeded the limit, if any
+func (m *Meter) Err() error {
+	if m == nil {
+		return nil
+	}
+	return m.err
+}
+
+// Approximate sizes of the objects that can grow large, used when charging a
+// Meter. They don't need to be exact, only proportional to the real cost.
+func StringSize(n int) int { return 16 + n }
+func ArraySize(n int) int  { return 24 + mulSize(n, 16) }
+func MapSize(n int) int    { return 48 + mulSize(n, 64) }
+
+// RepeatSize returns the size of a string of n bytes repeated count times
+func RepeatSize(n, count int) int {
+	return StringSize(mulSize(n, count))
+}
+
+// maxSize is larger than any limit a Meter could reasonably have, and small
+// enough that adding the fixed size of an object to it won't overflow.
+const maxSize = math.MaxInt / 2
+
+// mulSize multiplies a and b, saturating at maxSize rather than overflowing
+func mulSize(a, b int) int {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > maxSize/b {
+		return maxSize
+	}
+	return a * b
+}
+
+// SizeOf returns the approximate size of obj, not including the elements of
+// arrays and maps, which are charged when they are created.
+func SizeOf(obj Object) int {
+	switch obj := obj.(type) {
+	case *String:
+		return StringSize(len(obj.Value))
+	case *Array:
+		return ArraySize(len(obj.Elements))
+	case *Map:
+		return MapSize(len(obj.Pairs))
+	default:
+		return 0
+	}
+}
+
+// SetSize returns the approximate memory that setting key in obj allocates.
+// Only adding a new key to a map grows it; replacing an element doesn't.
+func SetSize(obj, key Object) int {
+	m, ok := obj.(*Map)
+	if !ok {
+		return 0
+	}
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return 0
+	}
+	if _, ok := m.Pairs[hashable.HashKey()]; ok {
+		return 0
+	}
+	return MapSize(len(m.Pairs)+1) - MapSize(len(m.Pairs))
+}
@@ -0,0 +1,56 @@
+package object
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMeter(t *testing.T) {
+	m := NewMeter(100)
+	if err := m.Charge(60); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := m.Charge(40); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := m.Used(); got != 100 {
+		t.Errorf("invalid used: got %d - want 100", got)
+	}
+	if err := m.Charge(1); !errors.Is(err, ErrMemoryLimit) {
+		t.Fatalf("invalid error: got %v - want %s", err, ErrMemoryLimit)
+	}
+	if err := m.Charge(0); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("invalid error after limit: got %v - want %s", err, ErrMemoryLimit)
+	}
+	if !errors.Is(m.Err(), ErrMemoryLimit) {
+		t.Errorf("invalid Err: got %v - want %s", m.Err(), ErrMemoryLimit)
+	}
+
+	var unlimited *Meter
+	if err := unlimited.Charge(math.MaxInt); err != nil {
+		t.Errorf("nil meter returned error: %s", err)
+	}
+}
+
+func TestSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{name: "string", got: StringSize(10), want: 26},
+		{name: "array", got: ArraySize(2), want: 56},
+		{name: "map", got: MapSize(1), want: 112},
+		{name: "repeat", got: RepeatSize(3, 4), want: 28},
+		{name: "repeat overflow", got: RepeatSize(math.MaxInt, math.MaxInt), want: StringSize(maxSize)},
+		{name: "SizeOf", got: SizeOf(&String{Value: "abc"}), want: 19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("invalid size: got %d - want %d", tt.got, tt.want)
+			}
+		})
+	}
+}
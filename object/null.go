@@ -1,5 +1,11 @@
 package object
 
+import "fmt"
+
+// NullValue is the null value. Null is compared by identity, so every null
+// should be NullValue.
+var NullValue = &Null{}
+
 type Null struct{}
 
 func (n *Null) Type() Type      { return NullType }
@@ -8,3 +14,21 @@ func (n *Null) Inspect() string { return "null" }
 func (n *Null) Bool() *Boolean {
 	return False
 }
+
+func (n *Null) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 1 {
+		return 0, fmt.Errorf("invalid null: not enough data")
+	}
+	if t := Type(data[0]); t != n.Type() {
+		return 0, fmt.Errorf("invalid type: got %s - want %s", t, n.Type())
+	}
+	return 1, nil
+}
+
+func (n *Null) MarshalBytes() ([]byte, error) {
+	return []byte{byte(n.Type())}, nil
+}
+
+func (n *Null) MarshalCompact(w *CompactWriter) error { return nil }
+
+func (n *Null) UnmarshalCompact(r *CompactReader) error { return nil }
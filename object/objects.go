@@ -1,6 +1,11 @@
 package object
 
-var ErrUnsupportedType = &Error{Message: "unsupported type for operation"}
+import "fmt"
+
+var (
+	ErrUnsupportedType = &Error{Message: "unsupported type for operation"}
+	ErrDivisionByZero  = &Error{Message: "division by zero"}
+)
 
 type Encodable interface {
 	MarshalBytes() ([]byte, error)
@@ -53,6 +58,9 @@ type Equal interface {
 	NEQ(Object) Object
 }
 
+// Indexer is implemented by objects that can be indexed. Since the element
+// found may itself be an error value, Idx raises an error by returning it
+// wrapped in an Exception.
 type Indexer interface {
 	Idx(Object) Object
 }
@@ -61,6 +69,17 @@ type Booler interface {
 	Bool() *Boolean
 }
 
+// Truthy returns the truth of obj as a condition, True or False, or an error
+// if obj doesn't have one. The VM and the evaluator both decide conditions by
+// it, so that they agree on which values are true.
+func Truthy(obj Object) Object {
+	b, ok := obj.(Booler)
+	if !ok {
+		return &Error{Message: fmt.Sprintf("cannot implicitly convert %s to bool", obj.Type())}
+	}
+	return b.Bool()
+}
+
 type Negater interface {
 	Negative() Object
 }
@@ -102,14 +121,70 @@ type Return struct {
 func (r *Return) Type() Type      { return ReturnType }
 func (r *Return) Inspect() string { return r.Value.Inspect() }
 
+// Exception carries an error being raised up through the evaluator, until it
+// reaches a try statement or the top of the program. Operations that can
+// return error values, like Idx, also use it to tell raising an error apart
+// from returning one.
+type Exception struct {
+	Err *Error
+}
+
+func (e *Exception) Type() Type      { return ExceptionType }
+func (e *Exception) Inspect() string { return e.Err.Inspect() }
+
 func ErrorFromGo(err error) *Error {
 	return &Error{Message: err.Error()}
 }
 
+// Error is an error value. Builtins return one to raise it, and scripts
+// create them with error() and catch them with try.
 type Error struct {
 	Message string
+	// Kind optionally classifies the error, so scripts can tell errors apart
+	// without inspecting their message
+	Kind string
+	// Cause is the error that led to this one, if any
+	Cause *Error
 }
 
 func (e *Error) Type() Type      { return ErrorType }
-func (e *Error) Inspect() string { return e.Message }
-func (e *Error) Error() string   { return e.Message }
+func (e *Error) Inspect() string { return e.Error() }
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Kind != "" {
+		msg = e.Kind + ": " + msg
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	if e.Cause == nil {
+		return nil
+	}
+	return e.Cause
+}
+
+// Idx gives scripts access to the parts of the error, as e["message"],
+// e["kind"] and e["cause"]
+func (e *Error) Idx(obj Object) Object {
+	key, ok := obj.(*String)
+	if !ok {
+		return &Exception{Err: ErrUnsupportedType}
+	}
+	switch key.Value {
+	case "message":
+		return &String{Value: e.Message}
+	case "kind":
+		return &String{Value: e.Kind}
+	case "cause":
+		if e.Cause == nil {
+			return NullValue
+		}
+		return e.Cause
+	default:
+		return &Exception{Err: &Error{Message: fmt.Sprintf("invalid error field: %s", key.Value)}}
+	}
+}
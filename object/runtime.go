@@ -0,0 +1,96 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jimmykodes/joker/sandbox"
+)
+
+// Runtime is the state of the running program that builtins have access to.
+// A nil *Runtime is valid, and places no limits on the builtin.
+type Runtime struct {
+	// Meter is charged for memory the builtin allocates
+	Meter *Meter
+	// Perms are the capabilities the builtin may use on the host
+	Perms *sandbox.Permissions
+	// IO holds the streams builtins read from and write to
+	IO IO
+
+	// stdin buffers IO.Stdin, so that no input is lost between reads
+	stdin *bufio.Reader
+}
+
+// IO holds the standard streams of a program. Streams that aren't set are
+// the process's own os.Stdin, os.Stdout and os.Stderr.
+type IO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Charge charges n bytes to the runtime's meter
+func (rt *Runtime) Charge(n int) error {
+	if rt == nil {
+		return nil
+	}
+	return rt.Meter.Charge(n)
+}
+
+// Permissions returns the capabilities granted to the program. The result is
+// nil, granting everything, if rt is nil.
+func (rt *Runtime) Permissions() *sandbox.Permissions {
+	if rt == nil {
+		return nil
+	}
+	return rt.Perms
+}
+
+func (rt *Runtime) Stdout() io.Writer {
+	if rt == nil || rt.IO.Stdout == nil {
+		return os.Stdout
+	}
+	return rt.IO.Stdout
+}
+
+func (rt *Runtime) Stderr() io.Writer {
+	if rt == nil || rt.IO.Stderr == nil {
+		return os.Stderr
+	}
+	return rt.IO.Stderr
+}
+
+var (
+	osStdin     *bufio.Reader
+	osStdinOnce sync.Once
+)
+
+// ReadLine reads the next line from stdin, without its line ending. It
+// returns io.EOF once stdin has no more input.
+func (rt *Runtime) ReadLine() (string, error) {
+	var r *bufio.Reader
+	if rt == nil || rt.IO.Stdin == nil {
+		// os.Stdin is shared by every runtime, so they must share its buffer too
+		osStdinOnce.Do(func() { osStdin = bufio.NewReader(os.Stdin) })
+		r = osStdin
+	} else {
+		if rt.stdin == nil {
+			rt.stdin = bufio.NewReader(rt.IO.Stdin)
+		}
+		r = rt.stdin
+	}
+
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		// the last line doesn't need to end in a newline
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
@@ -0,0 +1,36 @@
+package object
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRuntime_ReadLine(t *testing.T) {
+	rt := &Runtime{IO: IO{Stdin: strings.NewReader("one\ntwo\r\n\nlast")}}
+	for _, want := range []string{"one", "two", "", "last"} {
+		got, err := rt.ReadLine()
+		if err != nil {
+			t.Fatalf("read error: %s", err)
+		}
+		if got != want {
+			t.Errorf("invalid line: got %q - want %q", got, want)
+		}
+	}
+	if _, err := rt.ReadLine(); err != io.EOF {
+		t.Errorf("invalid error: got %v - want %s", err, io.EOF)
+	}
+}
+
+func TestEnvironment_Runtime(t *testing.T) {
+	var out bytes.Buffer
+	outer := NewEnvironment(WithOut(&out))
+	inner := NewEnvironment(EncloseOuterOption(outer))
+	if inner.Runtime() != outer.Runtime() {
+		t.Errorf("enclosed environment does not share runtime")
+	}
+	if inner.Out() != &out {
+		t.Errorf("invalid out: got %v - want %v", inner.Out(), &out)
+	}
+}
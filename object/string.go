@@ -16,10 +16,16 @@ func (s *String) Inspect() string { return `"` + s.Value + `"` }
 func (s *String) String() string  { return s.Value }
 
 func (s *String) UnmarshalBytes(data []byte) (int, error) {
+	if len(data) < 9 {
+		return 0, fmt.Errorf("invalid string: not enough data")
+	}
 	if t := Type(data[0]); t != s.Type() {
 		return 0, fmt.Errorf("invalid type: got %s - want %s", t, s.Type())
 	}
 	strLen := binary.BigEndian.Uint64(data[1:])
+	if strLen > uint64(len(data)-9) {
+		return 0, fmt.Errorf("invalid string: not enough data")
+	}
 
 	s.Value = string(data[9 : strLen+9])
 
@@ -33,6 +39,16 @@ func (s *String) MarshalBytes() ([]byte, error) {
 	return append(out, []byte(s.Value)...), nil
 }
 
+func (s *String) MarshalCompact(w *CompactWriter) error {
+	w.String(s.Value)
+	return nil
+}
+
+func (s *String) UnmarshalCompact(r *CompactReader) error {
+	s.Value = r.String()
+	return r.Err()
+}
+
 func (s *String) Bool() *Boolean {
 	if s.Value != "" {
 		return True
@@ -63,6 +79,9 @@ func (s *String) Add(obj Object) Object {
 func (s *String) Mult(obj Object) Object {
 	switch o := obj.(type) {
 	case *Integer:
+		if o.Value < 0 {
+			return &Error{Message: fmt.Sprintf("negative repeat count: %d", o.Value)}
+		}
 		return &String{Value: strings.Repeat(s.Value, int(o.Value))}
 	default:
 		return ErrUnsupportedType
@@ -132,10 +151,10 @@ func (s *String) NEQ(obj Object) Object {
 func (s *String) Idx(obj Object) Object {
 	o, ok := obj.(*Integer)
 	if !ok {
-		return ErrUnsupportedType
+		return &Exception{Err: ErrUnsupportedType}
 	}
-	if o.Value >= int64(len(s.Value)) {
-		return &Error{Message: fmt.Sprintf("index out of range [%d] with length %d", o.Value, len(s.Value))}
+	if o.Value < 0 || o.Value >= int64(len(s.Value)) {
+		return &Exception{Err: &Error{Message: fmt.Sprintf("index out of range [%d] with length %d", o.Value, len(s.Value))}}
 	}
 	return &String{Value: string(s.Value[int(o.Value)])}
 }
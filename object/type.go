@@ -20,4 +20,6 @@ const (
 	BreakType
 	ErrorType
 	FileType
+	ExceptionType
+	UpvalueType
 )
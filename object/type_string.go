@@ -24,11 +24,13 @@ func _() {
 	_ = x[BreakType-13]
 	_ = x[ErrorType-14]
 	_ = x[FileType-15]
+	_ = x[ExceptionType-16]
+	_ = x[UpvalueType-17]
 }
 
-const _Type_name = "NullTypeIntegerTypeFloatTypeBoolTypeStringTypeFunctionTypeCompiledFunctionTypeClosureTypeBuiltinTypeArrayTypeMapTypeReturnTypeContinueTypeBreakTypeErrorTypeFileType"
+const _Type_name = "NullTypeIntegerTypeFloatTypeBoolTypeStringTypeFunctionTypeCompiledFunctionTypeClosureTypeBuiltinTypeArrayTypeMapTypeReturnTypeContinueTypeBreakTypeErrorTypeFileTypeExceptionTypeUpvalueType"
 
-var _Type_index = [...]uint8{0, 8, 19, 28, 36, 46, 58, 78, 89, 100, 109, 116, 126, 138, 147, 156, 164}
+var _Type_index = [...]uint8{0, 8, 19, 28, 36, 46, 58, 78, 89, 100, 109, 116, 126, 138, 147, 156, 164, 177, 188}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
@@ -3,21 +3,43 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/jimmykodes/joker/token"
 )
 
 var ErrParserError = errors.New("parser error")
 
-func newParseError(line int, message string, args ...any) error {
-	return fmt.Errorf("%w at line %d: %s", ErrParserError, line, fmt.Sprintf(message, args...))
+// ParseError describes a single problem found while parsing.
+type ParseError struct {
+	// Pos is where in the source the problem was found
+	Pos token.Position
+	// Token is the token the parser was looking at when the problem was found
+	Token token.Token
+	// Expected is the set of tokens that would have been valid instead of
+	// Token. It is empty when the problem isn't a simple unexpected token.
+	Expected []token.Token
+	Msg      string
 }
 
-func invalidTokenError(line int, expected, got token.Token) error {
-	return newParseError(line, "invalid token. expected: %s - got: %s", expected, got)
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at %s: %s", ErrParserError, e.Pos, e.Msg)
 }
 
-type ParseError struct {
-	error
-	Token token.Token
+func (e *ParseError) Unwrap() error {
+	return ErrParserError
+}
+
+func newParseError(pos token.Position, tok token.Token, message string, args ...any) *ParseError {
+	return &ParseError{Pos: pos, Token: tok, Msg: fmt.Sprintf(message, args...)}
+}
+
+func invalidTokenError(pos token.Position, got token.Token, expected ...token.Token) *ParseError {
+	want := make([]string, len(expected))
+	for i, t := range expected {
+		want[i] = t.String()
+	}
+	err := newParseError(pos, got, "invalid token. expected: %s - got: %s", strings.Join(want, " or "), got)
+	err.Expected = expected
+	return err
 }
@@ -11,24 +11,30 @@ type (
 )
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{Token: p.curToken, Value: p.curLit}
+	return &ast.Identifier{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit}
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	exp := &ast.PrefixExpression{
-		Token:    p.curToken,
-		Operator: p.curLit,
+		Token: p.curToken,
+		// use the token rather than the literal, so aliases like `not` resolve
+		// to their operator
+		Operator: p.curToken.String(),
 	}
+	start := p.curPos
 	p.nextToken()
 	exp.Right = p.parseExpression(token.PrefixPrecedence)
+	exp.Span = p.span(start)
 	return exp
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	// the group itself has no node, so the parens are not part of the
+	// expression's span
 	p.nextToken()
 	exp := p.parseExpression(token.LowestPrecedence)
 	if !p.expect(p.peekTokenIs(token.RParen)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.RParen, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.RParen))
 		return nil
 	}
 	return exp
@@ -40,9 +46,11 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 		Operator: p.curLit,
 	}
+	start := spanStart(left, p.curPos)
 	pre := p.curToken.Precedence()
 	p.nextToken()
 	exp.Right = p.parseExpression(pre)
+	exp.Span = p.span(start)
 	return exp
 }
 
@@ -59,7 +67,7 @@ func (p *Parser) parseExpressionList(end token.Token) []ast.Expression {
 		list = append(list, p.parseExpression(token.LowestPrecedence))
 	}
 	if !p.expect(p.peekTokenIs(end)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, end, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, end))
 		return nil
 	}
 	return list
@@ -67,31 +75,52 @@ func (p *Parser) parseExpressionList(end token.Token) []ast.Expression {
 
 func (p *Parser) parseIfExpression() ast.Expression {
 	exp := &ast.IfExpression{Token: p.curToken}
+	start := p.curPos
 	p.nextToken()
 	exp.Condition = p.parseExpression(token.LowestPrecedence)
 	if !p.expect(p.peekTokenIs(token.LBrace)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.LBrace, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.LBrace))
 		return nil
 	}
 	exp.Consequence = p.parseBlockStatement()
 
 	if !p.expect(p.peekTokenIs(token.Else)) {
 		// no else, just continue
+		exp.Span = p.span(start)
+		return exp
+	}
+
+	if p.expect(p.peekTokenIs(token.If)) {
+		// else if: the chained if becomes the only statement of the alternative block.
+		// the block keeps the if token so it can be told apart from a literal `else { if ... }`
+		alt := p.parseIfExpression()
+		if alt == nil {
+			return nil
+		}
+		span := ast.Span{Start: alt.Pos(), Stop: alt.End()}
+		exp.Alternative = &ast.BlockStatement{
+			Token:      token.If,
+			Span:       span,
+			Statements: []ast.Statement{&ast.ExpressionStatement{Token: token.If, Span: span, Expression: alt}},
+		}
+		exp.Span = p.span(start)
 		return exp
 	}
 
 	if !p.expect(p.peekTokenIs(token.LBrace)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.LBrace, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.LBrace))
 		return nil
 	}
 
 	exp.Alternative = p.parseBlockStatement()
 
+	exp.Span = p.span(start)
 	return exp
 }
 
 func (p *Parser) parseForExpression() ast.Expression {
 	exp := &ast.ForExpression{Token: p.curToken}
+	start := p.curPos
 	p.nextToken()
 
 	exp.Init = p.parseStatement()
@@ -104,25 +133,29 @@ func (p *Parser) parseForExpression() ast.Expression {
 	p.nextToken()
 
 	exp.Body = p.parseBlockStatement()
+	exp.Span = p.span(start)
 	return exp
 }
 
 func (p *Parser) parseWhileExpression() ast.Expression {
 	exp := &ast.WhileExpression{Token: p.curToken}
+	start := p.curPos
 	p.nextToken()
 	exp.Condition = p.parseExpression(token.LowestPrecedence)
 	if !p.expect(p.peekTokenIs(token.LBrace)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.LBrace, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.LBrace))
 		return nil
 	}
 	exp.Body = p.parseBlockStatement()
+	exp.Span = p.span(start)
 	return exp
 }
 
 func (p *Parser) parseFuncExpression() ast.Expression {
 	exp := &ast.FunctionLiteral{Token: p.curToken}
+	start := p.curPos
 	if !p.expect(p.peekTokenIs(token.LParen)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.LParen, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.LParen))
 		return nil
 	}
 
@@ -131,19 +164,23 @@ func (p *Parser) parseFuncExpression() ast.Expression {
 	for i, param := range params {
 		cast, ok := param.(*ast.Identifier)
 		if !ok {
-			p.errors = append(p.errors, newParseError(p.curLine, "invalid type for func param. got %T - want %T", param, &ast.Identifier{}))
+			if param != nil {
+				// a nil param has already been reported by parseExpression
+				p.error(newParseError(param.Pos(), p.curToken, "invalid type for func param. got %T - want %T", param, &ast.Identifier{}))
+			}
 			return nil
 		}
 		exp.Parameters[i] = cast
 	}
 
 	if !p.expect(p.peekTokenIs(token.LBrace)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.LBrace, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.LBrace))
 		return nil
 	}
 
 	exp.Body = p.parseBlockStatement()
 
+	exp.Span = p.span(start)
 	return exp
 }
 
@@ -152,17 +189,43 @@ func (p *Parser) parseCallExpression(f ast.Expression) ast.Expression {
 		Token:    p.curToken,
 		Function: f,
 	}
+	start := spanStart(f, p.curPos)
 	exp.Arguments = p.parseExpressionList(token.RParen)
+	exp.Span = p.span(start)
 	return exp
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	e := &ast.IndexExpression{Token: p.curToken, Left: left}
+	start := spanStart(left, p.curPos)
 	p.nextToken()
 	e.Index = p.parseExpression(token.LowestPrecedence)
 	if !p.expect(p.peekTokenIs(token.RBrack)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.RBrack, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.RBrack))
 		return nil
 	}
+	e.Span = p.span(start)
 	return e
 }
+
+func (p *Parser) parseSelectorExpression(left ast.Expression) ast.Expression {
+	e := &ast.SelectorExpression{Token: p.curToken, Left: left}
+	start := spanStart(left, p.curPos)
+	if !p.expect(p.peekTokenIs(token.Ident)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.Ident))
+		return nil
+	}
+	e.Name = &ast.Identifier{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit}
+	e.Span = p.span(start)
+	return e
+}
+
+// spanStart returns where an expression that continues from left begins. left
+// can be nil if it failed to parse, in which case the position of the current
+// token is used instead.
+func spanStart(left ast.Expression, cur token.Position) token.Position {
+	if left == nil {
+		return cur
+	}
+	return left.Pos()
+}
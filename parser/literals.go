@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"strconv"
 
 	"github.com/jimmykodes/joker/ast"
@@ -10,6 +11,7 @@ import (
 func (p *Parser) parseCommentLiteral() ast.Expression {
 	return &ast.CommentLiteral{
 		Token: p.curToken,
+		Span:  p.span(p.curPos),
 		Value: p.curLit,
 	}
 }
@@ -17,36 +19,34 @@ func (p *Parser) parseCommentLiteral() ast.Expression {
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{
 		Token: p.curToken,
+		Span:  p.span(p.curPos),
 		Value: p.curLit,
 	}
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	a := &ast.ArrayLiteral{Token: p.curToken}
+	start := p.curPos
 	a.Elements = p.parseExpressionList(token.RBrack)
+	a.Span = p.span(start)
 	return a
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	h := &ast.MapLiteral{Token: p.curToken, Pairs: make(map[ast.Expression]ast.Expression)}
+	start := p.curPos
 	for !p.peekTokenIs(token.RBrace) {
 		p.nextToken()
 		key := p.parseExpression(token.LowestPrecedence)
 		if !p.expect(p.peekTokenIs(token.Colon)) {
-			p.errors = append(p.errors, invalidTokenError(p.curLine, token.Colon, p.peekToken))
+			p.error(invalidTokenError(p.peekPos, p.peekToken, token.Colon))
 			return nil
 		}
 		p.nextToken()
 		val := p.parseExpression(token.LowestPrecedence)
 		h.Pairs[key] = val
 		if !p.peekTokenIs(token.RBrace, token.Comma) {
-			p.errors = append(p.errors, newParseError(
-				p.curLine,
-				"invalid token. expected: %s or %s - got: %s",
-				token.Comma,
-				token.RBrace,
-				p.peekToken,
-			))
+			p.error(invalidTokenError(p.peekPos, p.peekToken, token.Comma, token.RBrace))
 			return nil
 		}
 		if p.peekTokenIs(token.Comma) {
@@ -54,30 +54,32 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		}
 	}
 	p.nextToken()
+	h.Span = p.span(start)
 	return h
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	i, err := strconv.ParseInt(p.curLit, 10, 64)
 	if err != nil {
-		p.errors = append(p.errors, err)
+		p.error(newParseError(p.curPos, p.curToken, "invalid integer literal %s: %s", p.curLit, errors.Unwrap(err)))
 		return nil
 	}
-	return &ast.IntegerLiteral{Token: p.curToken, Value: i}
+	return &ast.IntegerLiteral{Token: p.curToken, Span: p.span(p.curPos), Value: i}
 }
 
 func (p *Parser) parseFloatLiteral() ast.Expression {
 	i, err := strconv.ParseFloat(p.curLit, 64)
 	if err != nil {
-		p.errors = append(p.errors, err)
+		p.error(newParseError(p.curPos, p.curToken, "invalid float literal %s: %s", p.curLit, errors.Unwrap(err)))
 		return nil
 	}
-	return &ast.FloatLiteral{Token: p.curToken, Value: i}
+	return &ast.FloatLiteral{Token: p.curToken, Span: p.span(p.curPos), Value: i}
 }
 
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.BooleanLiteral{
 		Token: p.curToken,
+		Span:  p.span(p.curPos),
 		Value: p.curTokenIs(token.True),
 	}
 }
@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"sort"
+
 	"github.com/jimmykodes/joker/ast"
 	"github.com/jimmykodes/joker/lexer"
 	"github.com/jimmykodes/joker/token"
@@ -8,14 +10,22 @@ import (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []error
+	errors []*ParseError
+	// panicking is set after an error is reported, and cleared once the parser
+	// has synchronized to the start of the next statement. Errors reported in
+	// between are almost always caused by the first one, so they are dropped.
+	panicking bool
+	// depth is the number of unclosed braces before the current token
+	depth int
 
 	curToken token.Token
-	curLine  int
+	curPos   token.Position
+	curEnd   token.Position
 	curLit   string
 
 	peekToken token.Token
-	peekLine  int
+	peekPos   token.Position
+	peekEnd   token.Position
 	peekLit   string
 
 	prefixParseFuncs map[token.Token]prefixParseFunc
@@ -66,9 +76,14 @@ func New(l *lexer.Lexer) *Parser {
 		token.EQ:  p.parseInfixExpression,
 		token.NEQ: p.parseInfixExpression,
 
+		// logical
+		token.AND: p.parseInfixExpression,
+		token.OR:  p.parseInfixExpression,
+
 		// calling
 		token.LParen: p.parseCallExpression,
 		token.LBrack: p.parseIndexExpression,
+		token.Dot:    p.parseSelectorExpression,
 	}
 
 	return p
@@ -79,7 +94,11 @@ func (p *Parser) ParseProgram() *ast.Program {
 		Statements: make([]ast.Statement, 0),
 	}
 	for p.curToken != token.EOF {
-		if stmt := p.parseStatement(); stmt != nil {
+		depth := p.depth
+		stmt := p.parseStatement()
+		if p.panicking {
+			p.synchronize(depth)
+		} else if stmt != nil {
 			prog.Statements = append(prog.Statements, stmt)
 		}
 		p.nextToken()
@@ -87,8 +106,64 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return prog
 }
 
+// Errors returns the errors found while parsing, ordered by their position in
+// the source.
 func (p *Parser) Errors() []error {
-	return p.errors
+	errs := make([]*ParseError, len(p.errors))
+	copy(errs, p.errors)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Pos.Offset < errs[j].Pos.Offset })
+
+	out := make([]error, len(errs))
+	for i, err := range errs {
+		out[i] = err
+	}
+	return out
+}
+
+func (p *Parser) error(err *ParseError) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	for _, e := range p.errors {
+		if e.Pos == err.Pos && e.Msg == err.Msg {
+			return
+		}
+	}
+	p.errors = append(p.errors, err)
+}
+
+// synchronize skips the rest of a statement that contained an error, leaving
+// the parser on its last token: a semicolon, the closing brace of a block the
+// statement opened, or the token before a keyword that starts a new statement.
+// depth is the brace depth the statement started at. synchronize reports
+// whether it instead stopped on the closing brace of the block enclosing the
+// statement.
+func (p *Parser) synchronize(depth int) bool {
+	defer func() { p.panicking = false }()
+	for !p.curTokenIs(token.EOF) {
+		after := p.depth + braceDelta(p.curToken)
+		switch {
+		case after < depth:
+			return true
+		case p.curTokenIs(token.SemiCol) && after == depth:
+			return false
+		case p.curTokenIs(token.RBrace) && after == depth && !p.peekTokenIs(token.SemiCol):
+			return false
+		case after == depth && p.peekTokenIs(statementEnds[0], statementEnds[1:]...):
+			return false
+		}
+		p.nextToken()
+	}
+	return false
+}
+
+// statementEnds are the tokens that, when they follow a broken statement, mark
+// where the parser can pick back up.
+var statementEnds = []token.Token{
+	token.RBrace, token.EOF,
+	token.Let, token.Func, token.Return, token.If, token.For, token.While, token.Break, token.Continue,
+	token.Try, token.Raise, token.Import,
 }
 
 func (p *Parser) parseStatement() ast.Statement {
@@ -101,6 +176,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseContinueStatement()
 	case token.Break:
 		return p.parseBreakStatement()
+	case token.Try:
+		return p.parseTryStatement()
+	case token.Raise:
+		return p.parseRaiseStatement()
+	case token.Import:
+		return p.parseImportStatement()
 	case token.Ident:
 		if p.peekTokenIs(token.Assign) {
 			return p.parseReassignStatement()
@@ -117,9 +198,14 @@ func (p *Parser) parseStatement() ast.Statement {
 }
 
 func (p *Parser) parseExpression(pre token.Precedence) ast.Expression {
+	if p.curToken == token.Illegal {
+		// the lexer puts the reason the token is illegal in the literal
+		p.error(newParseError(p.curPos, p.curToken, "%s", p.curLit))
+		return nil
+	}
 	prefix := p.prefixParseFuncs[p.curToken]
 	if prefix == nil {
-		p.errors = append(p.errors, newParseError(p.curLine, "no prefix func found for token type: %s", p.curToken))
+		p.error(newParseError(p.curPos, p.curToken, "expected an expression - got: %s", p.curToken))
 		return nil
 	}
 
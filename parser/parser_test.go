@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/jimmykodes/joker/ast"
 	"github.com/jimmykodes/joker/lexer"
+	"github.com/jimmykodes/joker/token"
 )
 
 func TestParser_ParseProgram(t *testing.T) {
@@ -49,6 +52,12 @@ func TestParser_ParseProgram(t *testing.T) {
 			numStatements: 1,
 			programText:   "(-5)\n",
 		},
+		{
+			name:          "selector",
+			input:         "-math.consts[0] * str.pi;",
+			numStatements: 1,
+			programText:   "((-(math.consts[0])) * str.pi)\n",
+		},
 		{
 			name:          "expression statement - int",
 			input:         "5",
@@ -79,6 +88,18 @@ func TestParser_ParseProgram(t *testing.T) {
 			numStatements: 1,
 			programText:   "((5 < 3) == false)\n",
 		},
+		{
+			name:          "logical precedence",
+			input:         "a || b && c == d",
+			numStatements: 1,
+			programText:   "(a || (b && (c == d)))\n",
+		},
+		{
+			name:          "not alias",
+			input:         "not a && !b",
+			numStatements: 1,
+			programText:   "((!a) && (!b))\n",
+		},
 		{
 			name:          "grouped",
 			input:         "(5 + 8) * 23",
@@ -97,6 +118,18 @@ func TestParser_ParseProgram(t *testing.T) {
 			numStatements: 1,
 			programText:   "if (x == y) {\n\treturn 12;\n} else {\n\treturn 11;\n}\n",
 		},
+		{
+			name:          "else if",
+			input:         "if x == y { return 12 } else if x > y { return 11 } else { return 10 }",
+			numStatements: 1,
+			programText:   "if (x == y) {\n\treturn 12;\n} else if (x > y) {\n\treturn 11;\n} else {\n\treturn 10;\n}\n",
+		},
+		{
+			name:          "else if without else",
+			input:         "if a { 1 } else if b { 2 } else if c { 3 }",
+			numStatements: 1,
+			programText:   "if a {\n\t1\n} else if b {\n\t2\n} else if c {\n\t3\n}\n",
+		},
 		{
 			name:          "func literal",
 			input:         "fn (a, b, c) { return a + b }",
@@ -115,6 +148,18 @@ func TestParser_ParseProgram(t *testing.T) {
 			numStatements: 1,
 			programText:   "add(2, 9);\n",
 		},
+		{
+			name:          "index assignment",
+			input:         "x[0] = 12;",
+			numStatements: 1,
+			programText:   "(x[0]) = 12\n",
+		},
+		{
+			name:          "nested index assignment",
+			input:         `m["a"][0] = 1 + 2;`,
+			numStatements: 1,
+			programText:   "((m[\"a\"])[0]) = (1 + 2)\n",
+		},
 		{
 			name: "for",
 			input: `for i := 0; i < 10; i = i + 1; {
@@ -123,6 +168,30 @@ func TestParser_ParseProgram(t *testing.T) {
 			numStatements: 1,
 			programText:   "for i := 0; (i < 10); i = (i + 1) {\n\tprint(i);\n}\n",
 		},
+		{
+			name:          "try catch",
+			input:         `try { f(); } catch e { print(e); }`,
+			numStatements: 1,
+			programText:   "try {\n\tf();\n} catch e {\n\tprint(e);\n}\n",
+		},
+		{
+			name:          "try catch without name",
+			input:         `try { f(); } catch { }`,
+			numStatements: 1,
+			programText:   "try {\n\tf();\n} catch {\n}\n",
+		},
+		{
+			name:          "import",
+			input:         `import "lib/math"; math.pi;`,
+			numStatements: 2,
+			programText:   "import \"lib/math\";\nmath.pi\n",
+		},
+		{
+			name:          "raise",
+			input:         `raise error("boom"); throw "boom";`,
+			numStatements: 2,
+			programText:   "raise error(\"boom\");;\nraise \"boom\";\n",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -140,3 +209,167 @@ func TestParser_ParseProgram(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_illegalTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "bad escape",
+			input: `let x = "\q";`,
+			err:   "parser error at test.jk:1:9: invalid escape sequence: \\q",
+		},
+		{
+			name:  "unterminated string",
+			input: "let x = 1;\nlet y = \"open;",
+			err:   "parser error at test.jk:2:9: unterminated string",
+		},
+		{
+			name:  "unexpected character",
+			input: "let x = @;",
+			err:   "parser error at test.jk:1:9: unexpected character '@'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input, lexer.WithFilename("test.jk")))
+			p.ParseProgram()
+			if len(p.errors) == 0 {
+				t.Fatalf("expected parser errors")
+			}
+			if got := p.errors[0].Error(); got != tt.err {
+				t.Errorf("invalid error: got %s - want %s", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestParser_spans(t *testing.T) {
+	input := "let x = a + b * 2;\nfoo(x, [1, 2])[0] = {\"a\": 1};\nif x {\n  x\n} else if y { y }"
+	prog := New(lexer.New(input)).ParseProgram()
+	if len(prog.Statements) != 3 {
+		t.Fatalf("invalid number of statements: got %d - want 3", len(prog.Statements))
+	}
+	let := prog.Statements[0].(*ast.LetStatement)
+	infix := let.Value.(*ast.InfixExpression)
+	assign := prog.Statements[1].(*ast.IndexAssignStatement)
+	ifExp := prog.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+
+	tests := []struct {
+		name       string
+		node       ast.Node
+		start, end string
+	}{
+		{name: "program", node: prog, start: "1:1", end: "5:18"},
+		{name: "let", node: let, start: "1:1", end: "1:19"},
+		{name: "let name", node: let.Name, start: "1:5", end: "1:6"},
+		{name: "infix", node: infix, start: "1:9", end: "1:18"},
+		{name: "nested infix", node: infix.Right, start: "1:13", end: "1:18"},
+		{name: "index assign", node: assign, start: "2:1", end: "2:30"},
+		{name: "index", node: assign.Target, start: "2:1", end: "2:18"},
+		{name: "call", node: assign.Target.Left, start: "2:1", end: "2:15"},
+		{name: "array", node: assign.Target.Left.(*ast.CallExpression).Arguments[1], start: "2:8", end: "2:14"},
+		{name: "map", node: assign.Value, start: "2:21", end: "2:29"},
+		{name: "if", node: ifExp, start: "3:1", end: "5:18"},
+		{name: "consequence", node: ifExp.Consequence, start: "3:6", end: "5:2"},
+		{name: "else if", node: ifExp.Alternative, start: "5:8", end: "5:18"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.node.Pos().String(); got != tt.start {
+				t.Errorf("invalid start: got %s - want %s", got, tt.start)
+			}
+			if got := tt.node.End().String(); got != tt.end {
+				t.Errorf("invalid end: got %s - want %s", got, tt.end)
+			}
+		})
+	}
+}
+
+func TestParser_errorRecovery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		errs  []string
+	}{
+		{
+			name:  "one error per statement",
+			input: "let x = ;\nlet y 5;\nlet z = 3 +;\nprint(z);",
+			errs: []string{
+				"parser error at 1:9: expected an expression - got: ;",
+				"parser error at 2:7: invalid token. expected: = - got: INT",
+				"parser error at 3:12: expected an expression - got: ;",
+			},
+		},
+		{
+			name:  "invalid func statement",
+			input: "fn foo(1) { return 1; }\nfn bar(a) { return a }\nlet b = bar(2)",
+			errs: []string{
+				"parser error at 1:8: invalid type for func param. got *ast.IntegerLiteral - want *ast.Identifier",
+				"parser error at 3:15: invalid token. expected: ; - got: EOF",
+			},
+		},
+		{
+			name:  "errors inside blocks",
+			input: "fn f() {\n  let a = ;\n  let b = 2\n  return a;\n}\nif x { y = }\nlet c = f() 1;",
+			errs: []string{
+				"parser error at 2:11: expected an expression - got: ;",
+				"parser error at 4:3: invalid token. expected: ; - got: return",
+				"parser error at 6:12: expected an expression - got: }",
+				"parser error at 7:13: invalid token. expected: ; - got: INT",
+			},
+		},
+		{
+			name:  "unclosed braces",
+			input: "let m = {1: 2 3: 4};\nlet n = [1, 2;\nwhile n {\nlet a = 1;\n",
+			errs: []string{
+				"parser error at 1:15: invalid token. expected: , or } - got: INT",
+				"parser error at 2:14: invalid token. expected: ] - got: ;",
+				"parser error at 5:1: invalid token. expected: } - got: EOF",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			p.ParseProgram()
+			errs := p.Errors()
+			if len(errs) != len(tt.errs) {
+				t.Errorf("invalid number of errors: got %d - want %d", len(errs), len(tt.errs))
+			}
+			for i := 0; i < len(errs) && i < len(tt.errs); i++ {
+				if got := errs[i].Error(); got != tt.errs[i] {
+					t.Errorf("invalid error %d: got %s - want %s", i, got, tt.errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	p := New(lexer.New("let x = {1: 2 3: 4};", lexer.WithFilename("test.jk")))
+	p.ParseProgram()
+	errs := p.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("invalid number of errors: got %d - want 1", len(errs))
+	}
+	var perr *ParseError
+	if !errors.As(errs[0], &perr) {
+		t.Fatalf("invalid error type: got %T - want %T", errs[0], perr)
+	}
+	if !errors.Is(perr, ErrParserError) {
+		t.Errorf("error does not wrap ErrParserError")
+	}
+	want := token.Position{File: "test.jk", Line: 1, Column: 15, Offset: 14}
+	if perr.Pos != want {
+		t.Errorf("invalid position: got %s - want %s", perr.Pos, want)
+	}
+	if perr.Token != token.Int {
+		t.Errorf("invalid token: got %s - want %s", perr.Token, token.Int)
+	}
+	if len(perr.Expected) != 2 || perr.Expected[0] != token.Comma || perr.Expected[1] != token.RBrace {
+		t.Errorf("invalid expected tokens: got %v - want [%s %s]", perr.Expected, token.Comma, token.RBrace)
+	}
+}
@@ -1,80 +1,97 @@
 package parser
 
 import (
-	"fmt"
-
 	"github.com/jimmykodes/joker/ast"
 	"github.com/jimmykodes/joker/token"
 )
 
 func (p *Parser) parseDefineStatement() ast.Statement {
+	start := p.curPos
 	stmt := &ast.DefineStatement{
-		Name: &ast.Identifier{Token: p.curToken, Value: p.curLit},
+		Name: &ast.Identifier{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit},
 	}
 	if !p.expect(p.peekTokenIs(token.Define)) {
-		p.errors = append(p.errors, fmt.Errorf("identifier not followed by assignment or definition"))
+		p.error(newParseError(p.peekPos, p.peekToken, "identifier not followed by assignment or definition"))
 		return nil
 	}
 	stmt.Token = p.curToken
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(token.LowestPrecedence)
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = stmt.Name.Value
+	}
 
 	if !p.expect(p.peekTokenIs(token.SemiCol)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.SemiCol, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.SemiCol))
 		return nil
 	}
 
+	stmt.Span = p.span(start)
 	return stmt
 }
 
 func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
+	start := p.curPos
 
 	if !p.expect(p.peekTokenIs(token.Ident)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.Ident, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.Ident))
 		return nil
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curLit}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit}
 
 	if !p.expect(p.peekTokenIs(token.Assign)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.Assign, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.Assign))
 		return nil
 	}
 
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(token.LowestPrecedence)
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = stmt.Name.Value
+	}
 
 	if !p.expect(p.peekTokenIs(token.SemiCol)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.SemiCol, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.SemiCol))
 		return nil
 	}
 
+	stmt.Span = p.span(start)
 	return stmt
 }
 
 func (p *Parser) parseFuncStatement() ast.Statement {
 	stmt := &ast.FuncStatement{Token: p.curToken}
+	start := p.curPos
 
 	if !p.expect(p.peekTokenIs(token.Ident)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.Ident, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.Ident))
 		return nil
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curLit}
-	stmt.Fn = p.parseFuncExpression().(*ast.FunctionLiteral)
+	stmt.Name = &ast.Identifier{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit}
+	fn, ok := p.parseFuncExpression().(*ast.FunctionLiteral)
+	if !ok {
+		// parseFuncExpression has already reported why
+		return nil
+	}
+	stmt.Fn = fn
+	stmt.Fn.Name = stmt.Name.Value
 
+	stmt.Span = p.span(start)
 	return stmt
 }
 
 func (p *Parser) parseReassignStatement() ast.Statement {
+	start := p.curPos
 	stmt := &ast.ReassignStatement{
-		Name: &ast.Identifier{Token: p.curToken, Value: p.curLit},
+		Name: &ast.Identifier{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit},
 	}
 	if !p.expect(p.peekTokenIs(token.Assign)) {
-		p.errors = append(p.errors, fmt.Errorf("identifier not followed by assignment"))
+		p.error(newParseError(p.peekPos, p.peekToken, "identifier not followed by assignment"))
 		return nil
 	}
 	stmt.Token = p.curToken
@@ -83,32 +100,58 @@ func (p *Parser) parseReassignStatement() ast.Statement {
 	stmt.Value = p.parseExpression(token.LowestPrecedence)
 
 	if !p.expect(p.peekTokenIs(token.SemiCol)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.SemiCol, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.SemiCol))
 		return nil
 	}
 
+	stmt.Span = p.span(start)
+	return stmt
+}
+
+func (p *Parser) parseIndexAssignStatement(target *ast.IndexExpression) ast.Statement {
+	stmt := &ast.IndexAssignStatement{Target: target}
+	if !p.expect(p.peekTokenIs(token.Assign)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.Assign))
+		return nil
+	}
+	stmt.Token = p.curToken
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(token.LowestPrecedence)
+
+	if !p.expect(p.peekTokenIs(token.SemiCol)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.SemiCol))
+		return nil
+	}
+
+	stmt.Span = p.span(target.Pos())
 	return stmt
 }
 
 func (p *Parser) parseContinueStatement() ast.Statement {
 	stmt := &ast.ContinueStatement{Token: p.curToken}
+	start := p.curPos
 	if !p.expect(p.peekTokenIs(token.SemiCol)) {
-		p.errors = append(p.errors, invalidTokenError(p.curLine, token.SemiCol, p.peekToken))
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.SemiCol))
 		return nil
 	}
+	stmt.Span = p.span(start)
 	return stmt
 }
 
 func (p *Parser) parseBreakStatement() ast.Statement {
 	stmt := &ast.BreakStatement{Token: p.curToken}
+	start := p.curPos
 	if p.peekTokenIs(token.SemiCol) {
 		p.nextToken()
 	}
+	stmt.Span = p.span(start)
 	return stmt
 }
 
 func (p *Parser) parseReturnStatement() ast.Statement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
+	start := p.curPos
 
 	p.nextToken()
 	stmt.Value = p.parseExpression(token.LowestPrecedence)
@@ -116,27 +159,109 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 		p.nextToken()
 	}
 
+	stmt.Span = p.span(start)
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+	start := p.curPos
+
+	if !p.expect(p.peekTokenIs(token.LBrace)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.LBrace))
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if !p.expect(p.peekTokenIs(token.Catch)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.Catch))
+		return nil
+	}
+	if p.expect(p.peekTokenIs(token.Ident)) {
+		stmt.Name = &ast.Identifier{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit}
+	}
+	if !p.expect(p.peekTokenIs(token.LBrace)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.LBrace))
+		return nil
+	}
+	stmt.Catch = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SemiCol) {
+		p.nextToken()
+	}
+	stmt.Span = p.span(start)
+	return stmt
+}
+
+func (p *Parser) parseRaiseStatement() ast.Statement {
+	stmt := &ast.RaiseStatement{Token: p.curToken}
+	start := p.curPos
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(token.LowestPrecedence)
+	if stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SemiCol) {
+		p.nextToken()
+	}
+
+	stmt.Span = p.span(start)
 	return stmt
 }
 
 func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	start := p.curPos
 	stmt.Expression = p.parseExpression(token.LowestPrecedence)
+	if target, ok := stmt.Expression.(*ast.IndexExpression); ok && p.peekTokenIs(token.Assign) {
+		return p.parseIndexAssignStatement(target)
+	}
 	if p.peekTokenIs(token.SemiCol) {
 		p.nextToken()
 	}
+	stmt.Span = p.span(start)
 	return stmt
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
+	start := p.curPos
 	p.nextToken()
 	for !p.curTokenIs(token.RBrace, token.EOF) {
+		depth := p.depth
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			if p.synchronize(depth) {
+				break
+			}
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	if p.curTokenIs(token.EOF) {
+		p.error(invalidTokenError(p.curPos, p.curToken, token.RBrace))
+	}
+	block.Span = p.span(start)
 	return block
 }
+
+func (p *Parser) parseImportStatement() ast.Statement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+	start := p.curPos
+
+	if !p.expect(p.peekTokenIs(token.String)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.String))
+		return nil
+	}
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Span: p.span(p.curPos), Value: p.curLit}
+
+	if !p.expect(p.peekTokenIs(token.SemiCol)) {
+		p.error(invalidTokenError(p.peekPos, p.peekToken, token.SemiCol))
+		return nil
+	}
+
+	stmt.Span = p.span(start)
+	return stmt
+}
@@ -1,12 +1,19 @@
 package parser
 
 import (
+	"github.com/jimmykodes/joker/ast"
 	"github.com/jimmykodes/joker/token"
 )
 
 func (p *Parser) nextToken() {
-	p.curToken, p.curLine, p.curLit = p.peekToken, p.peekLine, p.peekLit
-	p.peekToken, p.peekLine, p.peekLit = p.l.NextToken()
+	p.depth += braceDelta(p.curToken)
+	p.curToken, p.curPos, p.curEnd, p.curLit = p.peekToken, p.peekPos, p.peekEnd, p.peekLit
+	p.peekToken, p.peekPos, p.peekEnd, p.peekLit = p.l.NextToken()
+}
+
+// span returns the span from start to the end of the current token
+func (p *Parser) span(start token.Position) ast.Span {
+	return ast.Span{Start: start, Stop: p.curEnd}
 }
 
 func (p *Parser) expect(b bool) bool {
@@ -35,3 +42,14 @@ func tokenIs(tok token.Token, t1 token.Token, ts ...token.Token) bool {
 	}
 	return false
 }
+
+// braceDelta returns how the token changes the brace depth
+func braceDelta(t token.Token) int {
+	switch t {
+	case token.LBrace:
+		return 1
+	case token.RBrace:
+		return -1
+	}
+	return 0
+}
@@ -17,7 +17,7 @@ const Prompt = ">> "
 
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	env := object.NewEnvironment(object.WithOut(out))
 	for {
 		fmt.Fprint(out, Prompt)
 		if scanned := scanner.Scan(); !scanned {
@@ -62,7 +62,12 @@ func StartVM(in io.Reader, out io.Writer) {
 			fmt.Fprintf(out, "Compile Error: %s\n", err)
 			continue
 		}
-		machine := vm.New(comp.Bytecode())
+		bytecode, err := comp.Link()
+		if err != nil {
+			fmt.Fprintf(out, "Link Error: %s\n", err)
+			continue
+		}
+		machine := vm.New(bytecode, vm.WithStdout(out))
 		if err := machine.Run(); err != nil {
 			fmt.Fprintf(out, "VM Error: %s\n", err)
 			continue
@@ -0,0 +1,155 @@
+package sandbox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File is an open file. Files opened with Open only need to be read, files
+// opened with Create or Append only need to be written.
+type File interface {
+	fs.File
+	io.Writer
+}
+
+// FS is a filesystem that scripts can open files in. It extends fs.FS, which
+// opens files for reading, with the ability to open files for writing.
+//
+// Names are passed through as the script gave them, so unlike fs.FS they may
+// be absolute or contain "..". Each FS decides how to interpret them.
+type FS interface {
+	fs.FS
+	// Create creates the named file, truncating it if it already exists
+	Create(name string) (File, error)
+	// Append opens the named file for writing at its end. The file must
+	// already exist.
+	Append(name string) (File, error)
+}
+
+// OS returns the host filesystem, with no restrictions on which files can be
+// opened. Names are interpreted as by the os package.
+func OS() FS {
+	return osFS{}
+}
+
+// Dir returns the host filesystem, restricted to files inside the given root
+// directories. Names are interpreted as by the os package, and opening a file
+// outside every root, including by following a symlink, fails with
+// fs.ErrPermission.
+func Dir(roots ...string) FS {
+	fsys := osFS{roots: make([]string, 0, len(roots))}
+	for _, root := range roots {
+		path, _ := realPath(root)
+		fsys.roots = append(fsys.roots, path)
+	}
+	return fsys
+}
+
+type osFS struct {
+	// roots is nil when the filesystem is unrestricted
+	roots []string
+}
+
+func (fsys osFS) Open(name string) (fs.File, error) {
+	path, err := fsys.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	return f, renamed(err, name)
+}
+
+func (fsys osFS) Create(name string) (File, error) {
+	path, err := fsys.resolve("create", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, renamed(err, name)
+	}
+	return f, nil
+}
+
+func (fsys osFS) Append(name string) (File, error) {
+	path, err := fsys.resolve("append", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return nil, renamed(err, name)
+	}
+	return f, nil
+}
+
+// resolve returns the path to open for name, or an error if name is outside
+// all of the filesystem's roots. The path that was checked is the one that
+// must be opened, since opening name itself could follow a symlink and ".."
+// out of the root.
+func (fsys osFS) resolve(op, name string) (string, error) {
+	if fsys.roots == nil {
+		return name, nil
+	}
+	path, ok := realPath(name)
+	if !ok {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	for _, root := range fsys.roots {
+		dir := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+		if path == root || strings.HasPrefix(path, dir) {
+			return path, nil
+		}
+	}
+	return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+// renamed reports err against the name the script gave rather than the
+// resolved path that was opened
+func renamed(err error, name string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Path = name
+	}
+	return err
+}
+
+// realPath returns the absolute path of name with all symlinks resolved. The
+// file doesn't need to exist, in which case the symlinks in its nearest
+// existing parent are resolved.
+//
+// ".." is resolved after the symlinks before it, as the os would, so name
+// isn't cleaned lexically until its symlinks have been followed.
+//
+// ok is false if part of name exists but can't be resolved, such as a symlink
+// to a file that doesn't exist. The os would still follow it, for instance to
+// create the file it points to, so the path returned isn't where name leads.
+func realPath(name string) (path string, ok bool) {
+	path = name
+	if !filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return filepath.Clean(name), true
+		}
+		path = wd + string(filepath.Separator) + path
+	}
+	var rest []string
+	for {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), true
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return "", false
+		}
+		i := strings.LastIndexByte(path, filepath.Separator)
+		if i <= len(filepath.VolumeName(path)) {
+			return filepath.Join(append([]string{path}, rest...)...), true
+		}
+		rest = append([]string{path[i+1:]}, rest...)
+		path = path[:i]
+	}
+}
@@ -0,0 +1,147 @@
+package sandbox
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "dangling"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(outside, "inner"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "inner"), filepath.Join(root, "inner")); err != nil {
+		t.Fatal(err)
+	}
+	fsys := Dir(root)
+
+	f, err := fsys.Create(filepath.Join(root, "out.txt"))
+	if err != nil {
+		t.Fatalf("create error: %s", err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name string
+		open func() error
+		err  error
+	}{
+		{name: "inside root", open: func() error { return open(fsys, filepath.Join(root, "out.txt")) }},
+		{name: "missing file", open: func() error { return open(fsys, filepath.Join(root, "missing")) }, err: fs.ErrNotExist},
+		{name: "outside root", open: func() error { return open(fsys, filepath.Join(outside, "secret")) }, err: fs.ErrPermission},
+		{name: "parent", open: func() error { return open(fsys, filepath.Join(root, "..", filepath.Base(outside), "secret")) }, err: fs.ErrPermission},
+		{name: "symlink", open: func() error { return open(fsys, filepath.Join(root, "link", "secret")) }, err: fs.ErrPermission},
+		{name: "symlink parent", open: func() error { return open(fsys, root+"/inner/../secret") }, err: fs.ErrPermission},
+		{
+			name: "create through dangling symlink",
+			open: func() error {
+				_, err := fsys.Create(filepath.Join(root, "dangling"))
+				return err
+			},
+			err: fs.ErrPermission,
+		},
+		{name: "dangling symlink", open: func() error { return open(fsys, filepath.Join(root, "dangling")) }, err: fs.ErrPermission},
+		{
+			name: "create through symlink parent",
+			open: func() error {
+				_, err := fsys.Create(root + "/inner/../new")
+				return err
+			},
+			err: fs.ErrPermission,
+		},
+		{
+			name: "create outside root",
+			open: func() error {
+				_, err := fsys.Create(filepath.Join(outside, "new"))
+				return err
+			},
+			err: fs.ErrPermission,
+		},
+		{
+			name: "append",
+			open: func() error {
+				f, err := fsys.Append(filepath.Join(root, "out.txt"))
+				if err == nil {
+					f.Close()
+				}
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.open()
+			if tt.err == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("invalid error: got %v - want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func open(fsys FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func TestPermissions(t *testing.T) {
+	mem := NewMemFS()
+	mem.WriteFile("data.txt", []byte("data"))
+
+	t.Run("no filesystem", func(t *testing.T) {
+		p := &Permissions{}
+		if _, err := p.Open("data.txt"); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("invalid error: got %v - want %s", err, fs.ErrPermission)
+		}
+	})
+	t.Run("read only", func(t *testing.T) {
+		p := &Permissions{FS: mem, ReadOnly: true}
+		if err := open(p.FS, "data.txt"); err != nil {
+			t.Errorf("open error: %s", err)
+		}
+		if _, err := p.Create("data.txt"); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("invalid create error: got %v - want %s", err, fs.ErrPermission)
+		}
+		if _, err := p.Append("data.txt"); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("invalid append error: got %v - want %s", err, fs.ErrPermission)
+		}
+	})
+	t.Run("argv", func(t *testing.T) {
+		if _, err := (&Permissions{}).Argv(); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("invalid error: got %v - want %s", err, fs.ErrPermission)
+		}
+		args, err := (&Permissions{Args: []string{"script"}}).Argv()
+		if err != nil || len(args) != 1 || args[0] != "script" {
+			t.Errorf("invalid args: got %v, %v - want [script]", args, err)
+		}
+	})
+	t.Run("stdout", func(t *testing.T) {
+		if err := (&Permissions{}).CheckStdout(); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("invalid error: got %v - want %s", err, fs.ErrPermission)
+		}
+		if err := (*Permissions)(nil).CheckStdout(); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package sandbox
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory filesystem. It is safe for concurrent use.
+//
+// Names are slash separated, and are cleaned and made relative to the root of
+// the filesystem, so "/a/b", "a/b" and "a/../a/b" all name the same file.
+// MemFS holds only files; directories exist implicitly and can't be opened.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+}
+
+type memData struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memData)}
+}
+
+// WriteFile creates the named file with the given contents, replacing it if
+// it already exists
+func (m *MemFS) WriteFile(name string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[memName(name)] = &memData{data: append([]byte(nil), data...), modTime: time.Now()}
+}
+
+// ReadFile returns the contents of the named file
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[memName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memName(name)
+	f, ok := m.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	// readers see the file as it was when it was opened
+	return &memFile{fsys: m, name: key, info: f.info(key), r: bytes.NewReader(f.data)}, nil
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memName(name)
+	f := &memData{modTime: time.Now()}
+	m.files[key] = f
+	return &memFile{fsys: m, name: key, w: f}, nil
+}
+
+func (m *MemFS) Append(name string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memName(name)
+	f, ok := m.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "append", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memFile{fsys: m, name: key, w: f}, nil
+}
+
+func memName(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+func (f *memData) info(name string) fs.FileInfo {
+	return memInfo{name: path.Base(name), size: int64(len(f.data)), modTime: f.modTime}
+}
+
+// memFile is a file opened from a MemFS. Only one of r, for reading, and w,
+// for writing, is set.
+type memFile struct {
+	fsys   *MemFS
+	name   string
+	info   fs.FileInfo
+	r      *bytes.Reader
+	w      *memData
+	closed bool
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.w != nil {
+		f.fsys.mu.Lock()
+		defer f.fsys.mu.Unlock()
+		return f.w.info(f.name), nil
+	}
+	return f.info, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.r == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrPermission}
+	}
+	return f.r.Read(p)
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.w == nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+	}
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	f.w.data = append(f.w.data, p...)
+	f.w.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return 0o644 }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return false }
+func (i memInfo) Sys() any           { return nil }
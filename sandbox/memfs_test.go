@@ -0,0 +1,63 @@
+package sandbox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+	m.WriteFile("/dir/a.txt", []byte("hello"))
+
+	f, err := m.Open("dir/../dir/a.txt")
+	if err != nil {
+		t.Fatalf("open error: %s", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("invalid data: got %q - want hello", data)
+	}
+	if info, _ := f.Stat(); info.Name() != "a.txt" || info.Size() != 5 {
+		t.Errorf("invalid stat: got %s %d - want a.txt 5", info.Name(), info.Size())
+	}
+	f.Close()
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("invalid read after close error: got %v - want %s", err, fs.ErrClosed)
+	}
+
+	w, err := m.Append("dir/a.txt")
+	if err != nil {
+		t.Fatalf("append error: %s", err)
+	}
+	if _, err := w.Write([]byte(", world")); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if _, err := w.Read(make([]byte, 1)); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("invalid read from writer error: got %v - want %s", err, fs.ErrPermission)
+	}
+	w.Close()
+	if data, _ := m.ReadFile("/dir/a.txt"); string(data) != "hello, world" {
+		t.Errorf("invalid data after append: got %q - want %q", data, "hello, world")
+	}
+
+	w, err = m.Create("dir/a.txt")
+	if err != nil {
+		t.Fatalf("create error: %s", err)
+	}
+	w.Close()
+	if data, _ := m.ReadFile("dir/a.txt"); len(data) != 0 {
+		t.Errorf("create did not truncate: got %q", data)
+	}
+
+	if _, err := m.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("invalid open error: got %v - want %s", err, fs.ErrNotExist)
+	}
+	if _, err := m.Append("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("invalid append error: got %v - want %s", err, fs.ErrNotExist)
+	}
+}
@@ -0,0 +1,96 @@
+// Package sandbox limits what scripts can do on the host running them.
+package sandbox
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Permissions are the capabilities granted to a script. A nil *Permissions
+// grants everything: files are opened on the host filesystem, argv returns
+// the host's os.Args, and the standard streams can be used. A non-nil
+// Permissions grants only what it sets.
+type Permissions struct {
+	// FS is the filesystem that scripts open files in. If nil, scripts can't
+	// open files.
+	FS FS
+	// ReadOnly prevents scripts from opening files for writing
+	ReadOnly bool
+	// Args is returned by argv. If nil, argv fails.
+	Args []string
+	// Stdout allows scripts to print
+	Stdout bool
+	// Stderr allows scripts to print to stderr with eprint
+	Stderr bool
+	// Stdin allows scripts to read input
+	Stdin bool
+}
+
+// Open opens the named file for reading
+func (p *Permissions) Open(name string) (fs.File, error) {
+	if p == nil {
+		return os.Open(name)
+	}
+	if p.FS == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return p.FS.Open(name)
+}
+
+// Create creates the named file for writing, truncating it if it exists
+func (p *Permissions) Create(name string) (File, error) {
+	if p == nil {
+		return os.Create(name)
+	}
+	if p.FS == nil || p.ReadOnly {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+	}
+	return p.FS.Create(name)
+}
+
+// Append opens the named file for writing at its end
+func (p *Permissions) Append(name string) (File, error) {
+	if p == nil {
+		return os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	}
+	if p.FS == nil || p.ReadOnly {
+		return nil, &fs.PathError{Op: "append", Path: name, Err: fs.ErrPermission}
+	}
+	return p.FS.Append(name)
+}
+
+// Argv returns the arguments the script was run with
+func (p *Permissions) Argv() ([]string, error) {
+	if p == nil {
+		return os.Args, nil
+	}
+	if p.Args == nil {
+		return nil, fmt.Errorf("argv: %w", fs.ErrPermission)
+	}
+	return p.Args, nil
+}
+
+// CheckStdout returns an error if scripts may not print
+func (p *Permissions) CheckStdout() error {
+	if p != nil && !p.Stdout {
+		return fmt.Errorf("stdout: %w", fs.ErrPermission)
+	}
+	return nil
+}
+
+// CheckStderr returns an error if scripts may not print to stderr
+func (p *Permissions) CheckStderr() error {
+	if p != nil && !p.Stderr {
+		return fmt.Errorf("stderr: %w", fs.ErrPermission)
+	}
+	return nil
+}
+
+// CheckStdin returns an error if scripts may not read input
+func (p *Permissions) CheckStdin() error {
+	if p != nil && !p.Stdin {
+		return fmt.Errorf("stdin: %w", fs.ErrPermission)
+	}
+	return nil
+}
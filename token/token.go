@@ -33,6 +33,10 @@ const (
 	Return
 	True
 	False
+	Try
+	Catch
+	Raise
+	Import
 	keywordEnd
 
 	operatorBeg
@@ -43,6 +47,8 @@ const (
 	EQ  // ==
 	NEQ // !=
 	NOT // !
+	AND // &&
+	OR  // ||
 
 	Assign  // =
 	Define  // :=
@@ -63,6 +69,7 @@ const (
 	Comma   // ,
 	SemiCol // ;
 	Colon   // :
+	Dot     // .
 	operatorEnd
 )
 
@@ -84,6 +91,10 @@ var tokens = [...]string{
 	Return:   "return",
 	True:     "true",
 	False:    "false",
+	Try:      "try",
+	Catch:    "catch",
+	Raise:    "raise",
+	Import:   "import",
 	LT:       "<",
 	GT:       ">",
 	LTE:      "<=",
@@ -91,6 +102,8 @@ var tokens = [...]string{
 	EQ:       "==",
 	NEQ:      "!=",
 	NOT:      "!",
+	AND:      "&&",
+	OR:       "||",
 	LParen:   "(",
 	RParen:   ")",
 	LBrace:   "{",
@@ -108,6 +121,7 @@ var tokens = [...]string{
 	Comma:    ",",
 	SemiCol:  ";",
 	Colon:    ":",
+	Dot:      ".",
 }
 
 func (t Token) String() string {
@@ -138,6 +152,8 @@ type Precedence uint
 const (
 	_ Precedence = iota
 	LowestPrecedence
+	OrPrecedence
+	AndPrecedence
 	EQPrecedence
 	LGTPrecedence
 	SumPrecedence
@@ -149,6 +165,10 @@ const (
 
 func (t Token) Precedence() Precedence {
 	switch t {
+	case OR:
+		return OrPrecedence
+	case AND:
+		return AndPrecedence
 	case EQ, NEQ:
 		return EQPrecedence
 	case LT, LTE, GT, GTE:
@@ -159,7 +179,7 @@ func (t Token) Precedence() Precedence {
 		return ProductPrecedence
 	case LParen:
 		return CallPrecedence
-	case LBrack:
+	case LBrack, Dot:
 		return IndexPrecedence
 	default:
 		return LowestPrecedence
@@ -173,6 +193,10 @@ func init() {
 	for i := keywordBeg + 1; i < keywordEnd; i++ {
 		keywords[tokens[i]] = i
 	}
+	// "not" is an alias for the ! operator
+	keywords["not"] = NOT
+	// "throw" is an alias for raise
+	keywords["throw"] = Raise
 }
 
 func Lookup(ident string) Token {
@@ -185,3 +209,25 @@ func Lookup(ident string) Token {
 func inRange(item, beg, end Token) bool {
 	return beg < item && item < end
 }
+
+// Position is a location in a source file.
+type Position struct {
+	File   string
+	Line   int // 1 based
+	Column int // 1 based, counted in bytes
+	Offset int // 0 based, counted in bytes
+}
+
+// IsValid reports whether the position has been set.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position as file:line:col, omitting the file when it is unknown.
+func (p Position) String() string {
+	s := strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+	if p.File != "" {
+		s = p.File + ":" + s
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package joker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jimmykodes/joker/object"
+	"github.com/jimmykodes/joker/vm"
+)
+
+var ErrUnsupportedValue = errors.New("unsupported value")
+
+// ToObject converts a Go value to a joker object. Supported values are nil,
+// bools, integers, floats, strings, slices of supported values and maps of
+// strings to supported values. object.Object values are returned unchanged.
+func ToObject(v any) (object.Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return vm.Null, nil
+	case object.Object:
+		return v, nil
+	case bool:
+		if v {
+			return object.True, nil
+		}
+		return object.False, nil
+	case int:
+		return &object.Integer{Value: int64(v)}, nil
+	case int8:
+		return &object.Integer{Value: int64(v)}, nil
+	case int16:
+		return &object.Integer{Value: int64(v)}, nil
+	case int32:
+		return &object.Integer{Value: int64(v)}, nil
+	case int64:
+		return &object.Integer{Value: v}, nil
+	case uint8:
+		return &object.Integer{Value: int64(v)}, nil
+	case uint16:
+		return &object.Integer{Value: int64(v)}, nil
+	case uint32:
+		return &object.Integer{Value: int64(v)}, nil
+	case float32:
+		return &object.Float{Value: float64(v)}, nil
+	case float64:
+		return &object.Float{Value: v}, nil
+	case string:
+		return &object.String{Value: v}, nil
+	case []any:
+		elems := make([]object.Object, len(v))
+		for i, elem := range v {
+			obj, err := ToObject(elem)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			elems[i] = obj
+		}
+		return &object.Array{Elements: elems}, nil
+	case map[string]any:
+		pairs := make(map[object.HashKey]object.HashPair, len(v))
+		for key, val := range v {
+			obj, err := ToObject(val)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			k := &object.String{Value: key}
+			pairs[k.HashKey()] = object.HashPair{Key: k, Value: obj}
+		}
+		return &object.Map{Pairs: pairs}, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedValue, v)
+	}
+}
+
+// FromObject converts a joker object to a Go value. Integers, floats,
+// strings, booleans and null become int64, float64, string, bool and nil,
+// arrays become []any and maps become map[any]any. Any other object, such as a
+// function, is returned unchanged.
+func FromObject(obj object.Object) any {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		out := make([]any, len(obj.Elements))
+		for i, elem := range obj.Elements {
+			out[i] = FromObject(elem)
+		}
+		return out
+	case *object.Map:
+		out := make(map[any]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			out[FromObject(pair.Key)] = FromObject(pair.Value)
+		}
+		return out
+	default:
+		return obj
+	}
+}
@@ -0,0 +1,149 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jimmykodes/joker/object"
+)
+
+// TraceEntry is a single active frame at the time of a runtime error.
+type TraceEntry struct {
+	Function string
+	// Module is the name of the file the function is in, if known
+	Module string
+	Line   int
+}
+
+// location formats the position of the entry for error messages
+func (e TraceEntry) location() string {
+	if e.Module == "" {
+		return fmt.Sprintf("line %d", e.Line)
+	}
+	return fmt.Sprintf("%s:%d", e.Module, e.Line)
+}
+
+// maxTraceLines is the most entries of a trace that RuntimeError.Error
+// writes, after repeated entries are combined
+const maxTraceLines = 64
+
+// RuntimeError is returned from Run when executing the program fails. It wraps
+// the underlying error along with the stack trace of the frames that were active
+// when it occurred, innermost first.
+type RuntimeError struct {
+	Err   error
+	Trace []TraceEntry
+}
+
+func (e *RuntimeError) Error() string {
+	var sb strings.Builder
+	if len(e.Trace) > 0 {
+		fmt.Fprintf(&sb, "%s: ", e.Trace[0].location())
+	}
+	sb.WriteString(e.Err.Error())
+	lines := 0
+	for i := 0; i < len(e.Trace); {
+		if lines == maxTraceLines {
+			fmt.Fprintf(&sb, "\n\t... %d more", len(e.Trace)-i)
+			break
+		}
+		entry := e.Trace[i]
+		n := 1
+		for i+n < len(e.Trace) && e.Trace[i+n] == entry {
+			n++
+		}
+		fmt.Fprintf(&sb, "\n\tat %s (%s)", entry.Function, entry.location())
+		if n > 1 {
+			// deep recursion is written once, rather than for every call
+			fmt.Fprintf(&sb, "\n\t... repeated %d more times", n-1)
+		}
+		lines++
+		i += n
+	}
+	return sb.String()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	trace := make([]TraceEntry, 0, vm.framesIdx)
+	for i := vm.framesIdx - 1; i >= 0; i-- {
+		fr := vm.frames[i]
+		name := fr.cl.Fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		trace = append(trace, TraceEntry{Function: name, Module: vm.moduleOf(i, fr), Line: fr.Line()})
+	}
+	return &RuntimeError{Err: err, Trace: trace}
+}
+
+// moduleOf returns the name of the module that the frame at idx is executing.
+// The top level instructions of the program belong to the module whose range
+// holds the frame's ip, and functions to the module whose constants they're
+// in.
+func (vm *VM) moduleOf(idx int, fr *Frame) string {
+	if idx == 0 {
+		for _, mod := range vm.modules {
+			if mod.Instructions.Contains(fr.ip) {
+				return mod.Name
+			}
+		}
+		return ""
+	}
+	for i, constant := range vm.constants {
+		if constant != fr.cl.Fn {
+			continue
+		}
+		for _, mod := range vm.modules {
+			if mod.Constants.Contains(i) {
+				return mod.Name
+			}
+		}
+	}
+	return ""
+}
+
+// handler is a try block that errors raised in it are caught by
+type handler struct {
+	// framesIdx is the number of frames when the try block was entered
+	framesIdx int
+	// catchPos is the position of the catch block in the frame's instructions
+	catchPos int
+	// sp is the stack pointer when the try block was entered
+	sp int
+}
+
+// catch unwinds to the innermost try block and jumps to its catch block, with
+// the error on the stack. It reports whether there was a try block to catch
+// err. Errors that stop the program rather than the script, such as reaching
+// a limit, are never caught.
+func (vm *VM) catch(err error) bool {
+	if len(vm.handlers) == 0 || errors.Is(err, ErrStepLimit) || errors.Is(err, ErrCanceled) || errors.Is(err, object.ErrMemoryLimit) {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	for vm.framesIdx > h.framesIdx {
+		vm.popFrame()
+	}
+	// the variables of the frames that were left are above the stack as it
+	// was when the try block was entered
+	vm.closeUpvalues(h.sp)
+	vm.sp = h.sp
+	// the frame's ip is incremented before the next instruction is executed
+	vm.currentFrame().ip = h.catchPos - 1
+
+	var errOb *object.Error
+	if !errors.As(err, &errOb) {
+		errOb = object.ErrorFromGo(err)
+	}
+	// the stack can't overflow, since it's no higher than when the try block
+	// was entered
+	vm.push(errOb)
+	return true
+}
@@ -18,3 +18,12 @@ type Frame struct {
 func (f Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// Line returns the source line of the instruction the frame is currently executing
+func (f Frame) Line() int {
+	ip := f.ip
+	if ip < 0 {
+		ip = 0
+	}
+	return f.cl.Fn.Lines.Line(ip)
+}
@@ -1,63 +1,257 @@
 package vm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"sort"
+	"time"
 
 	"github.com/jimmykodes/joker/builtins"
 	"github.com/jimmykodes/joker/code"
 	"github.com/jimmykodes/joker/compiler"
 	"github.com/jimmykodes/joker/object"
+	"github.com/jimmykodes/joker/sandbox"
 )
 
 const (
-	GlobalSize     = math.MaxUint16
-	StackSize      = 2048
-	FrameStackSize = 1024
+	GlobalSize = math.MaxUint16
+	// StackSize and FrameStackSize are the default maximum sizes of the
+	// stack, in values, and of the frame stack, which is the recursion depth.
+	// Both start small and grow as the program needs them to.
+	StackSize      = 1 << 20
+	FrameStackSize = 1 << 16
+
+	initialStackSize = 64
 )
 
-var Null = &object.Null{}
+// MainFunctionName is the name given to the top level of the program in stack traces
+const MainFunctionName = "<main>"
+
+var Null = object.NullValue
 
 type VM struct {
 	constants []object.Object
-	globals   [GlobalSize]object.Object
+	// globals grows as globals are set, up to GlobalSize
+	globals []object.Object
+	// modules is the program's module table, used to name the file of each
+	// frame in stack traces
+	modules []compiler.Module
+	linked  bool
+
+	stack        []object.Object
+	sp           int
+	maxStackSize int
+
+	frames    []*Frame
+	framesIdx int
+	maxDepth  int
+
+	// upvalues are the open upvalues, of variables that closures have
+	// captured from frames that are still running, ordered by stack slot
+	upvalues []*object.Upvalue
+
+	// handlers are the try blocks that have been entered and not yet left,
+	// innermost last
+	handlers []handler
+
+	// registry holds the native functions available to the program, if any
+	registry *builtins.Registry
+	// rt is passed to builtins, and holds the meter that allocations are
+	// charged to and the streams they read and write
+	rt *object.Runtime
+
+	// ctx, timeout and stepLimit bound how long the program may run. A zero
+	// timeout or stepLimit means no limit.
+	ctx       context.Context
+	timeout   time.Duration
+	stepLimit int
+	steps     int
+}
 
-	stack [StackSize]object.Object
-	sp    int
+type Option func(*VM)
 
-	frames    [FrameStackSize]*Frame
-	framesIdx int
+// WithStdout sends everything the program prints to w rather than os.Stdout
+func WithStdout(w io.Writer) Option {
+	return func(vm *VM) {
+		vm.rt.IO.Stdout = w
+	}
 }
 
-func New(bytecode *compiler.Bytecode) *VM {
-	vm := &VM{constants: bytecode.Constants}
-	fn := &object.CompiledFunction{Instructions: bytecode.Instructions}
-	vm.pushFrame(NewFrame(&object.Closure{Fn: fn}, 0))
+// WithStderr sends everything the program prints with eprint to w rather than os.Stderr
+func WithStderr(w io.Writer) Option {
+	return func(vm *VM) {
+		vm.rt.IO.Stderr = w
+	}
+}
+
+// WithStdin makes the program read input from r rather than os.Stdin
+func WithStdin(r io.Reader) Option {
+	return func(vm *VM) {
+		vm.rt.IO.Stdin = r
+	}
+}
+
+// WithRegistry provides the native functions the program was compiled with
+func WithRegistry(r *builtins.Registry) Option {
+	return func(vm *VM) {
+		vm.registry = r
+	}
+}
+
+// WithContext stops the program with ErrCanceled when ctx is done
+func WithContext(ctx context.Context) Option {
+	return func(vm *VM) {
+		vm.ctx = ctx
+	}
+}
+
+// WithTimeout stops the program with ErrCanceled if it is still running d after Run is called
+func WithTimeout(d time.Duration) Option {
+	return func(vm *VM) {
+		vm.timeout = d
+	}
+}
+
+// WithStepLimit stops the program with ErrStepLimit once it has executed n instructions
+func WithStepLimit(n int) Option {
+	return func(vm *VM) {
+		vm.stepLimit = n
+	}
+}
+
+// WithMemoryLimit stops the program with object.ErrMemoryLimit once the
+// arrays, maps and strings it creates add up to more than n bytes
+func WithMemoryLimit(n int) Option {
+	return func(vm *VM) {
+		vm.rt.Meter = object.NewMeter(n)
+	}
+}
+
+// WithPermissions limits what the program's builtins may do on the host. See
+// sandbox.Permissions.
+func WithPermissions(p *sandbox.Permissions) Option {
+	return func(vm *VM) {
+		vm.rt.Perms = p
+	}
+}
+
+// WithMaxStackSize limits the stack to n values, rather than StackSize. A
+// program that needs more fails with ErrStackOverflow.
+func WithMaxStackSize(n int) Option {
+	return func(vm *VM) {
+		vm.maxStackSize = n
+	}
+}
+
+// WithMaxRecursionDepth limits the number of function calls that can be
+// running at once to n, rather than FrameStackSize. A program that recurses
+// deeper fails with ErrStackOverflow.
+func WithMaxRecursionDepth(n int) Option {
+	return func(vm *VM) {
+		vm.maxDepth = n
+	}
+}
+
+func New(bytecode *compiler.Bytecode, opts ...Option) *VM {
+	vm := &VM{
+		constants:    bytecode.Constants,
+		modules:      bytecode.Modules,
+		linked:       bytecode.Linked(),
+		maxStackSize: StackSize,
+		maxDepth:     FrameStackSize,
+		ctx:          context.Background(),
+		rt:           &object.Runtime{},
+	}
+	for _, opt := range opts {
+		opt(vm)
+	}
+	size := initialStackSize
+	if size > vm.maxStackSize {
+		size = vm.maxStackSize
+	}
+	vm.stack = make([]object.Object, size)
+	fn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Name:         MainFunctionName,
+		Lines:        bytecode.Lines,
+	}
+	// main is pushed directly, so that it runs even with a maximum recursion
+	// depth of zero
+	vm.frames = append(vm.frames, NewFrame(&object.Closure{Fn: fn}, 0))
+	vm.framesIdx = 1
 	return vm
 }
 
 func (vm *VM) Run() error {
+	if !vm.linked {
+		return fmt.Errorf("vm: %w", ErrNotLinked)
+	}
 	if err := vm.run(); err != nil {
 		return fmt.Errorf("vm: %w", err)
 	}
 	return nil
 }
 
-var errStop = errors.New("program complete")
+var (
+	errStop = errors.New("program complete")
+
+	ErrStepLimit = errors.New("step limit exceeded")
+	ErrCanceled  = errors.New("execution canceled")
+	// ErrNotLinked is returned when running bytecode that imports modules it
+	// hasn't been linked with
+	ErrNotLinked = errors.New("bytecode is not linked")
+	// ErrStackOverflow is returned when a program runs out of stack or
+	// frames, usually by recursing too deeply
+	ErrStackOverflow = errors.New("stack overflow")
+	// ErrPanic is returned when a native function panics
+	ErrPanic = errors.New("native function panicked")
+)
+
+// cancelCheckInterval is how many instructions are executed between checks of
+// the context, since checking it on every instruction is comparatively slow
+const cancelCheckInterval = 1024
 
 func (vm *VM) run() error {
+	ctx := vm.ctx
+	if vm.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, vm.timeout)
+		defer cancel()
+	}
+	done := ctx.Done()
+
 	for {
+		if vm.stepLimit > 0 && vm.steps >= vm.stepLimit {
+			return vm.newRuntimeError(fmt.Errorf("%w: %d instructions", ErrStepLimit, vm.stepLimit))
+		}
+		if done != nil && vm.steps%cancelCheckInterval == 0 {
+			select {
+			case <-done:
+				return vm.newRuntimeError(fmt.Errorf("%w: %w", ErrCanceled, ctx.Err()))
+			default:
+			}
+		}
+		vm.steps++
+
 		if err := vm.ExecuteInstruction(); err != nil {
 			if errors.Is(err, errStop) {
 				return nil
 			}
-			return err
+			if vm.catch(err) {
+				continue
+			}
+			return vm.newRuntimeError(err)
 		}
 	}
 }
 
 func (vm *VM) Debug() error {
+	if !vm.linked {
+		return ErrNotLinked
+	}
 	r := make([]byte, 1)
 	isValid := false
 
@@ -75,7 +269,9 @@ func (vm *VM) Debug() error {
 				if errors.Is(err, errStop) {
 					return nil
 				}
-				return err
+				if !vm.catch(err) {
+					return vm.newRuntimeError(err)
+				}
 			}
 		case 's':
 			for i := 0; i < vm.sp; i++ {
@@ -86,13 +282,11 @@ func (vm *VM) Debug() error {
 				}
 			}
 		case 'g':
-			i := 0
-			for {
-				if vm.globals[i] == nil {
+			for i, obj := range vm.globals {
+				if obj == nil {
 					break
 				}
-				fmt.Println(i, "-", vm.globals[i].Inspect())
-				i++
+				fmt.Println(i, "-", obj.Inspect())
 			}
 		case 'i':
 			fmt.Printf("loc: %04d\n", vm.currentFrame().ip+1)
@@ -174,9 +368,12 @@ func (vm *VM) ExecuteInstruction() error {
 	case code.OpJump:
 		pos := int(code.ReadUint16(ins[ip+1:]))
 		vm.currentFrame().ip = pos - 1
-	case code.OpJumpNotTruthy:
-		condition := vm.pop()
-		if condition == object.False || condition == Null {
+	case code.OpJumpNotTruthy, code.OpJumpTruthy:
+		condition := object.Truthy(vm.pop())
+		if errOb, ok := condition.(*object.Error); ok {
+			return errOb
+		}
+		if (condition == object.True) == (op == code.OpJumpTruthy) {
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
 		} else {
@@ -187,11 +384,16 @@ func (vm *VM) ExecuteInstruction() error {
 	case code.OpSetGlobal:
 		idx := code.ReadUint16(ins[ip+1:])
 		vm.currentFrame().ip += 2
-		vm.globals[idx] = vm.pop()
+		vm.SetGlobal(int(idx), vm.pop())
 	case code.OpGetGlobal:
 		idx := code.ReadUint16(ins[ip+1:])
 		vm.currentFrame().ip += 2
-		if err := vm.push(vm.globals[idx]); err != nil {
+		obj := vm.Global(int(idx))
+		if obj == nil {
+			// defined in a branch that didn't run
+			obj = Null
+		}
+		if err := vm.push(obj); err != nil {
 			return fmt.Errorf("%s: %w", op, err)
 		}
 
@@ -215,6 +417,9 @@ func (vm *VM) ExecuteInstruction() error {
 		numElems := int(code.ReadUint16(ins[ip+1:]))
 		vm.currentFrame().ip += 2
 
+		if err := vm.rt.Charge(object.ArraySize(numElems)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
 		elems := make([]object.Object, 0, numElems)
 		for i := vm.sp - numElems; i < vm.sp; i++ {
 			elems = append(elems, vm.stack[i])
@@ -229,6 +434,9 @@ func (vm *VM) ExecuteInstruction() error {
 		numElems := int(code.ReadUint16(ins[ip+1:]))
 		vm.currentFrame().ip += 2
 
+		if err := vm.rt.Charge(object.MapSize(numElems)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
 		pairs := make(map[object.HashKey]object.HashPair, numElems)
 		for i := 0; i < numElems; i++ {
 			offset := vm.sp - (i * 2)
@@ -256,39 +464,35 @@ func (vm *VM) ExecuteInstruction() error {
 		if !ok {
 			return fmt.Errorf("invalid object on stack: %s is not indexable", obj.Type())
 		}
-		if err := vm.push(res.Idx(idx)); err != nil {
+		val := res.Idx(idx)
+		if exc, ok := val.(*object.Exception); ok {
+			return fmt.Errorf("%s: %w", op, exc.Err)
+		}
+		if err := vm.push(val); err != nil {
 			return fmt.Errorf("%s: %w", op, err)
 		}
 
+	case code.OpSetIndex:
+		val := vm.pop()
+		idx := vm.pop()
+		obj := vm.pop()
+
+		settable, ok := obj.(object.Settable)
+		if !ok {
+			return fmt.Errorf("invalid object on stack: %s does not support index assignment", obj.Type())
+		}
+		if err := vm.rt.Charge(object.SetSize(obj, idx)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if errOb, ok := settable.Set(idx, val).(*object.Error); ok {
+			return fmt.Errorf("%s: %w", op, errOb)
+		}
+
 		// Function
-	case code.OpCall:
+	case code.OpCall, code.OpTailCall:
 		numElems := int(code.ReadUint8(ins[ip+1:]))
 		vm.currentFrame().ip++
-
-		obj := vm.stack[vm.sp-1-numElems]
-		switch obj := obj.(type) {
-		case *object.Closure:
-			if numElems != obj.Fn.NumParams {
-				return fmt.Errorf("invalid number of args, got %d - want %d", numElems, obj.Fn.NumParams)
-			}
-
-			fr := NewFrame(obj, vm.sp-numElems)
-			vm.pushFrame(fr)
-			vm.sp = fr.basePointer + obj.Fn.NumLocals
-		case *object.Builtin:
-			args := vm.stack[vm.sp-numElems : vm.sp]
-			vm.sp = vm.sp - 1 - numElems
-			res := obj.Fn(args...)
-			if res == nil {
-				res = Null
-			}
-			if err := vm.push(res); err != nil {
-				return fmt.Errorf("%s: %w", op, err)
-			}
-
-		default:
-			return fmt.Errorf("%s: invalid object on stack: %s is not callable", op, obj.Type())
-		}
+		return vm.call(op, numElems)
 
 	case code.OpGetBuiltin:
 		builtin := int(code.ReadUint8(ins[ip+1:]))
@@ -301,6 +505,20 @@ func (vm *VM) ExecuteInstruction() error {
 			return fmt.Errorf("%s: %w", op, err)
 		}
 
+	case code.OpGetNative:
+		idx := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		if vm.registry == nil {
+			return fmt.Errorf("%s: no native functions registered", op)
+		}
+		obj, ok := vm.registry.Func(idx)
+		if !ok {
+			return fmt.Errorf("%s: invalid native function: %d", op, idx)
+		}
+		if err := vm.push(obj); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
 	case code.OpClosure:
 		constIdx := code.ReadUint16(ins[ip+1:])
 		numFree := int(code.ReadUint8(ins[ip+3:]))
@@ -311,9 +529,13 @@ func (vm *VM) ExecuteInstruction() error {
 		if !ok {
 			return fmt.Errorf("%s: invalid object on stack: %s is not callable", op, obj.Type())
 		}
-		free := make([]object.Object, numFree)
+		free := make([]*object.Upvalue, numFree)
 		for i := range free {
-			free[i] = vm.stack[vm.sp-numFree+i]
+			uv, ok := vm.stack[vm.sp-numFree+i].(*object.Upvalue)
+			if !ok {
+				return fmt.Errorf("%s: invalid object on stack: %s is not an upvalue", op, vm.stack[vm.sp-numFree+i].Type())
+			}
+			free[i] = uv
 		}
 		vm.sp = vm.sp - numFree
 
@@ -325,8 +547,8 @@ func (vm *VM) ExecuteInstruction() error {
 		freeIdx := code.ReadUint8(ins[ip+1:])
 		vm.currentFrame().ip++
 
-		currentClosure := vm.currentFrame().cl
-		if err := vm.push(currentClosure.Free[freeIdx]); err != nil {
+		uv := vm.currentFrame().cl.Free[freeIdx]
+		if err := vm.push(vm.upvalueValue(uv)); err != nil {
 			return fmt.Errorf("%s: %w", op, err)
 		}
 
@@ -334,45 +556,63 @@ func (vm *VM) ExecuteInstruction() error {
 		freeIdx := code.ReadUint8(ins[ip+1:])
 		vm.currentFrame().ip++
 
-		currentClosure := vm.currentFrame().cl
-		val := vm.pop()
-		currentClosure.Free[freeIdx] = val
-
-		// when setting a free variable, the original variable
-		// has to be set in the outer frame, too. otherwise
-		// it won't be changed. so in the compiler, we emit "Set<Scope>"
-		// codes until we get to something _not_ FreeScope-d so here, we need
-		// to, for each instruction that is SetFree, look back one frame and
-		// and set the index, continuously until we hit a local scope var
-		// TODO: might be nice to make this recursive rather than iterative?
-		df := 1
-		for {
-			curIns := code.Opcode(ins[vm.currentFrame().ip])
-			if curIns == code.OpSetLocal {
-				idx := code.ReadUint8(ins[ip+1:])
-				vm.currentFrame().ip++
-				fr := vm.frames[vm.framesIdx-df]
-				vm.stack[fr.basePointer+int(idx)] = val
-				break
-			} else if curIns == code.OpSetFree {
-				idx := code.ReadUint8(ins[ip+1:])
-				vm.currentFrame().ip++
-				fr := vm.frames[vm.framesIdx-1-df]
-				fr.cl.Free[idx] = val
-				df++
-			} else {
-				break
-			}
+		uv := vm.currentFrame().cl.Free[freeIdx]
+		if uv.Closed {
+			uv.Value = vm.pop()
+		} else {
+			vm.stack[uv.Slot] = vm.pop()
+		}
+
+	case code.OpCaptureLocal:
+		localIdx := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip++
+
+		uv := vm.captureUpvalue(vm.currentFrame().basePointer + int(localIdx))
+		if err := vm.push(uv); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+	case code.OpCloseUpvalues:
+		localIdx := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip++
+
+		vm.closeUpvalues(vm.currentFrame().basePointer + int(localIdx))
+
+	case code.OpCaptureFree:
+		freeIdx := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip++
+
+		if err := vm.push(vm.currentFrame().cl.Free[freeIdx]); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
 		}
 
 	case code.OpReturn:
-		val := vm.pop()
-		fr := vm.popFrame()
-		vm.sp = fr.basePointer - 1
-		if err := vm.push(val); err != nil {
+		if err := vm.returnValue(vm.pop()); err != nil {
 			return fmt.Errorf("%s: %w", op, err)
 		}
 
+		// Errors
+	case code.OpTry:
+		catchPos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		vm.handlers = append(vm.handlers, handler{framesIdx: vm.framesIdx, catchPos: catchPos, sp: vm.sp})
+
+	case code.OpEndTry:
+		if len(vm.handlers) == 0 {
+			return fmt.Errorf("%s: not in a try block", op)
+		}
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	case code.OpRaise:
+		switch obj := vm.pop().(type) {
+		case *object.Error:
+			return obj
+		case *object.String:
+			return &object.Error{Message: obj.Value}
+		default:
+			return fmt.Errorf("%s: cannot raise %s, must be %s or %s", op, obj.Type(), object.ErrorType, object.StringType)
+		}
+
 	default:
 		return fmt.Errorf("invalid op: %q", op)
 	}
@@ -383,9 +623,162 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIdx-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIdx] = f
+// call calls the function below the numArgs arguments on top of the stack.
+// For OpTailCall, a closure takes the place of the function that is running,
+// reusing its frame and its part of the stack, so that calls in tail position
+// don't use up frames however deep they recurse. A builtin's result is
+// returned straight away.
+func (vm *VM) call(op code.Opcode, numArgs int) error {
+	obj := vm.stack[vm.sp-1-numArgs]
+	switch obj := obj.(type) {
+	case *object.Closure:
+		if numArgs != obj.Fn.NumParams {
+			return fmt.Errorf("invalid number of args, got %d - want %d", numArgs, obj.Fn.NumParams)
+		}
+		if op == code.OpTailCall {
+			fr := vm.currentFrame()
+			if err := vm.reserveStack(fr.basePointer + obj.Fn.NumLocals); err != nil {
+				return err
+			}
+			vm.leaveHandlers()
+			vm.closeUpvalues(fr.basePointer)
+			// the closure and its arguments move down to where the running
+			// closure and its arguments are
+			copy(vm.stack[fr.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+			fr.cl = obj
+			fr.ip = -1
+			vm.clearLocals(fr.basePointer+numArgs, fr.basePointer+obj.Fn.NumLocals)
+			vm.sp = fr.basePointer + obj.Fn.NumLocals
+			return nil
+		}
+
+		fr := NewFrame(obj, vm.sp-numArgs)
+		if err := vm.reserveStack(fr.basePointer + obj.Fn.NumLocals); err != nil {
+			return err
+		}
+		if err := vm.pushFrame(fr); err != nil {
+			return err
+		}
+		vm.clearLocals(fr.basePointer+numArgs, fr.basePointer+obj.Fn.NumLocals)
+		vm.sp = fr.basePointer + obj.Fn.NumLocals
+	case *object.Builtin:
+		args := vm.stack[vm.sp-numArgs : vm.sp]
+		vm.sp = vm.sp - 1 - numArgs
+		res, err := vm.callBuiltin(obj, args)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err := vm.rt.Meter.Err(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if errOb, ok := builtins.Raised(obj, res); ok {
+			return errOb
+		}
+		if res == nil {
+			res = Null
+		}
+		if op == code.OpTailCall {
+			if err := vm.returnValue(res); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			return nil
+		}
+		if err := vm.push(res); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+	default:
+		return fmt.Errorf("%s: invalid object on stack: %s is not callable", op, obj.Type())
+	}
+	return nil
+}
+
+// returnValue returns val from the running function to its caller
+func (vm *VM) returnValue(val object.Object) error {
+	vm.leaveHandlers()
+	vm.closeUpvalues(vm.currentFrame().basePointer)
+	fr := vm.popFrame()
+	vm.sp = fr.basePointer - 1
+	return vm.push(val)
+}
+
+// leaveHandlers leaves the try blocks of the running function, when it
+// returns or is replaced by a tail call
+func (vm *VM) leaveHandlers() {
+	for n := len(vm.handlers); n > 0 && vm.handlers[n-1].framesIdx >= vm.framesIdx; n-- {
+		vm.handlers = vm.handlers[:n-1]
+	}
+}
+
+// captureUpvalue returns the open upvalue of a stack slot, making it if the
+// slot hasn't been captured yet, so that every closure capturing a variable
+// shares it
+func (vm *VM) captureUpvalue(slot int) *object.Upvalue {
+	i := sort.Search(len(vm.upvalues), func(i int) bool { return vm.upvalues[i].Slot >= slot })
+	if i < len(vm.upvalues) && vm.upvalues[i].Slot == slot {
+		return vm.upvalues[i]
+	}
+	uv := &object.Upvalue{Slot: slot}
+	vm.upvalues = append(vm.upvalues, nil)
+	copy(vm.upvalues[i+1:], vm.upvalues[i:])
+	vm.upvalues[i] = uv
+	return uv
+}
+
+// closeUpvalues closes the open upvalues of the stack slots from slot up,
+// whose frames are being left, moving the variables out of the stack
+func (vm *VM) closeUpvalues(slot int) {
+	i := sort.Search(len(vm.upvalues), func(i int) bool { return vm.upvalues[i].Slot >= slot })
+	for j, uv := range vm.upvalues[i:] {
+		uv.Value = vm.stack[uv.Slot]
+		uv.Closed = true
+		vm.upvalues[i+j] = nil
+	}
+	vm.upvalues = vm.upvalues[:i]
+}
+
+// upvalueValue returns the value of the variable uv holds
+func (vm *VM) upvalueValue(uv *object.Upvalue) object.Object {
+	if uv.Closed {
+		return uv.Value
+	}
+	return vm.stack[uv.Slot]
+}
+
+// pushFrame pushes the frame of a function being called. The frame stack
+// holds main as well, so it can be one frame deeper than maxDepth.
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIdx > vm.maxDepth {
+		return fmt.Errorf("%w: max recursion depth %d exceeded", ErrStackOverflow, vm.maxDepth)
+	}
+	if vm.framesIdx == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIdx] = f
+	}
 	vm.framesIdx++
+	return nil
+}
+
+// callBuiltin calls b with args. Natives are functions of the host program
+// rather than of joker, so a panic in one is returned as an error instead of
+// crashing the host.
+func (vm *VM) callBuiltin(b *object.Builtin, args []object.Object) (res object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %s: %v", ErrPanic, b.Name, r)
+		}
+	}()
+	return b.Fn(vm.rt, args...), nil
+}
+
+// clearLocals sets the stack slots from start to end to null, so that a local
+// read before it is set, because it was defined in a branch that didn't run,
+// isn't a value left on the stack by an earlier call
+func (vm *VM) clearLocals(start, end int) {
+	for i := start; i < end; i++ {
+		vm.stack[i] = Null
+	}
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -397,6 +790,9 @@ func (vm *VM) popFrame() *Frame {
 
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	r, l := vm.pop(), vm.pop()
+	if err := vm.rt.Charge(binarySize(op, l, r)); err != nil {
+		return err
+	}
 	var res object.Object
 	switch op {
 	case code.OpAdd:
@@ -457,9 +853,33 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		return fmt.Errorf("invalid op: %q", op)
 
 	}
+	if errOb, ok := res.(*object.Error); ok {
+		return errOb
+	}
 	return vm.push(res)
 }
 
+// binarySize returns the size of the string created by a binary operation on
+// l and r, so it can be charged before the string is allocated. Other results
+// are small enough not to be worth counting.
+func binarySize(op code.Opcode, l, r object.Object) int {
+	left, ok := l.(*object.String)
+	if !ok {
+		return 0
+	}
+	switch right := r.(type) {
+	case *object.String:
+		if op == code.OpAdd {
+			return object.StringSize(len(left.Value) + len(right.Value))
+		}
+	case *object.Integer:
+		if op == code.OpMult && right.Value > 0 {
+			return object.RepeatSize(len(left.Value), int(right.Value))
+		}
+	}
+	return 0
+}
+
 func (vm *VM) executePrefixOperator(op code.Opcode) error {
 	r := vm.pop()
 	var res object.Object
@@ -479,6 +899,9 @@ func (vm *VM) executePrefixOperator(op code.Opcode) error {
 	default:
 		return fmt.Errorf("invalid op: %q", op)
 	}
+	if errOb, ok := res.(*object.Error); ok {
+		return errOb
+	}
 	return vm.push(res)
 }
 
@@ -490,13 +913,66 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
+// Global returns the value of the global at idx, or nil if it has not been set
+func (vm *VM) Global(idx int) object.Object {
+	if idx >= len(vm.globals) {
+		return nil
+	}
+	return vm.globals[idx]
+}
+
+// SetGlobal sets the value of the global at idx
+func (vm *VM) SetGlobal(idx int, obj object.Object) {
+	if idx >= len(vm.globals) {
+		// at least doubled, so that setting globals in order doesn't copy them
+		// every time
+		n := 2 * len(vm.globals)
+		if n <= idx {
+			n = idx + 1
+		}
+		if n > GlobalSize {
+			n = GlobalSize
+		}
+		globals := make([]object.Object, n)
+		copy(globals, vm.globals)
+		vm.globals = globals
+	}
+	vm.globals[idx] = obj
+}
+
 func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.sp >= len(vm.stack) {
+		return nil
+	}
 	return vm.stack[vm.sp]
 }
 
+// reserveStack grows the stack, if it must, so that it has room for n values
+func (vm *VM) reserveStack(n int) error {
+	if n <= len(vm.stack) {
+		return nil
+	}
+	if n > vm.maxStackSize {
+		return fmt.Errorf("%w: max stack size %d exceeded", ErrStackOverflow, vm.maxStackSize)
+	}
+	size := 2 * len(vm.stack)
+	if size < n {
+		size = n
+	}
+	if size > vm.maxStackSize {
+		size = vm.maxStackSize
+	}
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
 func (vm *VM) push(obj object.Object) error {
-	if vm.sp > StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp == len(vm.stack) {
+		if err := vm.reserveStack(vm.sp + 1); err != nil {
+			return err
+		}
 	}
 	vm.stack[vm.sp] = obj
 	vm.sp++
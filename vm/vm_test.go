@@ -1,14 +1,24 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jimmykodes/joker/ast"
+	"github.com/jimmykodes/joker/builtins"
 	"github.com/jimmykodes/joker/compiler"
 	"github.com/jimmykodes/joker/lexer"
 	"github.com/jimmykodes/joker/object"
 	"github.com/jimmykodes/joker/parser"
+	"github.com/jimmykodes/joker/sandbox"
 )
 
 type vmTestCase struct {
@@ -46,25 +56,199 @@ func TestClosure(t *testing.T) {
       `,
 			expected: 30,
 		},
-		// this test has a recursion failure that I haven't figured out how to solve yet...
-		// {
-		// 	input: `
-		//     fn add(a) {
-		//       acc := 0;
-		//       return fn(b) {
-		//         return fn() {
-		//           acc = acc + a + b;
-		//           return acc;
-		//         }
-		//       }
-		//     }
-		//     let adderA = add(10);
-		//     let adderB = adderA(5);
-		//     adderB();
-		//     adderB();
-		//     `,
-		// 	expected: 30,
-		// },
+		{
+			input: `
+      fn add(a) {
+        acc := 0;
+        return fn(b) {
+          return fn() {
+            acc = acc + a + b;
+            return acc;
+          }
+        }
+      }
+      let adderA = add(10);
+      let adderB = adderA(5);
+      adderB();
+      adderB();
+      `,
+			expected: 30,
+		},
+		{
+			// closures made by separate calls have variables of their own
+			input: `
+      fn counter() {
+        let n = 0;
+        return fn() {
+          n = n + 1;
+          return n;
+        };
+      }
+      let a = counter();
+      let b = counter();
+      a();
+      a();
+      b();
+      `,
+			expected: 1,
+		},
+		{
+			// closures capturing the same variable share it
+			input: `
+      fn pair() {
+        let n = 0;
+        let inc = fn() { n = n + 1; };
+        let get = fn() { return n; };
+        return [inc, get];
+      }
+      let p = pair();
+      p[0]();
+      p[0]();
+      p[1]();
+      `,
+			expected: 2,
+		},
+		{
+			input: `
+      fn f() {
+        let n = 1;
+        let set = fn() { n = 5; };
+        set();
+        return n;
+      }
+      f();
+      `,
+			expected: 5,
+		},
+		{
+			input: `
+      fn f() {
+        let n = 1;
+        let get = fn() { return n; };
+        n = 2;
+        return get();
+      }
+      f();
+      `,
+			expected: 2,
+		},
+		{
+			input: `
+      fn f() {
+        let n = 0;
+        let outer = fn() {
+          return fn() {
+            n = n + 10;
+            return n;
+          };
+        };
+        let inner = outer();
+        inner();
+        return [inner(), n];
+      }
+      f();
+      `,
+			expected: []any{20, 20},
+		},
+		{
+			// the frame of the closure's variable is replaced by a tail call
+			input: `
+      fn id(x) { return x; }
+      fn make(n) {
+        let get = fn() { return n; };
+        return id(get);
+      }
+      let get = make(4);
+      get();
+      `,
+			expected: 4,
+		},
+		{
+			// the frame of the closure's variable is left by raising an error
+			input: `
+      let get = 0;
+      fn f() {
+        let n = 3;
+        get = fn() { return n; };
+        raise "boom";
+      }
+      try { f(); } catch { }
+      get();
+      `,
+			expected: 3,
+		},
+		{
+			// each iteration of a loop has variables of its own
+			input: `
+      fn f() {
+        let fs = [];
+        let i = 0;
+        while i < 3 {
+          j := i;
+          fs = append(fs, fn() { return j; });
+          i = i + 1;
+        }
+        return [fs[0](), fs[1](), fs[2]()];
+      }
+      f();
+      `,
+			expected: []any{0, 1, 2},
+		},
+		{
+			input: `
+      fn f() {
+        let fs = [];
+        for i := 0; i < 4; i = i + 1; {
+          j := i * 10;
+          if i == 1 { continue; }
+          fs = append(fs, fn() { return j; });
+          if i == 2 { j = j + 1; continue; }
+          j = j + 2;
+        }
+        return [fs[0](), fs[1](), fs[2]()];
+      }
+      f();
+      `,
+			expected: []any{2, 21, 32},
+		},
+		{
+			// the variable of the last iteration is closed when breaking out
+			input: `
+      fn f() {
+        let get = 0;
+        while true {
+          j := 1;
+          get = fn() { return j; };
+          break;
+        }
+        j = 5;
+        return get();
+      }
+      f();
+      `,
+			expected: 1,
+		},
+		{
+			input: `
+      fn f() {
+        let fs = [];
+        let i = 0;
+        while i < 2 {
+          a := i;
+          let k = 0;
+          while k < 2 {
+            b := k;
+            fs = append(fs, fn() { return a * 10 + b; });
+            k = k + 1;
+          }
+          i = i + 1;
+        }
+        return [fs[0](), fs[1](), fs[2](), fs[3]()];
+      }
+      f();
+      `,
+			expected: []any{0, 1, 10, 11},
+		},
 	}
 	runVmTests(t, tests)
 }
@@ -100,23 +284,71 @@ func TestRecursion(t *testing.T) {
       `,
 			expected: 0,
 		},
-		// TODO: this type of recursion breaks, and I don't
-		// like the book's fix for it, so until I come up with another
-		// one, i'm going to leave this case broken
-		// {
-		// 	input: `
-		//     fn wrapper() {
-		//       fn recursion(a) {
-		//         if a == 0 {
-		//           return 0;
-		//         }
-		//         return recursion(a - 1);
-		//       }
-		//       return recursion(10);
-		//     }
-		//     `,
-		// 	expected: 0,
-		// },
+		{
+			input: `
+      fn wrapper() {
+        fn recursion(a) {
+          if a == 0 {
+            return 0;
+          }
+          return recursion(a - 1);
+        }
+        return recursion(10);
+      }
+      wrapper();
+      `,
+			expected: 0,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			// deeper than FrameStackSize
+			input: `
+      fn sum(n, acc) {
+        if n == 0 {
+          return acc;
+        }
+        return sum(n - 1, acc + n);
+      }
+      sum(100000, 0);
+      `,
+			expected: 5000050000,
+		},
+		{
+			input: `
+      let odd = 0;
+      fn even(n) {
+        if n == 0 { return true; }
+        return odd(n - 1);
+      }
+      odd = fn(n) {
+        if n == 0 { return false; }
+        return even(n - 1);
+      };
+      odd(5001);
+      `,
+			expected: true,
+		},
+		{
+			input: `
+      fn inc(n) { return n + 1; }
+      fn twice(n) {
+        let x = n * 2;
+        let y = x;
+        return inc(y);
+      }
+      twice(3) + 1;
+      `,
+			expected: 8,
+		},
+		{`fn size(x) { return len(x); } size([1, 2, 3]) + 1`, 4},
+		{`fn f(g) { try { return g(); } catch e { return e["message"]; } } f(fn() { raise "boom"; })`, "boom"},
+		{`fn f(g) { try { raise "boom"; } catch e { return g(e["message"]); } } f(fn(m) { return m + "!"; })`, "boom!"},
+		{`fn f(c) { if c { return 1; } } f(false)`, Null},
 	}
 	runVmTests(t, tests)
 }
@@ -145,6 +377,485 @@ func TestBuiltinCall(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTryCatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`let r = ""; try { raise "boom"; } catch e { r = e["message"]; } r`, "boom"},
+		{`let r = ""; try { throw error("boom", "Custom"); } catch e { r = e["kind"]; } r`, "Custom"},
+		{`let r = 0; try { r = 1; } catch e { r = 2; } r`, 1},
+		{`let r = ""; try { let m = {}; m["x"]; } catch e { r = e["message"]; } r`, "key not present"},
+		{`let r = ""; try { 1 + "a"; } catch e { r = e["message"]; } r`, "unsupported type for operation"},
+		{`let r = ""; try { len(1); } catch e { r = e["message"]; } r`, "len() not supported on IntegerType"},
+		{`let r = ""; let z = 0; try { 1 / z; } catch e { r = e["message"]; } r`, "division by zero"},
+		{`let r = ""; let z = 0; try { 1 % z; } catch e { r = e["message"]; } r`, "division by zero"},
+		{`let r = ""; try { 1 / 0; } catch e { r = e["message"]; } r`, "division by zero"},
+		{
+			`fn f(n) { if n == 0 { raise error("deep", "Depth"); } return f(n - 1) + 1; }
+			let r = ""; try { f(5); } catch e { r = e["kind"] + ": " + e["message"]; } r`,
+			"Depth: deep",
+		},
+		{
+			`let r = "";
+			try { try { raise "inner"; } catch e { raise error("outer", "", e); } } catch e { r = e["message"] + "/" + e["cause"]["message"]; }
+			r`,
+			"outer/inner",
+		},
+		{`fn f() { try { raise "x"; } catch { return 2; } return 0; } 1 + f()`, 3},
+		{`fn f() { let a = 1; try { let b = 2; raise "x"; } catch e { a = a + 10; } return a; } f()`, 11},
+		{
+			`fn f() { try { return 1; } catch e { return 2; } }
+			let r = f(); try { raise "x"; } catch e { r = r + 10; } r`,
+			11,
+		},
+		{
+			`let i = 0; while true { try { i = i + 1; if i == 3 { break; } } catch e { } }
+			let r = 0; try { raise "x"; } catch e { r = i; } r`,
+			3,
+		},
+		{
+			`let i = 0; let n = 0; while i < 3 { i = i + 1; try { if i == 2 { continue; } n = n + 1; } catch e { } }
+			let r = 0; try { raise "x"; } catch e { r = n; } r`,
+			2,
+		},
+		{`let e = error("msg", "Kind"); e["kind"]`, "Kind"},
+		{`let e = error("msg"); e["cause"]`, Null},
+		{`error("msg", "Kind", error("cause"))`, &object.Error{Message: "msg"}},
+		// errors held in containers are values, not raised when indexed
+		{`let errs = [error("a"), error("b")]; errs[1]["message"]`, "b"},
+		{`let m = {"e": error("a")}; pop(m, "e")["message"]`, "a"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRaise(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`raise error("boom", "Custom");`, "Custom: boom"},
+		{`fn f() { raise "boom"; } f();`, "boom"},
+		{`let z = 0; 1 / z;`, "OpDiv: division by zero"},
+		{`1 % 0;`, "OpMod: division by zero"},
+		{`raise 1;`, "OpRaise: cannot raise IntegerType, must be ErrorType or StringType"},
+		{`error(1);`, "invalid type for error message: must be StringType - got IntegerType"},
+		{`try { raise "x"; } catch e { raise e; }`, "x"},
+		// the handler of a try block is gone once the function it's in returns
+		{`fn f() { try { return 1; } catch e { return 2; } } f(); raise "after";`, "after"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err := New(comp.Bytecode()).Run()
+			var rtErr *RuntimeError
+			if !errors.As(err, &rtErr) {
+				t.Fatalf("expected runtime error: got %v", err)
+			}
+			if got := rtErr.Err.Error(); got != tt.want {
+				t.Errorf("invalid error: got %q - want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNativeCall(t *testing.T) {
+	r := builtins.NewRegistry()
+	err := r.Register("double", func(rt *object.Runtime, args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	if err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+	err = r.RegisterModule("str", map[string]object.BuiltinFunction{
+		"upper": func(rt *object.Runtime, args ...object.Object) object.Object {
+			return &object.String{Value: strings.ToUpper(args[0].(*object.String).Value)}
+		},
+		"repeat": func(rt *object.Runtime, args ...object.Object) object.Object {
+			s := args[0].(*object.String).Value
+			return &object.String{Value: strings.Repeat(s, int(args[1].(*object.Integer).Value))}
+		},
+	})
+	if err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+
+	tests := []vmTestCase{
+		{`double(21)`, 42},
+		{`str.upper("joker")`, "JOKER"},
+		{`let f = str.repeat; f("ab", double(2))`, "abababab"},
+		{`fn up(s) { return str.upper(s) + "!"; } up("hi")`, "HI!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			comp := compiler.New(compiler.WithRegistry(r))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			vm := New(comp.Bytecode(), WithRegistry(r))
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+		})
+	}
+
+	t.Run("missing registry", func(t *testing.T) {
+		comp := compiler.New(compiler.WithRegistry(r))
+		if err := comp.Compile(parse(`double(1)`)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := New(comp.Bytecode()).Run(); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+func TestImports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"counter.jk": `let n = 0; fn incr() { n = n + 1; return n; }`,
+		"a.jk":       `import "counter"; counter.incr();`,
+		"shapes.jk":  `let sides = {"square": 4}; fn count(shape) { return sides[shape]; }`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []vmTestCase{
+		{`import "shapes"; shapes.count("square")`, 4},
+		{`import "shapes"; let f = shapes.count; f("square")`, 4},
+		{`import "shapes"; fn f() { return shapes.sides; } f()["square"]`, 4},
+		// a module is only run once, however many files import it
+		{`import "a"; import "counter"; counter.incr()`, 2},
+		// modules have their own globals
+		{`let n = 10; import "counter"; counter.incr() + n`, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			comp := compiler.New(compiler.WithFilename(filepath.Join(dir, "main.jk")))
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			bytecode, err := comp.Link()
+			if err != nil {
+				t.Fatalf("link error: %s", err)
+			}
+			vm := New(bytecode)
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+		})
+	}
+
+	t.Run("unlinked", func(t *testing.T) {
+		comp := compiler.New(compiler.WithFilename(filepath.Join(dir, "main.jk")))
+		if err := comp.Compile(parse(`import "counter"; counter.incr()`)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if err := New(comp.Bytecode()).Run(); !errors.Is(err, ErrNotLinked) {
+			t.Errorf("invalid error: got %v - want %s", err, ErrNotLinked)
+		}
+	})
+
+	t.Run("trace", func(t *testing.T) {
+		comp := compiler.New(compiler.WithFilename(filepath.Join(dir, "main.jk")))
+		if err := comp.Compile(parse("import \"shapes\";\nshapes.count(1);")); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode, err := comp.Link()
+		if err != nil {
+			t.Fatalf("link error: %s", err)
+		}
+		err = New(bytecode).Run()
+		var rtErr *RuntimeError
+		if !errors.As(err, &rtErr) {
+			t.Fatalf("expected runtime error: got %v", err)
+		}
+		want := []TraceEntry{
+			{Function: "count", Module: "shapes.jk", Line: 1},
+			{Function: MainFunctionName, Module: "main.jk", Line: 2},
+		}
+		if !reflect.DeepEqual(rtErr.Trace, want) {
+			t.Errorf("invalid trace: got %+v - want %+v", rtErr.Trace, want)
+		}
+	})
+}
+
+func TestIO(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		stdin    string
+		stdout   string
+		stderr   string
+		expected any
+	}{
+		{name: "print", input: `print("out", 1)`, stdout: "out 1\n", expected: Null},
+		{name: "eprint", input: `eprint("err", 1)`, stderr: "err 1\n", expected: Null},
+		{name: "input", input: `input("name? ")`, stdin: "joker\nnext\n", stdout: "name? ", expected: "joker"},
+		{name: "readline", input: `readline(); readline()`, stdin: "first\r\nsecond", expected: "second"},
+		{name: "end of input", input: `input()`, expected: Null},
+		{name: "print unset", input: `if false { y := 1; } print(y)`, stdout: "null\n", expected: Null},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			var stdout, stderr bytes.Buffer
+			vm := New(comp.Bytecode(), WithStdin(strings.NewReader(tt.stdin)), WithStdout(&stdout), WithStderr(&stderr))
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+			if got := stdout.String(); got != tt.stdout {
+				t.Errorf("invalid stdout: got %q - want %q", got, tt.stdout)
+			}
+			if got := stderr.String(); got != tt.stderr {
+				t.Errorf("invalid stderr: got %q - want %q", got, tt.stderr)
+			}
+		})
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	mem := sandbox.NewMemFS()
+	mem.WriteFile("in.txt", []byte("hello"))
+	tests := []struct {
+		name     string
+		input    string
+		perms    *sandbox.Permissions
+		expected any
+	}{
+		{
+			name:     "read",
+			input:    `read(open("in.txt"))`,
+			perms:    &sandbox.Permissions{FS: mem},
+			expected: "hello",
+		},
+		{
+			name:     "write",
+			input:    `let f = open("out.txt", "w"); write(f, "out"); close(f); read(open("out.txt"))`,
+			perms:    &sandbox.Permissions{FS: mem},
+			expected: "out\n",
+		},
+		{
+			name:     "no filesystem",
+			input:    `let res = 0; try { open("in.txt"); } catch e { res = e; } res`,
+			perms:    &sandbox.Permissions{},
+			expected: &object.Error{Message: "open in.txt: permission denied"},
+		},
+		{
+			name:     "read only",
+			input:    `let res = 0; try { open("in.txt", "a"); } catch e { res = e; } res`,
+			perms:    &sandbox.Permissions{FS: mem, ReadOnly: true},
+			expected: &object.Error{Message: "append in.txt: permission denied"},
+		},
+		{
+			name:     "argv",
+			input:    `argv()`,
+			perms:    &sandbox.Permissions{Args: []string{"script", "arg"}},
+			expected: []any{"script", "arg"},
+		},
+		{
+			name:     "no argv",
+			input:    `let res = 0; try { argv(); } catch e { res = e; } res`,
+			perms:    &sandbox.Permissions{},
+			expected: &object.Error{Message: "argv: permission denied"},
+		},
+		{
+			name:     "no stdin",
+			input:    `let res = 0; try { input(); } catch e { res = e; } res`,
+			perms:    &sandbox.Permissions{},
+			expected: &object.Error{Message: "stdin: permission denied"},
+		},
+		{
+			name:     "no stderr",
+			input:    `let res = 0; try { eprint("hello"); } catch e { res = e; } res`,
+			perms:    &sandbox.Permissions{},
+			expected: &object.Error{Message: "stderr: permission denied"},
+		},
+		{
+			name:     "no stdout",
+			input:    `let res = 0; try { print("hello"); } catch e { res = e; } res`,
+			perms:    &sandbox.Permissions{},
+			expected: &object.Error{Message: "stdout: permission denied"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			vm := New(comp.Bytecode(), WithPermissions(tt.perms))
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+		})
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	run := func(input string, opts ...Option) error {
+		t.Helper()
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		return New(comp.Bytecode(), opts...).Run()
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		input string
+		opts  []Option
+		errs  []error
+	}{
+		{
+			name:  "step limit",
+			input: "while true { }",
+			opts:  []Option{WithStepLimit(1000)},
+			errs:  []error{ErrStepLimit},
+		},
+		{
+			name:  "step limit in function",
+			input: "fn loop() { let x = 0; while true { x = x + 1; } } loop();",
+			opts:  []Option{WithStepLimit(1000)},
+			errs:  []error{ErrStepLimit},
+		},
+		{
+			name:  "under step limit",
+			input: "let x = 0; while x < 10 { x = x + 1; }",
+			opts:  []Option{WithStepLimit(1000)},
+		},
+		{
+			name:  "canceled",
+			input: "while true { }",
+			opts:  []Option{WithContext(canceled)},
+			errs:  []error{ErrCanceled, context.Canceled},
+		},
+		{
+			name:  "timeout",
+			input: "while true { }",
+			opts:  []Option{WithTimeout(10 * time.Millisecond)},
+			errs:  []error{ErrCanceled, context.DeadlineExceeded},
+		},
+		{
+			name:  "string concat",
+			input: `let s = "x"; while true { s = s + s; }`,
+			opts:  []Option{WithMemoryLimit(1 << 20)},
+			errs:  []error{object.ErrMemoryLimit},
+		},
+		{
+			name:  "append",
+			input: "let arr = []; while true { arr = append(arr, 1); }",
+			opts:  []Option{WithMemoryLimit(1 << 20)},
+			errs:  []error{object.ErrMemoryLimit},
+		},
+		{
+			name:  "array literal",
+			input: "let arr = [1, 2, 3, 4, 5];",
+			opts:  []Option{WithMemoryLimit(64)},
+			errs:  []error{object.ErrMemoryLimit},
+		},
+		{
+			name:  "map literal",
+			input: `let m = {"a": 1, "b": 2};`,
+			opts:  []Option{WithMemoryLimit(64)},
+			errs:  []error{object.ErrMemoryLimit},
+		},
+		{
+			name:  "map index assignment",
+			input: "let m = {}; let i = 0; while i < 1000000 { m[i] = i; i = i + 1; }",
+			opts:  []Option{WithMemoryLimit(1 << 20)},
+			errs:  []error{object.ErrMemoryLimit},
+		},
+		{
+			name:  "map set",
+			input: "let m = {}; let i = 0; while i < 1000000 { set(m, i, i); i = i + 1; }",
+			opts:  []Option{WithMemoryLimit(1 << 20)},
+			errs:  []error{object.ErrMemoryLimit},
+		},
+		{
+			name:  "step limit in try",
+			input: "try { while true { } } catch e { }",
+			opts:  []Option{WithStepLimit(1000)},
+			errs:  []error{ErrStepLimit},
+		},
+		{
+			name:  "memory limit in try",
+			input: `let s = "x"; try { while true { s = s + s; } } catch e { }`,
+			opts:  []Option{WithMemoryLimit(1 << 20)},
+			errs:  []error{object.ErrMemoryLimit},
+		},
+		{
+			name:  "stack overflow",
+			input: "fn deep(n) { return 1 + deep(n + 1); } deep(0);",
+			errs:  []error{ErrStackOverflow},
+		},
+		{
+			name:  "max recursion depth",
+			input: "fn deep(n) { if n == 0 { return 0; } return 1 + deep(n - 1); } deep(10);",
+			opts:  []Option{WithMaxRecursionDepth(10)},
+			errs:  []error{ErrStackOverflow},
+		},
+		{
+			name:  "under max recursion depth",
+			input: "fn deep(n) { if n == 0 { return 0; } return 1 + deep(n - 1); } deep(9);",
+			opts:  []Option{WithMaxRecursionDepth(10)},
+		},
+		{
+			name:  "max stack size",
+			input: "let arr = [1, 2, 3, 4, 5, 6, 7, 8, 9, 10];",
+			opts:  []Option{WithMaxStackSize(8)},
+			errs:  []error{ErrStackOverflow},
+		},
+		{
+			name:  "under max stack size",
+			input: "let arr = [1, 2, 3, 4, 5, 6, 7, 8];",
+			opts:  []Option{WithMaxStackSize(8)},
+		},
+		{
+			// the stack grows past its initial size
+			name:  "deep recursion",
+			input: "fn deep(n) { if n == 0 { return 0; } return 1 + deep(n - 1); } deep(10000);",
+		},
+		{
+			// replacing a key doesn't grow the map
+			name:  "map reassignment",
+			input: "let m = {}; let i = 0; while i < 1000000 { m[0] = i; i = i + 1; }",
+			opts:  []Option{WithMemoryLimit(1 << 20)},
+		},
+		{
+			name:  "under memory limit",
+			input: `let arr = []; let i = 0; while i < 10 { arr = append(arr, string(i) + "!"); i = i + 1; }`,
+			opts:  []Option{WithMemoryLimit(1 << 20)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := run(tt.input, tt.opts...)
+			if len(tt.errs) == 0 && err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			for _, want := range tt.errs {
+				if !errors.Is(err, want) {
+					t.Errorf("invalid error: got %v - want %s", err, want)
+				}
+			}
+		})
+	}
+}
+
 func TestFuncCall(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -219,6 +930,21 @@ func TestIndex(t *testing.T) {
 		{"{2:12}[1+1]", 12},
 		{"[4, 5, 6][0]", 4},
 		{"[4, 5, 6][1+1]", 6},
+		{`let r = ""; try { [4, 5, 6][-1]; } catch e { r = e["message"]; } r`, "index out of range [-1] with length 3"},
+		{`let r = ""; try { "abc"[-1]; } catch e { r = e["message"]; } r`, "index out of range [-1] with length 3"},
+		{`let r = ""; try { "abc"[3]; } catch e { r = e["message"]; } r`, "index out of range [3] with length 3"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = [1, 2, 3]; x[0] = 12; x;", []any{12, 2, 3}},
+		{"let x = [1, 2, 3]; x[1+1] = x[0] * 2; x[2];", 2},
+		{`let m = {}; m["foo"] = 12; m["foo"];`, 12},
+		{`let m = {"a": [1, 2]}; m["a"][0] = 5; m["a"];`, []any{5, 2}},
+		{`fn f() { x := [0]; x[0] = 1; return x[0]; } f();`, 1},
+		{`let r = ""; let x = [1, 2, 3]; try { x[-1] = 5; } catch e { r = e["message"]; } r`, "index out of range [-1] with length 3"},
 	}
 	runVmTests(t, tests)
 }
@@ -251,6 +977,11 @@ func TestGlobalVariableDefs(t *testing.T) {
 		{"one := 1; two := 2; one + two", 3},
 		{"one := 1; two := one + one; one + two", 3},
 		{"one := 1; two := 2; three := 0; three = one + two;", 3},
+		// defined in a branch that didn't run
+		{"if false { y := 1; } y;", Null},
+		{"fn f() { if false { y := 1; } return y; } f();", Null},
+		// not the value left by an earlier call
+		{"fn f(set) { if set { y := 1; } return y; } f(true); f(false);", Null},
 	}
 	runVmTests(t, tests)
 }
@@ -386,6 +1117,32 @@ func TestConditionals(t *testing.T) {
 		{"let x = 0; if true { x = 1; } else { x = 2; }; x;", 1},
 		{"let x = 0; if false { x = 1; } else { x = 2; }; x;", 2},
 		{"let x = 0; if 1 > 2 { x = 1; }; x;", 0},
+		{"let x = 0; if 1 > 2 { x = 1; } else if 2 > 1 { x = 2; } else { x = 3; }; x;", 2},
+		{"let x = 0; if 1 > 2 { x = 1; } else if 1 > 3 { x = 2; } else { x = 3; }; x;", 3},
+		{"let x = 0; if false { x = 1; } else if false { x = 2; }; x;", 0},
+		{`let x = if 1 > 2 { "a" } else if 2 > 1 { "b" } else { "c" }; x;`, "b"},
+		{`let x = if false { "a" } else if false { "b" }; x;`, Null},
+		{`let x = if true { let y = 1; }; x;`, Null},
+		{`fn f(a) { return if a > 10 { "big" } else if a > 5 { "medium" } else { "small" }; } f(6);`, "medium"},
+		{`fn f(a) { if a > 10 { return 1; } else if a > 5 { return 2; } return 3; } f(1);`, 3},
+	}
+	runVmTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || false", false},
+		{"false || true", true},
+		{"true || false", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"not true || not false", true},
+		{"let x = 5; 0 < x && x <= 10", true},
+		{"let x = 0; fn inc() { x = x + 1; return true; } false && inc(); true || inc(); x;", 0},
+		{"let x = 0; fn inc() { x = x + 1; return true; } true && inc(); false || inc(); x;", 2},
 	}
 	runVmTests(t, tests)
 }
@@ -488,27 +1245,152 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestConstantFolding(t *testing.T) {
+	tests := []vmTestCase{
+		{"-5 + 2", -3},
+		{"60 * 60 * 24", 86400},
+		{"7 % 3 - 10 / 4", -1},
+		{"1.5 * 2 + 1", 4.0},
+		{"1 / 2.0", 0.5},
+		{`"a" + "b" + "c"`, "abc"},
+		{"!true", false},
+		{"!!0", false},
+		{"1 < 2 == true", true},
+		{"2 <= 1.5", false},
+		{"(1 + 2) * 3 != 9", false},
+		{`let x = 2; x * (3 + 4)`, 14},
+	}
+	runVmTests(t, tests)
+
+	// expressions that raise errors raise them when the program runs
+	errorTests := []string{
+		`"a" * 2`,
+		`1 + "a"`,
+		`-"a"`,
+	}
+	for _, input := range errorTests {
+		t.Run(input, func(t *testing.T) {
+			var msgs []string
+			for _, optimize := range []bool{false, true} {
+				comp := compiler.New(compiler.WithOptimize(optimize))
+				if err := comp.Compile(parse(input)); err != nil {
+					t.Fatalf("compiler error: %s", err)
+				}
+				err := New(comp.Bytecode()).Run()
+				if err == nil {
+					t.Fatalf("expected an error (optimize=%t)", optimize)
+				}
+				msgs = append(msgs, err.Error())
+			}
+			if msgs[0] != msgs[1] {
+				t.Errorf("optimizing changed the error: got %q - want %q", msgs[1], msgs[0])
+			}
+		})
+	}
+}
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	input := `
+fn get(a, b) {
+  return a[b];
+}
+fn wrapper() {
+  x := get(1, 0);
+  return x;
+}
+wrapper();
+`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bc := comp.Bytecode()
+
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	var decoded compiler.Bytecode
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+
+	want := []TraceEntry{
+		{Function: "get", Line: 3},
+		{Function: "wrapper", Line: 6},
+		{Function: MainFunctionName, Line: 9},
+	}
+	for name, bytecode := range map[string]*compiler.Bytecode{"compiled": bc, "decoded": &decoded} {
+		t.Run(name, func(t *testing.T) {
+			err := New(bytecode).Run()
+			var rtErr *RuntimeError
+			if !errors.As(err, &rtErr) {
+				t.Fatalf("expected runtime error: got %v", err)
+			}
+			if !reflect.DeepEqual(rtErr.Trace, want) {
+				t.Errorf("invalid trace: got %+v - want %+v", rtErr.Trace, want)
+			}
+		})
+	}
+}
+
+func TestRuntimeError_Error(t *testing.T) {
+	f := TraceEntry{Function: "f", Module: "main.jk", Line: 2}
+	g := TraceEntry{Function: "g", Module: "main.jk", Line: 5}
+	trace := []TraceEntry{f, f, f, g, {Function: MainFunctionName, Module: "main.jk", Line: 7}}
+	err := &RuntimeError{Err: fmt.Errorf("%w: max recursion depth 3 exceeded", ErrStackOverflow), Trace: trace}
+	want := `main.jk:2: stack overflow: max recursion depth 3 exceeded
+	at f (main.jk:2)
+	... repeated 2 more times
+	at g (main.jk:5)
+	at <main> (main.jk:7)`
+	if err.Error() != want {
+		t.Errorf("invalid error message:\ngot  %s\nwant %s", err.Error(), want)
+	}
+
+	trace = nil
+	for i := 0; i < maxTraceLines+10; i++ {
+		trace = append(trace, TraceEntry{Function: "f", Line: i + 1})
+	}
+	err = &RuntimeError{Err: ErrStackOverflow, Trace: trace}
+	if got := strings.Count(err.Error(), "\n\tat "); got != maxTraceLines {
+		t.Errorf("invalid number of trace lines: got %d - want %d", got, maxTraceLines)
+	}
+	if !strings.HasSuffix(err.Error(), "\n\t... 10 more") {
+		t.Errorf("invalid end of error message: %s", err.Error())
+	}
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			program := parse(tt.input)
+			// optimizations must not change what a program does, so every
+			// program is run with and without them
+			for _, optimize := range []bool{false, true} {
+				program := parse(tt.input)
 
-			comp := compiler.New()
-			if err := comp.Compile(program); err != nil {
-				t.Errorf("compiler error: %s", err)
-				return
-			}
+				comp := compiler.New(compiler.WithOptimize(optimize))
+				if err := comp.Compile(program); err != nil {
+					t.Errorf("compiler error (optimize=%t): %s", optimize, err)
+					return
+				}
+				// everything the compiler builds must pass the verifier
+				if err := comp.Bytecode().Verify(); err != nil {
+					t.Errorf("verify error (optimize=%t): %s", optimize, err)
+					return
+				}
 
-			vm := New(comp.Bytecode())
-			if err := vm.Run(); err != nil {
-				t.Errorf("vm error: %s", err)
-				return
-			}
+				vm := New(comp.Bytecode())
+				if err := vm.Run(); err != nil {
+					t.Errorf("vm error (optimize=%t): %s", optimize, err)
+					return
+				}
 
-			stackElem := vm.LastPoppedStackElem()
-			testExpectedObject(t, tt.expected, stackElem)
+				stackElem := vm.LastPoppedStackElem()
+				testExpectedObject(t, tt.expected, stackElem)
+			}
 		})
 	}
 }
@@ -540,6 +1422,10 @@ func testExpectedObject(t *testing.T, want any, got object.Object) {
 		testArrayObject(t, want, got)
 	case map[any]any:
 		testMapObject(t, want, got)
+	case *object.Error:
+		if errOb, ok := got.(*object.Error); !ok || errOb.Message != want.Message {
+			t.Errorf("invalid error: got %T (%v) - want %s", got, got, want.Message)
+		}
 	default:
 		t.Errorf("missing test for type: %T", want)
 